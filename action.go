@@ -5,12 +5,15 @@ type ActionType int
 const (
 	ActionTypeInsert ActionType = 1
 	ActionTypeRemove            = -1
+	// Actions undone and redone as one, see Buffer.BeginUndoGroup
+	ActionTypeGroup = 0
 )
 
 type Action struct {
-	Typ  ActionType
-	Loc  *Location
-	Data []rune
+	Typ     ActionType
+	Loc     *Location
+	Data    []rune
+	Actions []*Action // for groups
 }
 
 func NewAction(typ ActionType, loc *Location, data []rune) *Action {
@@ -18,23 +21,59 @@ func NewAction(typ ActionType, loc *Location, data []rune) *Action {
 }
 
 func (a *Action) Apply(b *Buffer) {
+	if a.Typ == ActionTypeGroup {
+		for _, ga := range a.Actions {
+			ga.Apply(b)
+		}
+		return
+	}
 	a.Do(b, a.Typ)
 	b.Modified = true
 	hook_trigger_buffer("modified", b)
 }
 
 func (a *Action) Revert(b *Buffer) {
+	if a.Typ == ActionTypeGroup {
+		for i := len(a.Actions) - 1; i >= 0; i-- {
+			a.Actions[i].Revert(b)
+		}
+		return
+	}
 	a.Do(b, -a.Typ)
 	b.Modified = true
 	hook_trigger_buffer("modified", b)
 }
 
+// Functions told about every change made to buffers, once made. For
+// removals, data is what was removed.
+var changeListeners = []func(b *Buffer, typ ActionType, loc *Location, data []rune){}
+
+func onBufferChange(fn func(b *Buffer, typ ActionType, loc *Location, data []rune)) {
+	changeListeners = append(changeListeners, fn)
+}
+
+// Newlines in data, and runes after the last one
+func changeSize(data []rune) (lines, last int) {
+	for _, r := range data {
+		if r == '\n' {
+			lines++
+			last = 0
+		} else {
+			last++
+		}
+	}
+	return lines, last
+}
+
 func (a *Action) Do(b *Buffer, typ ActionType) {
 	if typ == ActionTypeInsert {
 		a.Insert(b)
 	} else {
 		a.Remove(b)
 	}
+	for _, fn := range changeListeners {
+		fn(b, typ, a.Loc, a.Data)
+	}
 }
 
 func (a *Action) Insert(b *Buffer) {
@@ -65,10 +65,16 @@ type Buffer struct {
 	Name             string
 	Path             string
 	Modified         bool
+	ReadOnly         bool
 	Cursor           *Location
 	Modes            []string
+	Options          map[string]interface{}
 	LastRenderWidth  int
 	LastRenderHeight int
+
+	undoGroup      *Action
+	undoGroupDepth int
+	filetypeSet    bool // by the user, rather than detected
 }
 
 func NewBuffer(name string, path string) *Buffer {
@@ -79,15 +85,15 @@ func NewBuffer(name string, path string) *Buffer {
 		Modified:     false,
 		Cursor:       NewLocation(0, 0),
 		Modes:        []string{},
+		Options:      map[string]interface{}{},
 	}
 
 	if path == "" {
 		// TODO ensure uniqueness
 		b.Name = name
 	} else {
+		// Named after the file, numbered when another buffer has its name
 		b.SetPath(path)
-		// TODO ensure uniqueness
-		b.Name = name
 	}
 
 	return b
@@ -261,20 +267,62 @@ func (b *Buffer) MoveWordBackward() bool {
 }
 
 func (b *Buffer) Insert(data []rune) {
-	a := NewAction(ActionTypeInsert, b.Cursor.Clone(), data)
-	b.HistoryIndex++
-	b.History = tryMergeHistory(b.History[:b.HistoryIndex], a)
+	b.InsertAt(b.Cursor, data)
+}
+
+func (b *Buffer) InsertAt(loc *Location, data []rune) {
+	if b.ReadOnly {
+		messageError("Buffer is read-only")
+		return
+	}
+	a := NewAction(ActionTypeInsert, loc.Clone(), data)
+	b.record(a)
 	a.Apply(b)
 }
 
 func (b *Buffer) RemoveAt(loc *Location, n int) []rune {
+	if b.ReadOnly {
+		messageError("Buffer is read-only")
+		return nil
+	}
 	a := NewAction(ActionTypeRemove, loc.Clone(), make([]rune, n))
-	b.HistoryIndex++
-	b.History = tryMergeHistory(b.History[:b.HistoryIndex], a)
+	b.record(a)
 	a.Apply(b)
 	return a.Data
 }
 
+// Adds a to the history, or to the undo group in progress
+func (b *Buffer) record(a *Action) {
+	if b.undoGroup != nil {
+		b.undoGroup.Actions = append(b.undoGroup.Actions, a)
+		return
+	}
+	b.HistoryIndex++
+	b.History = tryMergeHistory(b.History[:b.HistoryIndex], a)
+}
+
+// Groups the changes made until the matching EndUndoGroup, so they're
+// undone and redone in one step. Groups can nest, the outermost one wins.
+func (b *Buffer) BeginUndoGroup() {
+	if b.undoGroupDepth == 0 {
+		b.undoGroup = NewAction(ActionTypeGroup, nil, nil)
+	}
+	b.undoGroupDepth++
+}
+
+func (b *Buffer) EndUndoGroup() {
+	b.undoGroupDepth--
+	if b.undoGroupDepth > 0 {
+		return
+	}
+	g := b.undoGroup
+	b.undoGroup = nil
+	if len(g.Actions) > 0 {
+		b.HistoryIndex++
+		b.History = tryMergeHistory(b.History[:b.HistoryIndex], g)
+	}
+}
+
 func (b *Buffer) Remove(n int) []rune {
 	return b.RemoveAt(b.Cursor, n)
 }
@@ -303,21 +351,24 @@ func (b *Buffer) SetPath(path string) {
 	if err != nil {
 		b.Path = filepath.Clean(path)
 	}
+	if !b.filetypeSet {
+		b.Options["filetype"] = detectFiletype(b)
+		updateLanguageMode(b)
+	}
 	b.Name = ""
 	name := filepath.Base(b.Path)
+	candidate := name
 
 	i := 1
 checkName:
 	for _, b2 := range buffers {
-		if b2.Name == name {
-			b.Name = name + " " + strconv.Itoa(i)
+		if b2 != b && b2.Name == candidate {
+			candidate = name + " " + strconv.Itoa(i)
 			i++
 			goto checkName
 		}
 	}
-	if b.Name == "" {
-		b.Name = name
-	}
+	b.Name = candidate
 }
 
 func (b *Buffer) AddMode(name string) {
@@ -353,12 +404,17 @@ func (b *Buffer) Save() {
 		messageError("Can't save a buffer without a path.")
 		return
 	}
+	if b.ReadOnly {
+		messageError("Buffer is read-only")
+		return
+	}
 	err := ioutil.WriteFile(b.Path, []byte(b.Contents()), 0666)
 	if err != nil {
 		messageError("Error saving buffer: " + err.Error())
 	} else {
 		b.Modified = false
 		message("Buffer written to '" + b.NicePath() + "'")
+		hook_trigger_buffer("saved", b)
 	}
 }
 
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// Command lines are split into arguments like a shell would:
+//
+//   :e my\ file.txt          one argument, the space escaped
+//   :e "my file.txt"         double quotes allow \ escapes and $VARS
+//   :e 'my $file.txt'        single quotes keep everything as is
+//   :e %.orig                % is the current file's path, # the alternate
+//   :e ~/notes $HOME/todo    ~ and environment variables are expanded
+//
+// Commands added with addRawCommand get the rest of the line untouched.
+
+var rawCommands = map[string]bool{}
+
+// Set while running a command line starting with %, for commands like :s
+// that work on the cursor line by default to work on the whole buffer
+var commandWholeBuffer = false
+
+// Adds a command receiving its arguments as a single raw string, args
+// being the command name and what follows it
+func addRawCommand(name, desc string, fn func([]string)) {
+	addCommandDesc(name, desc, fn)
+	rawCommands[name] = true
+}
+
+// Splits a command line into the command name and its arguments, then runs it
+func runCommandLine(line string) {
+	line = strings.TrimLeftFunc(line, unicode.IsSpace)
+	if line == "" {
+		return
+	}
+	if strings.HasPrefix(line, "%") {
+		commandWholeBuffer = true
+		defer func() { commandWholeBuffer = false }()
+		line = line[1:]
+	}
+	name, rest := line, ""
+	if i := strings.IndexFunc(line, unicode.IsSpace); i >= 0 {
+		name, rest = line[:i], strings.TrimLeftFunc(line[i:], unicode.IsSpace)
+	}
+	if full, ok := commandAliases[name]; ok {
+		name = full
+	}
+	if _, ok := commands[name]; !ok {
+		// Names can be followed by punctuation directly, as in :s/a/b/
+		if i := strings.IndexFunc(line, func(r rune) bool { return !unicode.IsLetter(r) }); i > 0 {
+			name, rest = line[:i], line[i:]
+			if full, ok := commandAliases[name]; ok {
+				name = full
+			}
+		}
+	}
+	if rawCommands[name] {
+		runCommand([]string{name, rest})
+		return
+	}
+	args, err := parseCommandArgs(rest)
+	if err != nil {
+		messageError(err.Error())
+		return
+	}
+	runCommand(append([]string{name}, args...))
+}
+
+// Splits s into arguments, handling quotes, escapes and expansions
+func parseCommandArgs(s string) ([]string, error) {
+	args := []string{}
+	arg := []rune{}
+	inArg := false
+	runes := []rune(s)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			if inArg {
+				args = append(args, string(arg))
+				arg = []rune{}
+				inArg = false
+			}
+		case r == '\\':
+			// Only special characters are escaped, so regexps like \bfoo\b
+			// go through as typed
+			if i+1 < len(runes) && (unicode.IsSpace(runes[i+1]) || strings.ContainsRune(`\'"$%#~`, runes[i+1])) {
+				i++
+			}
+			arg = append(arg, runes[i])
+			inArg = true
+		case r == '\'':
+			end := indexRune(runes, i+1, '\'')
+			if end < 0 {
+				return nil, errors.New("Missing closing '")
+			}
+			arg = append(arg, runes[i+1:end]...)
+			i = end
+			inArg = true
+		case r == '"':
+			i++
+			for ; i < len(runes) && runes[i] != '"'; i++ {
+				if runes[i] == '\\' && i+1 < len(runes) && strings.ContainsRune(`\"$`, runes[i+1]) {
+					i++
+					arg = append(arg, runes[i])
+				} else if runes[i] == '$' {
+					value, n := expandVariable(runes[i:])
+					arg = append(arg, []rune(value)...)
+					i += n - 1
+				} else {
+					arg = append(arg, runes[i])
+				}
+			}
+			if i >= len(runes) {
+				return nil, errors.New("Missing closing \"")
+			}
+			inArg = true
+		case r == '$':
+			value, n := expandVariable(runes[i:])
+			arg = append(arg, []rune(value)...)
+			i += n - 1
+			inArg = true
+		case r == '~' && !inArg && (i+1 == len(runes) || runes[i+1] == '/' || unicode.IsSpace(runes[i+1])):
+			arg = append(arg, []rune(os.Getenv("HOME"))...)
+			inArg = true
+		case r == '%' || r == '#':
+			path, err := expandFileRef(r)
+			if err != nil {
+				return nil, err
+			}
+			arg = append(arg, []rune(path)...)
+			inArg = true
+		default:
+			arg = append(arg, r)
+			inArg = true
+		}
+	}
+	if inArg {
+		args = append(args, string(arg))
+	}
+	return args, nil
+}
+
+func indexRune(runes []rune, from int, r rune) int {
+	for i := from; i < len(runes); i++ {
+		if runes[i] == r {
+			return i
+		}
+	}
+	return -1
+}
+
+// Expands the $VAR or ${VAR} runes start with, returning its value and
+// the number of runes used. A lone $ stays as is.
+func expandVariable(runes []rune) (string, int) {
+	if len(runes) > 1 && runes[1] == '{' {
+		if end := indexRune(runes, 2, '}'); end >= 0 {
+			return os.Getenv(string(runes[2:end])), end + 1
+		}
+		return "$", 1
+	}
+	n := 1
+	for n < len(runes) && (runes[n] == '_' || unicode.IsLetter(runes[n]) || unicode.IsDigit(runes[n])) {
+		n++
+	}
+	if n == 1 {
+		return "$", 1
+	}
+	return os.Getenv(string(runes[1:n])), n
+}
+
+// Path of the current (%) or alternate (#) buffer's file
+func expandFileRef(r rune) (string, error) {
+	if r == '#' {
+		if alternateBuffer == nil || alternateBuffer.Path == "" {
+			return "", errors.New("No alternate file name for '#'")
+		}
+		return relativePath(alternateBuffer.Path), nil
+	}
+	if currentViewTree == nil || currentViewTree.Leaf.Buf.Path == "" {
+		return "", errors.New("No file name for '%'")
+	}
+	return relativePath(currentViewTree.Leaf.Buf.Path), nil
+}
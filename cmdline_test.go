@@ -0,0 +1,68 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseCommandArgs(t *testing.T) {
+	os.Setenv("RY_TEST_VAR", "value")
+	defer os.Unsetenv("RY_TEST_VAR")
+	home := os.Getenv("HOME")
+	tests := map[string][]string{
+		"":                             {},
+		"a  b":                         {"a", "b"},
+		`my\ file.txt`:                 {"my file.txt"},
+		`"my file.txt" x`:              {"my file.txt", "x"},
+		`'$RY_TEST_VAR \n' ""`:         {`$RY_TEST_VAR \n`, ""},
+		`"a \"b\" $RY_TEST_VAR"`:       {`a "b" value`},
+		"$RY_TEST_VAR/${RY_TEST_VAR}x": {"value/valuex"},
+		"~/notes a~ $":                 {home + "/notes", "a~", "$"},
+		`\bfoo\b "\d+" \\ \%`:          {`\bfoo\b`, `\d+`, `\`, "%"},
+	}
+	for line, expected := range tests {
+		args, err := parseCommandArgs(line)
+		if err != nil {
+			t.Fatalf("%q: %v", line, err)
+		}
+		if !reflect.DeepEqual(args, expected) {
+			t.Errorf("%q: expected %q, got %q", line, expected, args)
+		}
+	}
+	if _, err := parseCommandArgs(`"unfinished`); err == nil {
+		t.Error("expected an error for a missing quote")
+	}
+}
+
+func TestCommandLineFileRefs(t *testing.T) {
+	first := tempFile(t, "one\n")
+	second := filepath.Join(filepath.Dir(first), "my file.txt")
+	ioutil.WriteFile(second, []byte("two\n"), 0644)
+	h := newHeadless(80, 24, []string{first})
+
+	if _, err := parseCommandArgs("#"); err == nil {
+		t.Fatal("expected an error without an alternate file")
+	}
+	runCommandLine(`e "` + second + `"`)
+	if h.Buffer().Path != second {
+		t.Fatalf("expected %q to be opened, got %q", second, h.Buffer().Path)
+	}
+	args, err := parseCommandArgs("% #.orig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if args[0] != second || args[1] != first+".orig" {
+		t.Fatalf("unexpected expansions %q", args)
+	}
+
+	// Raw commands get the line as typed
+	var raw []string
+	addRawCommand("raw", "", func(args []string) { raw = args })
+	h.Keys(": r a w SPC SPC a SPC SPC ' % RET")
+	if !reflect.DeepEqual(raw, []string{"raw", "a  '%"}) {
+		t.Fatalf("unexpected raw arguments %q", raw)
+	}
+}
@@ -63,9 +63,12 @@ func openBufferFromFile(path string) *Buffer {
 		if len(buf.Data) > 1 {
 			buf.Data = buf.Data[:len(buf.Data)-1]
 		}
+		buf.Options["filetype"] = detectFiletype(buf)
+		updateLanguageMode(buf)
 	}
 	buffers = append(buffers, buf)
 	hook_trigger_buffer("modified", buf)
+	hook_trigger_buffer("opened", buf)
 	return buf
 }
 
@@ -83,8 +86,9 @@ func showBuffer(buffer_name string) *Buffer {
 			if currentViewTree.Leaf.Buf == b {
 				return b // already shown
 			}
-			currentViewTree = NewViewTreeLeaf(nil, NewView(b))
-			rootViewTree = currentViewTree
+			alternateBuffer = currentViewTree.Leaf.Buf
+			currentViewTree.Leaf = NewView(b)
+			completionClose()
 			return b
 		}
 	}
@@ -95,6 +99,10 @@ func closeBuffer(b *Buffer) {
 	for i, b2 := range buffers {
 		if b == b2 {
 			buffers = append(buffers[:i], buffers[i+1:]...)
+			if alternateBuffer == b {
+				alternateBuffer = nil
+			}
+			hook_trigger_buffer("closed", b)
 			break
 		}
 	}
@@ -103,14 +111,25 @@ func closeBuffer(b *Buffer) {
 func selectAvailableBuffer(closeIfNone bool) {
 	if len(buffers) == 0 {
 		if closeIfNone {
-			// TODO Use method here (don't handcode screen.Fini())
-			screen.Fini()
-			os.Exit(0)
+			quit()
 		}
 	} else {
-		currentViewTree = NewViewTreeLeaf(nil, NewView(buffers[0]))
-		rootViewTree = currentViewTree
+		// Windows showing closed buffers show another one
+		for _, leaf := range rootViewTree.Leaves() {
+			if !bufferIsOpen(leaf.Leaf.Buf) {
+				leaf.Leaf = NewView(buffers[0])
+			}
+		}
+	}
+}
+
+func bufferIsOpen(b *Buffer) bool {
+	for _, b2 := range buffers {
+		if b == b2 {
+			return true
+		}
 	}
+	return false
 }
 
 func closeCurrentBuffer(force bool) {
@@ -132,8 +151,39 @@ func findBuffer(name string) *Buffer {
 	return nil
 }
 
+func findBufferByPath(path string) *Buffer {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	for _, b := range buffers {
+		if b.Path == path {
+			return b
+		}
+	}
+	return nil
+}
+
+// Shows the buffer visiting path, opening the file if no buffer does yet
+func showFile(path string) *Buffer {
+	b := findBufferByPath(path)
+	if b == nil {
+		if b = openBufferFromFile(path); b == nil {
+			return nil
+		}
+	}
+	return showBuffer(b.Name)
+}
+
 var commands = map[string]func([]string){}
+
+// Buffer shown before the current one, # in command lines
+var alternateBuffer *Buffer
+
 var commandAliases = map[string]string{}
+var commandDescriptions = map[string]string{}
+
+// Completion of command arguments in the : prompt, by command name
+var commandCompletions = map[string]func(string) []string{}
 
 func runCommand(args []string) {
 	if len(args) == 0 {
@@ -154,28 +204,50 @@ func runCommand(args []string) {
 func addCommand(name string, fn func([]string)) {
 	commands[name] = fn
 }
+
+// Adds a command like addCommand, with a short description of what it does
+func addCommandDesc(name, desc string, fn func([]string)) {
+	addCommand(name, fn)
+	commandDescriptions[name] = desc
+}
+
+// Sets how the arguments of a command are completed, complete getting the
+// word before the cursor and returning candidates for it
+func addCommandCompletion(name string, complete func(string) []string) {
+	commandCompletions[name] = complete
+}
+
 func addAlias(alias, name string) {
 	commandAliases[alias] = name
 }
 
 func initCommands() {
-	addCommand("quit", func(args []string) {
+	commands = map[string]func([]string){}
+	commandAliases = map[string]string{}
+	commandDescriptions = map[string]string{}
+	commandCompletions = map[string]func(string) []string{}
+	rawCommands = map[string]bool{}
+
+	addCommandDesc("quit", "Close current buffer (making sure it's saved before)", func(args []string) {
 		closeCurrentBuffer(false)
 	})
 	addAlias("q", "quit")
-	addCommand("quit!", func(args []string) {
+	addCommandDesc("quit!", "Close current buffer (ignoring unsaved changes)", func(args []string) {
 		closeCurrentBuffer(true)
 	})
 	addAlias("q!", "quit!")
-	addCommand("write", func(args []string) {
+	addCommandDesc("write", "Write buffer to disk, optionally setting it's path", func(args []string) {
 		b := currentViewTree.Leaf.Buf
 		if len(args) > 1 {
 			b.SetPath(args[1])
+			// The filetype can change with the path, and highlighting
+			hook_trigger_buffer("modified", b)
 		}
 		b.Save()
 	})
 	addAlias("w", "write")
-	addCommand("edit", func(args []string) {
+	addCommandCompletion("write", completePath)
+	addCommandDesc("edit", "Edit a file in a new buffer (shows file selector on directories)", func(args []string) {
 		if len(args) < 2 {
 			messageError("Can't open buffer without a name or file path.")
 		} else {
@@ -190,13 +262,21 @@ func initCommands() {
 
 	})
 	addAlias("e", "edit")
+	addCommandCompletion("edit", completePath)
 	addAlias("o", "edit")
-	addCommand("writequit", func(args []string) {
+	addCommandDesc("writequit", "Writes buffer to disk then closes it", func(args []string) {
 		runCommand([]string{"write"})
 		runCommand([]string{"quit"})
 	})
 	addAlias("wq", "writequit")
-	addCommand("buffers", func(args []string) {
+	addCommandDesc("buffers", "Shows a list of buffers in current window, or the buffer named", func(args []string) {
+		if len(args) > 1 && args[1] != "" {
+			name := strings.Join(args[1:], " ")
+			if showBuffer(name) == nil {
+				messageError("No buffer named '" + name + "'")
+			}
+			return
+		}
 		var b *Buffer
 		if b = findBuffer("*buffers*"); b == nil {
 			b = openBufferNamed("*buffers*")
@@ -212,4 +292,5 @@ func initCommands() {
 		showBuffer(b.Name)
 	})
 	addAlias("b", "buffers")
+	addCommandCompletion("buffers", completeBufferName)
 }
@@ -0,0 +1,387 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Completion offers items to replace the word before the cursor with, in
+// insert mode and in prompts. Items come from sources, registered with a
+// priority, which reply right away or later, from the main loop, when
+// they wait on a server or the disk. Replies are merged as they come:
+// items starting with the word typed first, those of higher priority
+// sources first, then the others matching it fuzzily, best first.
+//
+// In insert mode, items show in a menu under the cursor. Going through
+// them previews them in the buffer; any key other than the menu's own
+// accepts the one shown and goes on as usual.
+
+type completionItem struct {
+	Label  string // shown in the menu
+	Insert string // text replacing the word typed, Label when empty
+	Detail string
+	Source string // name of the source it came from
+}
+
+type completionSource struct {
+	Name     string
+	Priority int
+	// Calls reply once with the items for req, on the main loop
+	Complete func(req *completionRequest, reply func([]completionItem))
+}
+
+type completionRequest struct {
+	Buf    *Buffer   // nil in prompts
+	Cursor *Location // in Buf
+	Text   string    // before the cursor, on its line or in the prompt
+	Prefix string    // word being completed, ending Text
+
+	id      int
+	results [][]completionItem
+	pending int
+}
+
+type insertCompletion struct {
+	Buf      *Buffer
+	Beg      *Location
+	Original string // what was typed, restored when cancelling
+	Items    []completionItem
+	Selected int // -1 while showing Original
+	req      *completionRequest
+}
+
+// Most items kept from a request's replies
+const maxCompletions = 200
+
+var (
+	completionSources   = []*completionSource{}
+	completionRequestID = 0
+	editorCompletion    *insertCompletion
+)
+
+func initCompletion() {
+	completionSources = []*completionSource{}
+	editorCompletion = nil
+
+	addCompletionSource("paths", 5, completePaths)
+
+	addMode("completion")
+	for _, key := range []string{"C-n", "DOWN", "TAB"} {
+		bind("completion", k(key), func(vt *ViewTree, b *Buffer, kl *KeyList) {
+			completionSelect(1)
+		})
+	}
+	for _, key := range []string{"C-p", "UP", "BTAB"} {
+		bind("completion", k(key), func(vt *ViewTree, b *Buffer, kl *KeyList) {
+			completionSelect(-1)
+		})
+	}
+	bind("completion", k("C-e"), completionCancel)
+	bind("completion", k("ESC"), completionCancel)
+	bind("completion", k("C-y"), completionAccept)
+	bind("completion", k("RET"), completionAccept)
+	bind("completion", k("$any"), func(vt *ViewTree, b *Buffer, kl *KeyList) {
+		completionClose()
+		if binding, _ := findBinding(activeModes(), kl); binding != nil {
+			binding.f(vt, b, kl)
+		}
+	})
+
+	// Completions in a buffer being closed end with it
+	hook_buffer("closed", func(b *Buffer) {
+		if editorCompletion != nil && editorCompletion.Buf == b {
+			completionClose()
+		}
+	})
+
+	bindDesc("insert", k("C-n"), "Complete word", func(vt *ViewTree, b *Buffer, kl *KeyList) {
+		completeInsert(b, completionSources, 1)
+	})
+	bindDesc("insert", k("C-p"), "Complete word, last match first", func(vt *ViewTree, b *Buffer, kl *KeyList) {
+		completeInsert(b, completionSources, -1)
+	})
+}
+
+// Registers a completion source, replacing the one with the same name
+func addCompletionSource(name string, priority int, complete func(req *completionRequest, reply func([]completionItem))) {
+	removeCompletionSource(name)
+	completionSources = append(completionSources, &completionSource{Name: name, Priority: priority, Complete: complete})
+	sort.SliceStable(completionSources, func(i, j int) bool {
+		return completionSources[i].Priority > completionSources[j].Priority
+	})
+}
+
+func removeCompletionSource(name string) {
+	for i, source := range completionSources {
+		if source.Name == name {
+			completionSources = append(completionSources[:i], completionSources[i+1:]...)
+			return
+		}
+	}
+}
+
+func findCompletionSource(name string) *completionSource {
+	for _, source := range completionSources {
+		if source.Name == name {
+			return source
+		}
+	}
+	return nil
+}
+
+// Asks sources for completions, calling show with the merged items once
+// those replying right away have, then each time another one does. done
+// tells if all of them have replied.
+func requestCompletions(req *completionRequest, sources []*completionSource, show func(items []completionItem, done bool)) {
+	completionRequestID++
+	req.id = completionRequestID
+	req.results = make([][]completionItem, len(sources))
+	req.pending = len(sources)
+	asking := true
+	for i, source := range sources {
+		i, source := i, source
+		replied := false
+		source.Complete(req, func(items []completionItem) {
+			if replied || req.id != completionRequestID {
+				return
+			}
+			replied = true
+			for j := range items {
+				items[j].Source = source.Name
+			}
+			req.results[i] = items
+			req.pending--
+			if !asking {
+				show(mergeCompletions(req.Prefix, req.results), req.pending == 0)
+			}
+		})
+	}
+	asking = false
+	show(mergeCompletions(req.Prefix, req.results), req.pending == 0)
+}
+
+// Drops the replies to requests made so far
+func cancelCompletions() {
+	completionRequestID++
+}
+
+// Items of all replies without duplicates, the ones starting with prefix
+// first in the order given, then fuzzy matches best first
+func mergeCompletions(prefix string, results [][]completionItem) []completionItem {
+	starting := []completionItem{}
+	others := []completionItem{}
+	scores := map[string]int{}
+	seen := map[string]bool{}
+	for _, items := range results {
+		for _, item := range items {
+			text := item.Text()
+			if seen[text] {
+				continue
+			}
+			seen[text] = true
+			if strings.HasPrefix(text, prefix) {
+				starting = append(starting, item)
+			} else if m, ok := fuzzyMatchString(prefix, text); ok {
+				scores[text] = m.score
+				others = append(others, item)
+			}
+		}
+	}
+	sort.SliceStable(others, func(i, j int) bool {
+		return scores[others[i].Text()] > scores[others[j].Text()]
+	})
+	items := append(starting, others...)
+	if len(items) > maxCompletions {
+		items = items[:maxCompletions]
+	}
+	return items
+}
+
+func (item completionItem) Text() string {
+	if item.Insert != "" {
+		return item.Insert
+	}
+	return item.Label
+}
+
+// Start of the word before the cursor
+func wordStart(b *Buffer) *Location {
+	c := b.Cursor.Char
+	line := b.Data[b.Cursor.Line]
+	for c > 0 && c <= len(line) && isWord(line[c-1]) {
+		c--
+	}
+	return NewLocation(b.Cursor.Line, c)
+}
+
+// Completes the word before the cursor in insert mode with sources,
+// previewing the first item, or the last one when n is -1
+func completeInsert(b *Buffer, sources []*completionSource, n int) {
+	completionClose()
+	beg := wordStart(b)
+	line := b.Data[b.Cursor.Line]
+	req := &completionRequest{
+		Buf:    b,
+		Cursor: b.Cursor.Clone(),
+		Text:   string(line[:b.Cursor.Char]),
+		Prefix: string(line[beg.Char:b.Cursor.Char]),
+	}
+	requestCompletions(req, sources, func(items []completionItem, done bool) {
+		if c := editorCompletion; c != nil && c.req == req {
+			c.update(items)
+			return
+		}
+		if editorMode != "insert" || currentViewTree.Leaf.Buf != b || !b.Cursor.Equal(req.Cursor) {
+			return
+		}
+		if len(items) == 0 {
+			if done {
+				message("No completions")
+			}
+			return
+		}
+		b.BeginUndoGroup()
+		editorCompletion = &insertCompletion{
+			Buf:      b,
+			Beg:      beg,
+			Original: req.Prefix,
+			Items:    items,
+			Selected: -1,
+			req:      req,
+		}
+		completionSelect(n)
+	})
+}
+
+// Takes items replied later in, keeping the selected one
+func (c *insertCompletion) update(items []completionItem) {
+	selected := ""
+	if c.Selected >= 0 {
+		selected = c.Items[c.Selected].Text()
+	}
+	c.Items = items
+	for i, item := range items {
+		if c.Selected >= 0 && item.Text() == selected {
+			c.Selected = i
+			return
+		}
+	}
+	// The previewed item is gone, preview the one taking its place
+	c.Selected = min(c.Selected, len(items)-1)
+	if c.Selected >= 0 {
+		c.replace(c.Items[c.Selected].Text())
+	} else if selected != "" {
+		c.replace(c.Original)
+	}
+}
+
+func (c *insertCompletion) replace(text string) {
+	b := c.Buf
+	if n := b.Cursor.Char - c.Beg.Char; n > 0 {
+		b.RemoveAt(c.Beg, n)
+	}
+	b.InsertAt(c.Beg, []rune(text))
+	b.MoveTo(c.Beg.Char+len([]rune(text)), c.Beg.Line)
+}
+
+// Previews the next item, or the previous one when n is -1, going by
+// what was typed between the last and first
+func completionSelect(n int) {
+	c := editorCompletion
+	count := len(c.Items) + 1
+	c.Selected = (c.Selected+1+n+count)%count - 1
+	if c.Selected < 0 {
+		c.replace(c.Original)
+	} else {
+		c.replace(c.Items[c.Selected].Text())
+	}
+}
+
+func completionCancel(vt *ViewTree, b *Buffer, kl *KeyList) {
+	editorCompletion.replace(editorCompletion.Original)
+	completionClose()
+}
+
+func completionAccept(vt *ViewTree, b *Buffer, kl *KeyList) {
+	completionClose()
+}
+
+func completionClose() {
+	if c := editorCompletion; c != nil {
+		editorCompletion = nil
+		cancelCompletions()
+		c.Buf.EndUndoGroup()
+	}
+}
+
+// Menu lines for items: labels then details and sources in columns
+func completionLines(items []completionItem, sources bool) []string {
+	labelWidth, detailWidth := 0, 0
+	for _, item := range items {
+		labelWidth = max(labelWidth, len([]rune(item.Label)))
+		detailWidth = max(detailWidth, len([]rune(item.Detail)))
+	}
+	lines := []string{}
+	for _, item := range items {
+		line := padr(item.Label, labelWidth, ' ')
+		if detailWidth > 0 {
+			line += "  " + padr(item.Detail, detailWidth, ' ')
+		}
+		if sources {
+			line += "  " + item.Source
+		}
+		lines = append(lines, strings.TrimRight(line, " "))
+	}
+	return lines
+}
+
+// Draws the menu under the cursor, or above it when there's no room
+func renderCompletion(width, height int) {
+	c := editorCompletion
+	v := currentViewTree.Leaf
+	if editorMode != "insert" || v.Buf != c.Buf {
+		return
+	}
+	lines := completionLines(c.Items, true)
+	w := 0
+	for _, line := range lines {
+		w = max(w, len([]rune(line))+4)
+	}
+	w = min(w, width)
+	h := min(len(lines), 10) + 2
+	x := max(min(v.CursorX-(c.Buf.Cursor.Char-c.Beg.Char)-2, width-w), 0)
+	y := v.CursorY + 1
+	if y+h > height-1 {
+		y = max(v.CursorY-h, 0)
+	}
+	renderPopup(&Popup{Lines: lines, Selected: c.Selected}, x, y, w, h)
+}
+
+// Completes paths being typed, those with a slash in them, relative to
+// the working directory
+func completePaths(req *completionRequest, reply func([]completionItem)) {
+	start := strings.LastIndexAny(req.Text, " \t\"'`()[]{}<>=,:;") + 1
+	path := req.Text[start:]
+	if !strings.Contains(path, "/") {
+		reply(nil)
+		return
+	}
+	go func() {
+		paths := completePath(path)
+		runInMainLoop(func() {
+			// Only the word typed is replaced, the rest of the path stays
+			typed := len([]rune(path)) - len([]rune(req.Prefix))
+			items := []completionItem{}
+			for _, p := range paths {
+				label := filepath.Base(p)
+				if strings.HasSuffix(p, string(os.PathSeparator)) {
+					label += string(os.PathSeparator)
+				}
+				items = append(items, completionItem{Label: label, Insert: string([]rune(p)[typed:])})
+			}
+			reply(items)
+		})
+	}()
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func completionLabels() []string {
+	labels := []string{}
+	for _, item := range editorCompletion.Items {
+		labels = append(labels, item.Label)
+	}
+	return labels
+}
+
+func TestMergeCompletions(t *testing.T) {
+	items := mergeCompletions("fb", [][]completionItem{
+		{{Label: "foobar"}, {Label: "fb_x"}},
+		nil,
+		{{Label: "fbz"}, {Label: "fb_x"}, {Label: "nope"}, {Label: "f_b"}},
+	})
+	labels := []string{}
+	for _, item := range items {
+		labels = append(labels, item.Label)
+	}
+	if !reflect.DeepEqual(labels, []string{"fb_x", "fbz", "f_b", "foobar"}) {
+		t.Fatalf("unexpected completions %q", labels)
+	}
+}
+
+func TestCompletionSources(t *testing.T) {
+	path := tempFile(t, "alpha beta\n")
+	if err := ioutil.WriteFile(filepath.Join(filepath.Dir(path), "notes.txt"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	h := newHeadless(80, 24, []string{path})
+
+	// A source replying later, from another goroutine
+	addCompletionSource("slow", 30, func(req *completionRequest, reply func([]completionItem)) {
+		go runInMainLoop(func() {
+			reply([]completionItem{{Label: "alpaca", Detail: "animal"}})
+		})
+	})
+	scriptRun(`
+		ry.add_completion("lua", function(buf, prefix)
+			return {"alright", {label = "apex", detail = "peak"}}
+		end, 1)
+	`)
+
+	h.Keys("o a l C-n")
+	if !reflect.DeepEqual(completionLabels(), []string{"alpha", "alright"}) {
+		t.Fatalf("unexpected completions %q", completionLabels())
+	}
+	waitFor(t, h, "the slow source", func() bool { return len(editorCompletion.Items) == 3 })
+	if !reflect.DeepEqual(completionLabels(), []string{"alpaca", "alpha", "alright"}) {
+		t.Fatalf("unexpected completions %q", completionLabels())
+	}
+	if editorCompletion.Selected != 1 {
+		t.Fatalf("expected alpha still selected, got %d", editorCompletion.Selected)
+	}
+	expectContents(t, h, "alpha beta\nalpha\n")
+	found := false
+	for y := 0; y < 24; y++ {
+		found = found || strings.Contains(h.Line(y), "alpaca   animal  slow")
+	}
+	if !found {
+		t.Fatal("expected sources and details in the menu")
+	}
+
+	// Replies to a closed menu are dropped
+	h.Keys("ESC ESC o a l C-n C-e")
+	h.Process()
+	if editorCompletion != nil {
+		t.Fatal("expected the menu closed")
+	}
+
+	// Paths complete the file name part being typed
+	removeCompletionSource("slow")
+	h.Keys("ESC o")
+	for _, r := range filepath.Dir(path) + "/note" {
+		h.Keys(string(r))
+	}
+	h.Keys("C-n")
+	waitFor(t, h, "paths", func() bool { return editorCompletion != nil })
+	if line := string(h.Buffer().Data[3]); line != filepath.Dir(path)+"/notes.txt" {
+		t.Fatalf("expected the path completed, got %q", line)
+	}
+}
+
+func TestCompletionUpdate(t *testing.T) {
+	h := newHeadless(80, 24, nil)
+	h.Keys("i a b d")
+	b := h.Buffer()
+	b.BeginUndoGroup()
+	editorCompletion = &insertCompletion{
+		Buf:      b,
+		Beg:      NewLocation(0, 0),
+		Original: "a",
+		Items:    []completionItem{{Label: "abc"}, {Label: "abd"}},
+		Selected: 1,
+	}
+
+	// Late replies without the previewed item preview another one
+	editorCompletion.update([]completionItem{{Label: "abc"}})
+	if editorCompletion.Selected != 0 {
+		t.Fatalf("expected abc selected, got %d", editorCompletion.Selected)
+	}
+	expectContents(t, h, "abc\n")
+	editorCompletion.update(nil)
+	if editorCompletion.Selected != -1 {
+		t.Fatalf("expected nothing selected, got %d", editorCompletion.Selected)
+	}
+	expectContents(t, h, "a\n")
+
+	// Drawing keeps it, showing another buffer ends it
+	render()
+	if editorCompletion == nil {
+		t.Fatal("expected the completion kept while rendering")
+	}
+	showBuffer(openBufferNamed("other").Name)
+	if editorCompletion != nil {
+		t.Fatal("expected the completion closed with its buffer hidden")
+	}
+}
@@ -1,43 +1,360 @@
 package main
 
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Options are looked up in layers: buffer-local values first, then the
+// values set for the buffer's filetype, then global ones, then the
+// option's default.
+//
+// ~/.config/ry/config.toml holds global values at the top level and
+// per filetype values in [filetype.<name>] tables:
+//
+//   tab_width = 4
+//
+//   [filetype.go]
+//   tab_to_spaces = false
+//
+//   [filetype.yaml]
+//   tab_width = 2
+
+type Option struct {
+	Name        string
+	Default     interface{} // string, float64 or bool
+	Description string
+	Local       bool // only makes sense per buffer, set locally by :set
+}
+
 var (
-	config map[string]interface{}
+	options        = map[string]*Option{}
+	config         map[string]interface{}
+	configFiletype map[string]map[string]interface{}
+
+	// Handlers for other tables of the config file, by table name
+	configSections = map[string]func(interface{}) error{}
 )
 
 func initConfig() {
-	config = map[string]interface{}{
-		"tab_width":     float64(4),
-		"tab_to_spaces": true,
+	options = map[string]*Option{}
+	config = map[string]interface{}{}
+	configFiletype = map[string]map[string]interface{}{}
+	configSections = map[string]func(interface{}) error{}
+
+	addOption("tab_width", float64(4), "Width of a tab, and number of spaces inserted for one")
+	addOption("tab_to_spaces", true, "Insert spaces instead of tabs")
+	addOption("timeout", float64(1000), "Milliseconds to wait for the rest of a key sequence")
+	addOptionLocal("filetype", "", "Type of the buffer's contents, picks per filetype options")
+
+	addCommandDesc("set", "Sets an option globally", func(args []string) {
+		configSetCommand(args[1:], false)
+	})
+	addCommandDesc("setlocal", "Sets an option for the current buffer only", func(args []string) {
+		configSetCommand(args[1:], true)
+	})
+	addAlias("setl", "setlocal")
+	addCommandCompletion("set", completeOptionName)
+	addCommandCompletion("setlocal", completeOptionName)
+	addCommandDesc("options", "Lists options with their value for the current buffer", func(args []string) {
+		showOptions()
+	})
+}
+
+// Registers a known option with its default value
+func addOption(name string, def interface{}, description string) {
+	options[name] = &Option{Name: name, Default: def, Description: description}
+}
+
+func addOptionLocal(name string, def interface{}, description string) {
+	addOption(name, def, description)
+	options[name].Local = true
+}
+
+// Looks up the value of an option for b, b being nil for the global value
+func configValue(key string, b *Buffer) interface{} {
+	if b != nil {
+		if v, ok := b.Options[key]; ok {
+			return v
+		}
+		if ft, ok := b.Options["filetype"].(string); ok {
+			if v, ok := configFiletype[ft][key]; ok {
+				return v
+			}
+			if lang := languages[ft]; lang != nil {
+				if v, ok := lang.Options[key]; ok {
+					return v
+				}
+			}
+		}
+	}
+	if v, ok := config[key]; ok {
+		return v
+	}
+	if o, ok := options[key]; ok {
+		return o.Default
 	}
+	return nil
 }
 
 func configGet(key string, b *Buffer) string {
-	if v, ok := config[key]; ok {
-		if vv, ok := v.(string); ok {
-			return vv
-		}
+	if v, ok := configValue(key, b).(string); ok {
+		return v
 	}
 	return ""
 }
 
 func configGetBool(key string, b *Buffer) bool {
-	if v, ok := config[key]; ok {
-		if vv, ok := v.(bool); ok {
-			return vv
-		}
+	if v, ok := configValue(key, b).(bool); ok {
+		return v
 	}
 	return false
 }
 
 func configGetNumber(key string, b *Buffer) float64 {
-	if v, ok := config[key]; ok {
-		if vv, ok := v.(float64); ok {
-			return vv
-		}
+	if v, ok := configValue(key, b).(float64); ok {
+		return v
 	}
 	return 0
 }
 
-func configSet(key string, value interface{}) {
+// Sets the global value of an option
+func configSet(key string, value interface{}) error {
+	value, err := configCheck(key, value)
+	if err != nil {
+		return err
+	}
 	config[key] = value
+	return nil
+}
+
+// Sets the value of an option for a filetype
+func configSetFiletype(filetype, key string, value interface{}) error {
+	value, err := configCheck(key, value)
+	if err != nil {
+		return err
+	}
+	if _, ok := configFiletype[filetype]; !ok {
+		configFiletype[filetype] = map[string]interface{}{}
+	}
+	configFiletype[filetype][key] = value
+	return nil
+}
+
+// Sets the value of an option for b only
+func (b *Buffer) SetOption(key string, value interface{}) error {
+	value, err := configCheck(key, value)
+	if err != nil {
+		return err
+	}
+	b.Options[key] = value
+	if key == "filetype" {
+		b.filetypeSet = true
+		updateLanguageMode(b)
+	}
+	return nil
+}
+
+// Checks value has the type of option key's default, converting numbers
+// to float64 and parsing strings (as typed after :set) when needed
+func configCheck(key string, value interface{}) (interface{}, error) {
+	o, ok := options[key]
+	if !ok {
+		return nil, errors.New("Unknown option '" + key + "'")
+	}
+	switch v := value.(type) {
+	case int:
+		value = float64(v)
+	case int64:
+		value = float64(v)
+	}
+	s, isString := value.(string)
+	switch o.Default.(type) {
+	case float64:
+		if isString {
+			n, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				return nil, errors.New("Option '" + key + "' expects a number")
+			}
+			return n, nil
+		}
+		if _, ok := value.(float64); ok {
+			return value, nil
+		}
+		return nil, errors.New("Option '" + key + "' expects a number")
+	case bool:
+		if isString {
+			switch strings.ToLower(s) {
+			case "true", "yes", "on", "1":
+				return true, nil
+			case "false", "no", "off", "0":
+				return false, nil
+			}
+			return nil, errors.New("Option '" + key + "' expects true or false")
+		}
+		if _, ok := value.(bool); ok {
+			return value, nil
+		}
+		return nil, errors.New("Option '" + key + "' expects true or false")
+	default:
+		if !isString {
+			return nil, errors.New("Option '" + key + "' expects a string")
+		}
+		return value, nil
+	}
+}
+
+func formatOptionValue(v interface{}) string {
+	if n, ok := v.(float64); ok {
+		return strconv.FormatFloat(n, 'f', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
+
+// Handles ":set" and ":setlocal" arguments: "key=value", "key" (true),
+// "nokey" (false) and "key?" (show value)
+func configSetCommand(args []string, local bool) {
+	b := currentViewTree.Leaf.Buf
+	if len(args) == 0 {
+		message("Usage: set <option>=<value>|<option>|no<option>|<option>?")
+		return
+	}
+	for _, arg := range args {
+		if arg == "" {
+			continue
+		}
+		key, value := arg, interface{}(nil)
+		if i := strings.Index(arg, "="); i >= 0 {
+			key, value = arg[:i], arg[i+1:]
+		} else if strings.HasSuffix(arg, "?") {
+			key = arg[:len(arg)-1]
+			if _, ok := options[key]; !ok {
+				messageError("Unknown option '" + key + "'")
+				return
+			}
+			message(key + "=" + formatOptionValue(configValue(key, b)))
+			continue
+		} else if o, ok := options[arg]; ok {
+			if _, isBool := o.Default.(bool); !isBool {
+				message(key + "=" + formatOptionValue(configValue(key, b)))
+				continue
+			}
+			value = true
+		} else if strings.HasPrefix(arg, "no") {
+			key, value = arg[2:], false
+			if o, ok := options[key]; ok {
+				if _, isBool := o.Default.(bool); !isBool {
+					messageError("Option '" + key + "' isn't true or false")
+					return
+				}
+			}
+		}
+
+		var err error
+		if local || options[key] != nil && options[key].Local {
+			err = b.SetOption(key, value)
+		} else {
+			err = configSet(key, value)
+		}
+		if err != nil {
+			messageError(err.Error())
+			return
+		}
+	}
+	hook_trigger_buffer("option_set", b)
+}
+
+// Lists known options with their value for the current buffer
+func showOptions() {
+	cb := currentViewTree.Leaf.Buf
+	names := []string{}
+	for name := range options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b *Buffer
+	if b = findBuffer("*options*"); b == nil {
+		b = openBufferNamed("*options*")
+	}
+	b.Data = [][]rune{}
+	for _, name := range names {
+		line := padr(name+"="+formatOptionValue(configValue(name, cb)), 30, ' ') + options[name].Description
+		b.Data = append(b.Data, []rune(line))
+	}
+	hook_trigger_buffer("modified", b)
+	showBuffer(b.Name)
+}
+
+// Loads config.toml from the config directory, if there is one
+func loadConfigFile() {
+	path := filepath.Join(configDir(), "config.toml")
+	if _, err := os.Stat(path); err != nil {
+		return
+	}
+	if err := configLoad(path); err != nil {
+		messageError("Error in '" + path + "': " + err.Error())
+	}
+}
+
+func configLoad(path string) error {
+	values := map[string]interface{}{}
+	if _, err := toml.DecodeFile(path, &values); err != nil {
+		return err
+	}
+	keys := []string{}
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value := values[key]
+		if key == "filetype" {
+			filetypes, ok := value.(map[string]interface{})
+			if !ok {
+				return errors.New("filetype must be a table of filetypes")
+			}
+			for ft, ftValues := range filetypes {
+				ftOptions, ok := ftValues.(map[string]interface{})
+				if !ok {
+					return errors.New("filetype." + ft + " must be a table of options")
+				}
+				for k, v := range ftOptions {
+					if err := configSetFiletype(ft, k, v); err != nil {
+						return err
+					}
+				}
+			}
+		} else if handler, ok := configSections[key]; ok {
+			if err := handler(value); err != nil {
+				return err
+			}
+		} else if err := configSet(key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Directory holding the user's configuration and scripts
+func configDir() string {
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		return filepath.Join(dir, "ry")
+	}
+	return filepath.Join(os.Getenv("HOME"), ".config", "ry")
+}
+
+// Directory holding state kept between sessions, like prompt history
+func dataDir() string {
+	if dir := os.Getenv("XDG_DATA_HOME"); dir != "" {
+		return filepath.Join(dir, "ry")
+	}
+	return filepath.Join(os.Getenv("HOME"), ".local", "share", "ry")
 }
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestConfigLayers(t *testing.T) {
+	h := newHeadless(80, 24, []string{tempFile(t, "")})
+	b := h.Buffer()
+	if ft := configGet("filetype", b); ft != "txt" {
+		t.Fatalf("expected filetype txt, got %q", ft)
+	}
+	if configGetNumber("tab_width", b) != 4 {
+		t.Fatal("expected the default tab width")
+	}
+	configSet("tab_width", float64(8))
+	if configGetNumber("tab_width", b) != 8 {
+		t.Fatal("expected the global tab width")
+	}
+	configSetFiletype("txt", "tab_width", 3)
+	if configGetNumber("tab_width", b) != 3 {
+		t.Fatal("expected the filetype tab width")
+	}
+	b.SetOption("tab_width", float64(2))
+	if configGetNumber("tab_width", b) != 2 {
+		t.Fatal("expected the buffer tab width")
+	}
+	if configGetNumber("tab_width", nil) != 8 {
+		t.Fatal("expected the global tab width without a buffer")
+	}
+	if err := configSet("tab_width", "wide"); err == nil {
+		t.Fatal("expected an error setting a number option to a word")
+	}
+	if err := configSet("no_such_option", true); err == nil {
+		t.Fatal("expected an error setting an unknown option")
+	}
+}
+
+func TestConfigSetCommand(t *testing.T) {
+	h := newHeadless(80, 24, nil)
+	b := h.Buffer()
+	modified, set := 0, 0
+	hook_buffer("modified", func(*Buffer) { modified++ })
+	hook_buffer("option_set", func(*Buffer) { set++ })
+	h.Keys(": s e t SPC t a b _ w i d t h = 2 SPC n o t a b _ t o _ s p a c e s RET")
+	if modified != 0 || set != 1 {
+		t.Fatalf("expected option_set hooks only, got %d modified and %d", modified, set)
+	}
+	if configGetNumber("tab_width", nil) != 2 || configGetBool("tab_to_spaces", nil) {
+		t.Fatalf("expected :set to change global options, got %v", config)
+	}
+	h.Keys(": s e t l o c a l SPC t a b _ t o _ s p a c e s RET")
+	if !configGetBool("tab_to_spaces", b) || configGetBool("tab_to_spaces", nil) {
+		t.Fatal("expected :setlocal to only change the buffer")
+	}
+	h.Keys(": s e t SPC t a b _ w i d t h ? RET")
+	if h.Message() != "tab_width=2" {
+		t.Fatalf("unexpected message %q", h.Message())
+	}
+	h.Keys(": s e t SPC t a b _ w i d t h = x RET")
+	if editorMessageType != "error" {
+		t.Fatal("expected an error for a bad value")
+	}
+}
+
+func TestConfigLoad(t *testing.T) {
+	newHeadless(80, 24, nil)
+	path := filepath.Join(filepath.Dir(tempFile(t, "")), "config.toml")
+	err := ioutil.WriteFile(path, []byte(`
+tab_width = 6
+
+[filetype.go]
+tab_to_spaces = false
+tab_width = 8
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := configLoad(path); err != nil {
+		t.Fatal(err)
+	}
+	if configGetNumber("tab_width", nil) != 6 {
+		t.Fatal("expected the global tab width from the file")
+	}
+	b := NewBuffer("main.go", "main.go")
+	if configGetNumber("tab_width", b) != 8 || configGetBool("tab_to_spaces", b) {
+		t.Fatal("expected go options from the file")
+	}
+
+	ioutil.WriteFile(path, []byte("tab_width = true\n"), 0644)
+	if err := configLoad(path); err == nil {
+		t.Fatal("expected an error for a mistyped option")
+	}
+}
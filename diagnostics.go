@@ -0,0 +1,293 @@
+package main
+
+import (
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/gdamore/tcell"
+)
+
+// Diagnostics are errors, warnings and hints about a file's contents,
+// published by sources like :make or a language server. They show as
+// signs next to line numbers and underlined ranges, the cursor line's
+// message going in the message bar.
+
+type DiagnosticSeverity int
+
+// Same values as the Language Server Protocol's
+const (
+	DiagnosticError   DiagnosticSeverity = 1
+	DiagnosticWarning DiagnosticSeverity = 2
+	DiagnosticInfo    DiagnosticSeverity = 3
+	DiagnosticHint    DiagnosticSeverity = 4
+)
+
+type Diagnostic struct {
+	Severity DiagnosticSeverity
+	Beg      *Location
+	End      *Location // exclusive, the same as Beg to point at a word
+	Message  string
+	Source   string
+}
+
+// Diagnostics by file path, then by source
+var diagnostics = map[string]map[string][]*Diagnostic{}
+
+func initDiagnostics() {
+	diagnostics = map[string]map[string][]*Diagnostic{}
+
+	onBufferChange(diagnosticsChange)
+
+	addOption("sign_column", "auto", "Show the sign column: auto when there are diagnostics, yes or no")
+
+	bindDesc("normal", k("] d"), "Next diagnostic", func(vt *ViewTree, b *Buffer, kl *KeyList) {
+		diagnosticMove(b, 1)
+	})
+	bindDesc("normal", k("[ d"), "Previous diagnostic", func(vt *ViewTree, b *Buffer, kl *KeyList) {
+		diagnosticMove(b, -1)
+	})
+
+	addCommandDesc("diagnostics", "Lists the current buffer's diagnostics in the quickfix list", func(args []string) {
+		b := currentViewTree.Leaf.Buf
+		entries := []*quickfixEntry{}
+		for _, d := range bufferDiagnostics(b) {
+			entries = append(entries, &quickfixEntry{
+				Path: b.Path,
+				Line: d.Beg.Line,
+				Col:  d.Beg.Char,
+				Type: d.Severity.String(),
+				Text: d.Message,
+			})
+		}
+		setQuickfix(entries)
+	})
+}
+
+func (s DiagnosticSeverity) String() string {
+	switch s {
+	case DiagnosticError:
+		return "error"
+	case DiagnosticWarning:
+		return "warning"
+	case DiagnosticInfo:
+		return "info"
+	}
+	return "hint"
+}
+
+// Replaces the diagnostics source published for the file at path
+func setDiagnostics(path, source string, diags []*Diagnostic) {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	if diagnostics[path] == nil {
+		diagnostics[path] = map[string][]*Diagnostic{}
+	}
+	for _, d := range diags {
+		d.Source = source
+	}
+	if len(diags) == 0 {
+		delete(diagnostics[path], source)
+	} else {
+		diagnostics[path][source] = diags
+	}
+}
+
+// Removes the diagnostics source published, for every file
+func clearDiagnostics(source string) {
+	for _, bySource := range diagnostics {
+		delete(bySource, source)
+	}
+}
+
+// Keeps the diagnostics of b on the text they're about as it's edited,
+// dropping those whose text is removed, until their source publishes
+// them again
+func diagnosticsChange(b *Buffer, typ ActionType, loc *Location, data []rune) {
+	bySource := diagnostics[b.Path]
+	if b.Path == "" || bySource == nil {
+		return
+	}
+	lines, last := changeSize(data)
+	for source, diags := range bySource {
+		kept := diags[:0]
+		for _, d := range diags {
+			point := d.Beg.Equal(d.End)
+			if typ == ActionTypeInsert {
+				if loc.Before(d.Beg) {
+					shiftInsert(d.Beg, loc, lines, last)
+				}
+				if loc.Before(d.End) && (point || !loc.Equal(d.End)) {
+					shiftInsert(d.End, loc, lines, last)
+				}
+			} else {
+				end := NewLocation(loc.Line+lines, last)
+				if lines == 0 {
+					end.Char += loc.Char
+				}
+				if loc.Before(d.Beg) && d.End.Before(end) && !d.Beg.Equal(end) {
+					continue
+				}
+				shiftRemove(d.Beg, loc, end, lines)
+				shiftRemove(d.End, loc, end, lines)
+			}
+			kept = append(kept, d)
+		}
+		if len(kept) == 0 {
+			delete(bySource, source)
+		} else {
+			bySource[source] = kept
+		}
+	}
+}
+
+// Diagnostics of b from all sources, in order of position then severity
+func bufferDiagnostics(b *Buffer) []*Diagnostic {
+	if b.Path == "" {
+		return nil
+	}
+	diags := []*Diagnostic{}
+	for _, sourceDiags := range diagnostics[b.Path] {
+		diags = append(diags, sourceDiags...)
+	}
+	sort.SliceStable(diags, func(i, j int) bool {
+		if diags[i].Beg.Line != diags[j].Beg.Line {
+			return diags[i].Beg.Line < diags[j].Beg.Line
+		}
+		if diags[i].Beg.Char != diags[j].Beg.Char {
+			return diags[i].Beg.Char < diags[j].Beg.Char
+		}
+		return diags[i].Severity < diags[j].Severity
+	})
+	return diags
+}
+
+// Most severe diagnostic starting on line l of b, nil if none
+func diagnosticAt(diags []*Diagnostic, l int) *Diagnostic {
+	var found *Diagnostic
+	for _, d := range diags {
+		if d.Beg.Line == l && (found == nil || d.Severity < found.Severity) {
+			found = d
+		}
+	}
+	return found
+}
+
+// Tells if the sign column shows for b
+func signColumnShown(b *Buffer, diags []*Diagnostic) bool {
+	switch configGet("sign_column", b) {
+	case "yes":
+		return true
+	case "no":
+		return false
+	}
+	return len(diags) > 0
+}
+
+func diagnosticStyle(d *Diagnostic) tcell.Style {
+	return style("diagnostic." + d.Severity.String())
+}
+
+func (d *Diagnostic) Sign() string {
+	switch d.Severity {
+	case DiagnosticError:
+		return "E"
+	case DiagnosticWarning:
+		return "W"
+	case DiagnosticInfo:
+		return "I"
+	}
+	return "H"
+}
+
+// Diagnostics underlining each rune of line l, nil if none. Diagnostics
+// without a range underline the word they start on.
+func diagnosticMarks(diags []*Diagnostic, l int, line []rune) []*Diagnostic {
+	length := len(line)
+	var marks []*Diagnostic
+	for _, d := range diags {
+		if l < d.Beg.Line || l > d.End.Line {
+			continue
+		}
+		from, to := 0, length
+		if l == d.Beg.Line {
+			from = d.Beg.Char
+		}
+		if l == d.End.Line {
+			to = d.End.Char
+		}
+		if d.Beg.Line == d.End.Line && d.Beg.Char == d.End.Char {
+			to = from + 1
+			for to < length && isWord(line[to]) && isWord(line[to-1]) {
+				to++
+			}
+		}
+		for c := max(from, 0); c < min(to, length); c++ {
+			if marks == nil {
+				marks = make([]*Diagnostic, length)
+			}
+			if marks[c] == nil || d.Severity < marks[c].Severity {
+				marks[c] = d
+			}
+		}
+	}
+	return marks
+}
+
+// Moves the cursor to the next diagnostic after it, or the previous one
+// when n is -1
+func diagnosticMove(b *Buffer, n int) {
+	diags := bufferDiagnostics(b)
+	if n < 0 {
+		for i := len(diags) - 1; i >= 0; i-- {
+			if diags[i].Beg.Before(b.Cursor) && !diags[i].Beg.Equal(b.Cursor) {
+				diagnosticGo(b, diags, i)
+				return
+			}
+		}
+	} else {
+		for i, d := range diags {
+			if b.Cursor.Before(d.Beg) && !b.Cursor.Equal(d.Beg) {
+				diagnosticGo(b, diags, i)
+				return
+			}
+		}
+	}
+	message("No more diagnostics")
+}
+
+func diagnosticGo(b *Buffer, diags []*Diagnostic, i int) {
+	d := diags[i]
+	b.MoveTo(d.Beg.Char, d.Beg.Line)
+	message("(" + strconv.Itoa(i+1) + " of " + strconv.Itoa(len(diags)) + ") " + d.Message)
+}
+
+// Message for the diagnostic on the cursor line of the current buffer
+func cursorDiagnostic() *Diagnostic {
+	if currentViewTree == nil {
+		return nil
+	}
+	b := currentViewTree.Leaf.Buf
+	return diagnosticAt(bufferDiagnostics(b), b.Cursor.Line)
+}
+
+// Diagnostics from quickfix entries, published as source
+func publishQuickfixDiagnostics(source string, entries []*quickfixEntry) {
+	clearDiagnostics(source)
+	byPath := map[string][]*Diagnostic{}
+	for _, e := range entries {
+		severity := DiagnosticError
+		switch e.Type {
+		case "warning":
+			severity = DiagnosticWarning
+		case "note", "info":
+			severity = DiagnosticInfo
+		}
+		loc := NewLocation(e.Line, e.Col)
+		byPath[e.Path] = append(byPath[e.Path], &Diagnostic{Severity: severity, Beg: loc, End: loc.Clone(), Message: e.Text})
+	}
+	for path, diags := range byPath {
+		setDiagnostics(path, source, diags)
+	}
+}
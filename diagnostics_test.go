@@ -0,0 +1,79 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/gdamore/tcell"
+)
+
+func TestDiagnostics(t *testing.T) {
+	path := tempFile(t, "package main\n\nfunc main() {\n\tfoo()\n}\n")
+	h := newHeadless(80, 24, []string{path})
+	if h.Line(0) != "1 package main" {
+		t.Fatalf("expected no sign column, got %q", h.Line(0))
+	}
+
+	scriptRun(`
+		ry.buffer():set_diagnostics("lint", {
+			{line = 4, col = 2, end_col = 5, severity = "error", message = "undefined: foo"},
+			{line = 1, col = 1, severity = "warning", message = "missing doc"},
+		})
+	`)
+	render()
+	if h.Line(0) != "1 W package main" || h.Line(3) != "4 E >   foo()" {
+		t.Fatalf("expected signs, got %q and %q", h.Line(0), h.Line(3))
+	}
+	cells, width, _ := h.sim.GetContents()
+	if _, _, attrs := cells[3*width+8].Style.Decompose(); attrs&tcell.AttrUnderline == 0 {
+		t.Fatal("expected the range underlined")
+	}
+	if _, _, attrs := cells[3*width+11].Style.Decompose(); attrs&tcell.AttrUnderline != 0 {
+		t.Fatal("expected the rest of the line not underlined")
+	}
+	if h.Line(23) != "warning: missing doc" {
+		t.Fatalf("expected the cursor line's diagnostic, got %q", h.Line(23))
+	}
+
+	h.Keys("] d")
+	if line, char := h.Cursor(); line != 3 || char != 1 || h.Message() != "(2 of 2) undefined: foo" {
+		t.Fatalf("expected the error, got %d,%d %q", line, char, h.Message())
+	}
+	h.Keys("] d")
+	if h.Message() != "No more diagnostics" {
+		t.Fatalf("unexpected message %q", h.Message())
+	}
+	h.Keys("[ d")
+	if line, _ := h.Cursor(); line != 0 {
+		t.Fatalf("expected the warning, got line %d", line)
+	}
+
+	// Sources replace their own diagnostics only
+	publishQuickfixDiagnostics("make", []*quickfixEntry{{Path: path, Line: 4, Text: "syntax error"}})
+	if n := len(bufferDiagnostics(h.Buffer())); n != 3 {
+		t.Fatalf("expected 3 diagnostics, got %d", n)
+	}
+
+	// Diagnostics follow edits until published again
+	h.Keys("g g O ESC")
+	if h.Line(1) != "2 W package main" || h.Line(4) != "5 E >   foo()" {
+		t.Fatalf("expected signs moved down, got %q and %q", h.Line(1), h.Line(4))
+	}
+	for _, d := range bufferDiagnostics(h.Buffer()) {
+		if d.Message == "syntax error" && (d.Beg.Line != 5 || d.End.Line != 5) {
+			t.Fatalf("expected the make diagnostic moved down once, got lines %d-%d", d.Beg.Line, d.End.Line)
+		}
+	}
+	publishQuickfixDiagnostics("make", nil)
+	if n := len(bufferDiagnostics(h.Buffer())); n != 2 {
+		t.Fatalf("expected 2 diagnostics, got %d", n)
+	}
+	h.Keys("j j j j d d")
+	if diags := bufferDiagnostics(h.Buffer()); len(diags) != 1 || diags[0].Severity != DiagnosticWarning {
+		t.Fatalf("expected the error dropped with its line, got %d diagnostics", len(diags))
+	}
+
+	h.Keys(": s e t SPC s i g n _ c o l u m n = n o RET")
+	if h.Line(1) != "2 package main" {
+		t.Fatalf("expected the sign column hidden, got %q", h.Line(0))
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"sort"
+	"unicode"
+)
+
+// Fuzzy matching finds the runes of a pattern in order in a string,
+// ignoring case. Matches score more when runes follow each other or
+// start a word or path segment, and when the string is short.
+
+type fuzzyMatch struct {
+	str       string
+	score     int
+	positions []int // rune indexes of the matched runes
+}
+
+const (
+	fuzzyScoreMatch       = 16
+	fuzzyBonusConsecutive = 24
+	fuzzyBonusBoundary    = 20
+	fuzzyPenaltyGap       = 1
+)
+
+// Matches pattern against str, returning ok false when it doesn't match
+func fuzzyMatchString(pattern, str string) (match fuzzyMatch, ok bool) {
+	p := []rune(pattern)
+	s := []rune(str)
+	match.str = str
+	if len(p) == 0 {
+		return match, true
+	}
+
+	// Greedy forward match, then tighten it by matching backward from its
+	// end, which prefers the last occurrence's compact span
+	pi, end := 0, -1
+	for si := 0; si < len(s) && pi < len(p); si++ {
+		if unicode.ToLower(s[si]) == unicode.ToLower(p[pi]) {
+			pi++
+			if pi == len(p) {
+				end = si
+			}
+		}
+	}
+	if end < 0 {
+		return match, false
+	}
+	positions := make([]int, len(p))
+	pi = len(p) - 1
+	for si := end; si >= 0 && pi >= 0; si-- {
+		if unicode.ToLower(s[si]) == unicode.ToLower(p[pi]) {
+			positions[pi] = si
+			pi--
+		}
+	}
+
+	score := 0
+	for i, pos := range positions {
+		score += fuzzyScoreMatch
+		if i > 0 && positions[i-1] == pos-1 {
+			score += fuzzyBonusConsecutive
+		} else if i > 0 {
+			score -= (pos - positions[i-1] - 1) * fuzzyPenaltyGap
+		}
+		if pos == 0 || isBoundary(s[pos-1], s[pos]) {
+			score += fuzzyBonusBoundary
+		}
+	}
+	score -= len(s) / 4
+	match.score = score
+	match.positions = positions
+	return match, true
+}
+
+func isBoundary(prev, r rune) bool {
+	switch prev {
+	case '/', '\\', '_', '-', '.', ' ':
+		return true
+	}
+	return unicode.IsLower(prev) && unicode.IsUpper(r)
+}
+
+// Returns the candidates matching pattern, best first
+func fuzzyFilter(pattern string, candidates []string) []fuzzyMatch {
+	matches := []fuzzyMatch{}
+	for _, c := range candidates {
+		if m, ok := fuzzyMatchString(pattern, c); ok {
+			matches = append(matches, m)
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].score != matches[j].score {
+			return matches[i].score > matches[j].score
+		}
+		if len(matches[i].str) != len(matches[j].str) {
+			return len(matches[i].str) < len(matches[j].str)
+		}
+		return matches[i].str < matches[j].str
+	})
+	return matches
+}
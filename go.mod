@@ -1,14 +1,16 @@
 module github.com/kiasaki/ry
 
-go 1.13
+go 1.16
 
 require (
+	github.com/BurntSushi/toml v1.3.2
 	github.com/gdamore/encoding v1.0.0
 	github.com/gdamore/tcell v1.3.0
 	github.com/go-errors/errors v1.0.0
 	github.com/kr/pty v1.1.1
 	github.com/lucasb-eyer/go-colorful v1.0.3
 	github.com/mattn/go-runewidth v0.0.8
+	github.com/yuin/gopher-lua v1.1.1
 	github.com/zyedidia/clipboard v0.0.0-20180208191628-4611e809d8b1
 	golang.org/x/sys v0.0.0-20200223170610-d5e6a3e2c0ae // indirect
 	golang.org/x/text v0.3.2
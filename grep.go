@@ -0,0 +1,449 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// :grep searches the files of the project for a regexp, streaming hits
+// into the *grep* buffer as "file:line:col: text" lines. Files are paths
+// relative to the search's base directory.
+
+type grepSearch struct {
+	re      *regexp.Regexp
+	base    string
+	done    chan struct{}
+	running bool
+	hits    int
+	files   map[string]bool
+	// Text of hit lines by file:line, as last read from the files, for
+	// :apply to find what was edited
+	originals map[string]string
+}
+
+// Searches by grep buffer, the last one started for each
+var grepSearches = map[*Buffer]*grepSearch{}
+
+var grepLineRegexp = regexp.MustCompile(`^(.+?):(\d+):(\d+): ?(.*)$`)
+var rgLineRegexp = regexp.MustCompile(`^(.+?):(\d+):(\d+):(.*)$`)
+
+func initGrep() {
+	grepSearches = map[*Buffer]*grepSearch{}
+
+	addOption("grep_rg", true, "Delegate :grep to rg when it's installed")
+
+	addMode("grep")
+	mustFindMode("grep").normalOnly = true
+	bindDesc("grep", k("RET"), "Go to hit", func(vt *ViewTree, b *Buffer, kl *KeyList) {
+		grepJump(b, b.Cursor.Line)
+	})
+	bindDesc("grep", k("n"), "Next hit", func(vt *ViewTree, b *Buffer, kl *KeyList) {
+		grepMove(b, 1)
+	})
+	bindDesc("grep", k("p"), "Previous hit", func(vt *ViewTree, b *Buffer, kl *KeyList) {
+		grepMove(b, -1)
+	})
+	bindDesc("grep", k("C-c"), "Cancel search", func(vt *ViewTree, b *Buffer, kl *KeyList) {
+		grepCancel(b)
+	})
+	bindDesc("grep", k("q"), "Close", func(vt *ViewTree, b *Buffer, kl *KeyList) {
+		grepCancel(b)
+		closeCurrentBuffer(true)
+	})
+
+	addCommandDesc("grep", "Searches files under the project root, or path, for a regexp", func(args []string) {
+		if len(args) < 2 || args[1] == "" {
+			messageError("Usage: grep <pattern> [path]")
+			return
+		}
+		path := ""
+		if len(args) > 2 {
+			path = args[2]
+		}
+		grep(args[1], path)
+	})
+	addCommandCompletion("grep", completePath)
+	addCommandDesc("apply", "Writes lines edited in the *grep* buffer back to their files", func(args []string) {
+		b := currentViewTree.Leaf.Buf
+		if _, ok := grepSearches[b]; !ok {
+			b = findBuffer("*grep*")
+		}
+		if _, ok := grepSearches[b]; b == nil || !ok {
+			messageError("No grep results to apply")
+			return
+		}
+		grepApply(b)
+	})
+
+	hook_buffer("closed", func(b *Buffer) {
+		if s, ok := grepSearches[b]; ok {
+			s.Cancel()
+			delete(grepSearches, b)
+		}
+	})
+}
+
+// Starts searching for pattern under path, or the current project root
+// when empty, showing hits in the *grep* buffer as they come
+func grep(pattern, path string) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		messageError("Invalid pattern: " + err.Error())
+		return
+	}
+	root, base := currentProjectRoot(), currentProjectRoot()
+	if path != "" {
+		if root, err = filepath.Abs(path); err != nil {
+			messageError(err.Error())
+			return
+		}
+		base, _ = os.Getwd()
+	}
+
+	b := findBuffer("*grep*")
+	if b == nil {
+		b = openBufferNamed("*grep*")
+		b.AddMode("grep")
+	}
+	if s, ok := grepSearches[b]; ok {
+		s.Cancel()
+	}
+	s := &grepSearch{re: re, base: base, done: make(chan struct{}), running: true, files: map[string]bool{}, originals: map[string]string{}}
+	grepSearches[b] = s
+	b.Data = [][]rune{[]rune{}}
+	b.Cursor = NewLocation(0, 0)
+	b.Modified = false
+	// Edits of the previous hits can't be undone on new ones
+	b.History = []*Action{}
+	b.HistoryIndex = -1
+	hook_trigger_buffer("modified", b)
+	showBuffer(b.Name)
+	message("Searching for " + pattern + "...")
+
+	add := func(lines []string) {
+		runInMainLoop(func() {
+			if grepSearches[b] != s || !s.running {
+				return
+			}
+			s.Add(b, lines)
+		})
+	}
+	finish := func(err error) {
+		runInMainLoop(func() {
+			if grepSearches[b] != s || !s.running {
+				return
+			}
+			s.running = false
+			if err != nil {
+				messageError("Grep failed: " + err.Error())
+				return
+			}
+			message("Grep: " + strconv.Itoa(s.hits) + " hits in " + strconv.Itoa(len(s.files)) + " files")
+		})
+	}
+
+	if rg, err := exec.LookPath("rg"); err == nil && configGetBool("grep_rg", nil) {
+		go grepRg(rg, pattern, root, base, s.done, add, finish)
+	} else {
+		go grepWalk(re, root, base, s.done, add, finish)
+	}
+}
+
+// Stops the search, leaving the hits found so far
+func (s *grepSearch) Cancel() {
+	if s.running {
+		s.running = false
+		close(s.done)
+		message("Grep cancelled")
+	}
+}
+
+func grepCancel(b *Buffer) {
+	if s, ok := grepSearches[b]; ok && s.running {
+		s.Cancel()
+	}
+}
+
+// Appends hit lines to b
+func (s *grepSearch) Add(b *Buffer, lines []string) {
+	for _, line := range lines {
+		if m := grepLineRegexp.FindStringSubmatch(line); m != nil {
+			s.files[m[1]] = true
+			s.originals[m[1]+":"+m[2]] = m[4]
+		}
+		s.hits++
+		if len(b.Data) == 1 && len(b.Data[0]) == 0 {
+			b.Data[0] = []rune(line)
+		} else {
+			b.Data = append(b.Data, []rune(line))
+		}
+	}
+	hook_trigger_buffer("modified", b)
+}
+
+// Searches the files under root in pure Go, a few at a time
+func grepWalk(re *regexp.Regexp, root, base string, done chan struct{}, add func([]string), finish func(error)) {
+	paths := make(chan string)
+	go func() {
+		walkProject(root, done, func(path string) bool {
+			select {
+			case paths <- path:
+				return true
+			case <-done:
+				return false
+			}
+		})
+		close(paths)
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < runtime.NumCPU(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range paths {
+				rel, err := filepath.Rel(base, path)
+				if err != nil {
+					rel = path
+				}
+				if lines := grepFile(re, path, rel); len(lines) > 0 {
+					add(lines)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	finish(nil)
+}
+
+// Hits of re in the file at path, named name in them
+func grepFile(re *regexp.Regexp, path, name string) []string {
+	data, err := ioutil.ReadFile(path)
+	if err != nil || bytes.IndexByte(data[:min(len(data), 8000)], 0) >= 0 {
+		return nil
+	}
+	lines := []string{}
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if loc := re.FindStringIndex(line); loc != nil {
+			col := utf8.RuneCountInString(line[:loc[0]]) + 1
+			lines = append(lines, name+":"+strconv.Itoa(i+1)+":"+strconv.Itoa(col)+": "+line)
+		}
+	}
+	return lines
+}
+
+// Searches with ripgrep, which takes care of .gitignore files itself
+func grepRg(rg, pattern, root, base string, done chan struct{}, add func([]string), finish func(error)) {
+	cmd := exec.Command(rg, "--vimgrep", "--no-heading", "--color", "never", "-e", pattern, root)
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		finish(err)
+		return
+	}
+	if err := cmd.Start(); err != nil {
+		finish(err)
+		return
+	}
+	go func() {
+		<-done
+		cmd.Process.Kill()
+	}()
+
+	lines := []string{}
+	seen := map[string]bool{}
+	scanner := bufio.NewScanner(out)
+	for scanner.Scan() {
+		m := rgLineRegexp.FindStringSubmatch(scanner.Text())
+		if m == nil {
+			continue
+		}
+		// --vimgrep gives every match, hits are lines
+		key := m[1] + ":" + m[2]
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		rel, err := filepath.Rel(base, m[1])
+		if err != nil {
+			rel = m[1]
+		}
+		// rg columns count bytes
+		col, _ := strconv.Atoi(m[3])
+		col = utf8.RuneCountInString(m[4][:min(max(col-1, 0), len(m[4]))]) + 1
+		lines = append(lines, rel+":"+m[2]+":"+strconv.Itoa(col)+": "+m[4])
+		if len(lines) >= 100 {
+			add(lines)
+			lines = []string{}
+		}
+	}
+	if len(lines) > 0 {
+		add(lines)
+	}
+	// rg exits with 1 when nothing matched
+	err = cmd.Wait()
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
+		err = nil
+	}
+	select {
+	case <-done:
+		err = nil
+	default:
+	}
+	finish(err)
+}
+
+// Location a grep buffer line points to, ok false if it's not a hit
+func grepLocation(b *Buffer, line int) (path string, l, c int, ok bool) {
+	if line < 0 || line >= len(b.Data) {
+		return "", 0, 0, false
+	}
+	m := grepLineRegexp.FindStringSubmatch(string(b.Data[line]))
+	if m == nil {
+		return "", 0, 0, false
+	}
+	l, _ = strconv.Atoi(m[2])
+	c, _ = strconv.Atoi(m[3])
+	path = m[1]
+	if s, ok := grepSearches[b]; ok && !filepath.IsAbs(path) {
+		path = filepath.Join(s.base, path)
+	}
+	return path, l - 1, c - 1, true
+}
+
+func grepJump(b *Buffer, line int) {
+	path, l, c, ok := grepLocation(b, line)
+	if !ok {
+		return
+	}
+	if fb := showFile(path); fb != nil {
+		fb.MoveTo(c, l)
+		currentViewTree.Leaf.CenterPending = true
+	}
+}
+
+// Moves the cursor to the next hit, or the previous one when n is -1
+func grepMove(b *Buffer, n int) {
+	for line := b.Cursor.Line + n; line >= 0 && line < len(b.Data); line += n {
+		if _, _, _, ok := grepLocation(b, line); ok {
+			b.MoveTo(0, line)
+			return
+		}
+	}
+	message("No more hits")
+}
+
+// Runes of line l in a grep buffer that match the search, for highlighting
+func grepHighlights(b *Buffer, l int) []bool {
+	s, ok := grepSearches[b]
+	if !ok {
+		return nil
+	}
+	m := grepLineRegexp.FindStringSubmatchIndex(string(b.Data[l]))
+	if m == nil {
+		return nil
+	}
+	line := string(b.Data[l])
+	text := line[m[8]:]
+	marks := make([]bool, len(b.Data[l]))
+	offset := utf8.RuneCountInString(line[:m[8]])
+	for _, loc := range s.re.FindAllStringIndex(text, -1) {
+		from := offset + utf8.RuneCountInString(text[:loc[0]])
+		to := offset + utf8.RuneCountInString(text[:loc[1]])
+		for i := from; i < to; i++ {
+			marks[i] = true
+		}
+	}
+	return marks
+}
+
+type grepChange struct {
+	key  string
+	line int
+	text string
+}
+
+// Writes the hit lines of b whose text was edited back to their files,
+// each file's changes undone in one step. Files whose line changed since
+// the search are left alone.
+func grepApply(b *Buffer) {
+	s := grepSearches[b]
+	changes := map[string][]grepChange{}
+	paths := []string{}
+	for i, line := range b.Data {
+		m := grepLineRegexp.FindStringSubmatch(string(line))
+		if m == nil {
+			continue
+		}
+		key := m[1] + ":" + m[2]
+		if original, ok := s.originals[key]; !ok || original == m[4] {
+			continue
+		}
+		path, l, _, _ := grepLocation(b, i)
+		if _, ok := changes[path]; !ok {
+			paths = append(paths, path)
+		}
+		changes[path] = append(changes[path], grepChange{key: key, line: l, text: m[4]})
+	}
+	if len(paths) == 0 {
+		message("No changes to apply")
+		return
+	}
+	sort.Strings(paths)
+
+	applied, files, conflicts, failed := 0, 0, 0, []string{}
+	for _, path := range paths {
+		fb := findBufferByPath(path)
+		if fb == nil {
+			if fb = openBufferFromFile(path); fb == nil {
+				failed = append(failed, relativePath(path))
+				continue
+			}
+		}
+		n := 0
+		fb.BeginUndoGroup()
+		for _, c := range changes[path] {
+			if c.line >= len(fb.Data) || string(fb.Data[c.line]) != s.originals[c.key] {
+				conflicts++
+				continue
+			}
+			fb.RemoveAt(NewLocation(c.line, 0), len(fb.Data[c.line]))
+			fb.InsertAt(NewLocation(c.line, 0), []rune(c.text))
+			s.originals[c.key] = c.text
+			n++
+		}
+		fb.EndUndoGroup()
+		if n == 0 {
+			continue
+		}
+		fb.Save()
+		if fb.Modified {
+			failed = append(failed, relativePath(path))
+			continue
+		}
+		applied += n
+		files++
+	}
+	b.Modified = false
+
+	summary := "Applied " + strconv.Itoa(applied) + " changes to " + strconv.Itoa(files) + " files"
+	if conflicts > 0 {
+		summary += ", skipped " + strconv.Itoa(conflicts) + " lines changed since the search"
+	}
+	if len(failed) > 0 {
+		messageError(summary + ", failed to write " + strings.Join(failed, ", "))
+		return
+	}
+	message(summary)
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func waitGrep(t *testing.T, h *headless) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		s, ok := grepSearches[findBuffer("*grep*")]
+		if !ok || !s.running {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("grep still running")
+		}
+		time.Sleep(5 * time.Millisecond)
+		h.Process()
+	}
+}
+
+func TestGrep(t *testing.T) {
+	dir := writeProject(t, map[string]string{
+		".git/HEAD":   "",
+		".gitignore":  "vendor/\n",
+		"main.go":     "package main\n\nfunc main() {\n\thello()\n}\n",
+		"hello.go":    "package main\n\n// hello says hello\nfunc hello() {}\n",
+		"vendor/x.go": "func hello() {}\n",
+		"data.bin":    "hello\x00",
+	})
+	defer os.RemoveAll(dir)
+
+	h := newHeadless(80, 24, []string{filepath.Join(dir, "main.go")})
+	configSet("grep_rg", false)
+	h.Keys(": g r e p SPC ' h e l l o \\ ( ' RET")
+	waitGrep(t, h)
+	b := h.Buffer()
+	if b.Name != "*grep*" || h.Mode() != "normal+grep" {
+		t.Fatalf("expected the grep buffer, got %s in %s", b.Name, h.Mode())
+	}
+	lines := []string{}
+	for _, line := range b.Data {
+		lines = append(lines, string(line))
+	}
+	sort.Strings(lines)
+	expected := []string{"hello.go:4:6: func hello() {}", "main.go:4:2: \thello()"}
+	if len(lines) != 2 || lines[0] != expected[0] || lines[1] != expected[1] {
+		t.Fatalf("expected %q, got %q", expected, lines)
+	}
+	if h.Message() != "Grep: 2 hits in 2 files" {
+		t.Fatalf("unexpected message %q", h.Message())
+	}
+	line := string(b.Data[0])
+	at := strings.LastIndex(line, "hello(")
+	marks := grepHighlights(b, 0)
+	if marks == nil || marks[at-1] || !marks[at] || !marks[at+5] || marks[at+6] {
+		t.Fatalf("expected hello( highlighted in %q, got %v", line, marks)
+	}
+
+	h.Keys("g g n p n")
+	if line, _ := h.Cursor(); line != 1 {
+		t.Fatalf("expected the second hit, got line %d", line)
+	}
+	path, l, c, _ := grepLocation(b, 1)
+	h.Keys("RET")
+	if h.Buffer().Path != path {
+		t.Fatalf("expected %s opened, got %s", path, h.Buffer().Path)
+	}
+	if line, char := h.Cursor(); line != l || char != c {
+		t.Fatalf("expected cursor at %d,%d, got %d,%d", l, c, line, char)
+	}
+}
+
+func TestGrepCancel(t *testing.T) {
+	h := newHeadless(80, 24, nil)
+	configSet("grep_rg", false)
+	grep("package", ".")
+	h.Keys("C-c")
+	s := grepSearches[findBuffer("*grep*")]
+	if s.running || h.Message() != "Grep cancelled" {
+		t.Fatalf("expected the search cancelled, got %q", h.Message())
+	}
+	// Hits found after cancelling are dropped
+	n := len(h.Buffer().Data)
+	time.Sleep(20 * time.Millisecond)
+	h.Process()
+	if len(h.Buffer().Data) != n {
+		t.Fatal("expected no hits added after cancelling")
+	}
+}
+
+func TestGrepWalkFile(t *testing.T) {
+	dir := writeProject(t, map[string]string{
+		"a.go": "package a\n\nfunc hello() {}\n",
+		"b.go": "package b\n\nfunc hello() {}\n",
+	})
+	defer os.RemoveAll(dir)
+
+	hits := []string{}
+	grepWalk(regexp.MustCompile("hello"), filepath.Join(dir, "a.go"), dir, make(chan struct{}), func(lines []string) {
+		hits = append(hits, lines...)
+	}, func(err error) {})
+	if len(hits) != 1 || hits[0] != "a.go:3:6: func hello() {}" {
+		t.Fatalf("expected the file searched, got %q", hits)
+	}
+}
+
+func TestGrepAgainClearsHistory(t *testing.T) {
+	dir := writeProject(t, map[string]string{
+		".git/HEAD": "",
+		"a.go":      "package a\n\nfunc hello() {}\n",
+	})
+	defer os.RemoveAll(dir)
+
+	h := newHeadless(80, 24, []string{filepath.Join(dir, "a.go")})
+	configSet("grep_rg", false)
+	h.Keys(": g r e p SPC h e l l o RET")
+	waitGrep(t, h)
+	h.Keys(": % s / h e l l o / b y e / RET")
+	showFile(filepath.Join(dir, "a.go"))
+	h.Keys(": g r e p SPC p a c k a g e RET")
+	waitGrep(t, h)
+	h.Keys("u")
+	expectContents(t, h, "a.go:1:1: package a\n")
+}
+
+func TestGrepApply(t *testing.T) {
+	dir := writeProject(t, map[string]string{
+		"go.mod":  "module example\n",
+		"a.go":    "package main\n\nfunc oldName() {}\n",
+		"b.go":    "package main\n\nvar x = oldName\nvar y = oldName\n",
+		"c.go":    "package main\n\n// oldName\n",
+		"main.go": "package main\n",
+	})
+	defer os.RemoveAll(dir)
+
+	h := newHeadless(80, 24, []string{filepath.Join(dir, "main.go")})
+	configSet("grep_rg", false)
+	h.Keys(": g r e p SPC o l d N a m e RET")
+	waitGrep(t, h)
+	if len(h.Buffer().Data) != 4 {
+		t.Fatalf("expected 4 hits, got %q", h.Contents())
+	}
+
+	// c.go changed on disk since the search, in an open buffer
+	c := showFile(filepath.Join(dir, "c.go"))
+	c.MoveTo(0, 2)
+	c.Insert([]rune("x"))
+	h.Keys(": b SPC * g r e p * RET")
+
+	// Names in front of hits are left alone
+	h.Keys(": % s / o l d / n e w / RET")
+	if h.Message() != "4 substitutions on 4 lines" || !h.Buffer().Modified {
+		t.Fatalf("unexpected message %q", h.Message())
+	}
+	h.Keys(": a p p l y RET")
+	if h.Message() != "Applied 3 changes to 2 files, skipped 1 lines changed since the search" {
+		t.Fatalf("unexpected message %q", h.Message())
+	}
+	if h.Buffer().Modified {
+		t.Fatal("expected the grep buffer unmodified once applied")
+	}
+	for name, expected := range map[string]string{
+		"a.go": "package main\n\nfunc newName() {}\n",
+		"b.go": "package main\n\nvar x = newName\nvar y = newName\n",
+	} {
+		data, _ := ioutil.ReadFile(filepath.Join(dir, name))
+		if string(data) != expected {
+			t.Errorf("%s: unexpected contents %q", name, data)
+		}
+	}
+
+	// Each file's changes are undone as one
+	b := findBufferByPath(filepath.Join(dir, "b.go"))
+	b.Undo()
+	if b.Contents() != "package main\n\nvar x = oldName\nvar y = oldName\n" {
+		t.Fatalf("unexpected contents after undo %q", b.Contents())
+	}
+
+	// The grep buffer is editable in insert mode
+	h.Keys("g g A")
+	h.Keys("p n q ESC")
+	if line := string(h.Buffer().Data[0]); line[len(line)-3:] != "pnq" {
+		t.Fatalf("expected keys inserted, got %q", line)
+	}
+}
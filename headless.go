@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/gdamore/tcell"
+)
+
+// Drives the editor on a simulated screen, without a terminal. Keys go
+// through the same dispatch as in main() and the screen is rendered after
+// each of them, so tests can look at buffers, modes and rendered cells.
+type headless struct {
+	sim tcell.SimulationScreen
+}
+
+func newHeadless(width, height int, files []string) *headless {
+	h := newHeadlessScreen(width, height)
+	h.Open(files)
+	return h
+}
+
+// Sets up the simulated screen and the editor, without opening buffers
+func newHeadlessScreen(width, height int) *headless {
+	sim := tcell.NewSimulationScreen("UTF-8")
+	fatalError(sim.Init())
+	sim.SetSize(width, height)
+	screen = sim
+	editorWidth, editorHeight = width, height
+
+	initEditor()
+	return &headless{sim: sim}
+}
+
+// Opens files as if given on the command line
+func (h *headless) Open(files []string) {
+	initBuffers(files)
+	initViews()
+	render()
+}
+
+// Runs ry with no terminal: handles keys then prints the current buffer,
+// returning the exit status
+func runHeadless(keys string, files []string) int {
+	h := newHeadlessScreen(80, 24)
+	loadConfigFile()
+	loadInitScript()
+	h.Open(files)
+	h.Keys(keys)
+	h.Timeout()
+	if editorMessageType == "error" && editorMessage != "" {
+		fmt.Fprintln(os.Stderr, editorMessage)
+	}
+	fmt.Print(h.Contents())
+	if editorMessageType == "error" && editorMessage != "" {
+		return 1
+	}
+	return 0
+}
+
+// Handles keys given in key list notation, as k() takes them
+func (h *headless) Keys(keys string) {
+	for _, key := range k(keys).keys {
+		if editorQuitting {
+			return
+		}
+		handleEvent(eventFromKey(key))
+		render()
+	}
+}
+
+// Runs the keys waiting for a longer binding as if the timeout passed
+func (h *headless) Timeout() {
+	dispatchKeys(true)
+	render()
+}
+
+// Runs the functions queued with runInMainLoop, as the main loop would
+func (h *headless) Process() {
+	for {
+		select {
+		case f := <-mainLoopFuncs:
+			f()
+			render()
+		default:
+			return
+		}
+	}
+}
+
+// Returns the terminal event typing key would produce
+func eventFromKey(key *Key) *tcell.EventKey {
+	if key.Key != tcell.KeyRune {
+		return tcell.NewEventKey(key.Key, 0, key.Mod)
+	}
+	if key.Mod&tcell.ModCtrl != 0 && isAlpha(key.Chr) {
+		// terminals send control characters for these
+		return tcell.NewEventKey(tcell.KeyRune, key.Chr&0x1f, tcell.ModNone)
+	}
+	return tcell.NewEventKey(tcell.KeyRune, key.Chr, key.Mod)
+}
+
+func (h *headless) Buffer() *Buffer {
+	return currentViewTree.Leaf.Buf
+}
+
+func (h *headless) Contents() string {
+	return h.Buffer().Contents()
+}
+
+func (h *headless) Cursor() (line, char int) {
+	return h.Buffer().Cursor.Line, h.Buffer().Cursor.Char
+}
+
+// Editor mode followed by the current buffer's modes, as shown in the
+// status bar (e.g. "normal+visual")
+func (h *headless) Mode() string {
+	return strings.Join(append([]string{editorMode}, h.Buffer().Modes...), "+")
+}
+
+func (h *headless) Message() string {
+	return editorMessage
+}
+
+// Rendered text of screen row y, trailing blanks trimmed
+func (h *headless) Line(y int) string {
+	cells, width, _ := h.sim.GetContents()
+	line := []rune{}
+	for x := 0; x < width; x++ {
+		cell := cells[y*width+x]
+		if len(cell.Runes) == 0 {
+			line = append(line, ' ')
+		} else {
+			line = append(line, cell.Runes...)
+		}
+	}
+	return strings.TrimRight(string(line), " ")
+}
+
+func (h *headless) Cell(x, y int) (rune, tcell.Style) {
+	r, _, style, _ := h.sim.GetContent(x, y)
+	return r, style
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Writes contents to a file in a new temporary directory, returning its path
+func tempFile(t *testing.T, contents string) string {
+	path := filepath.Join(t.TempDir(), "file.txt")
+	if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func expectContents(t *testing.T, h *headless, expected string) {
+	t.Helper()
+	if actual := h.Contents(); actual != expected {
+		t.Fatalf("expected contents %q, got %q", expected, actual)
+	}
+}
+
+func expectCursor(t *testing.T, h *headless, line, char int) {
+	t.Helper()
+	if l, c := h.Cursor(); l != line || c != char {
+		t.Fatalf("expected cursor at %d,%d, got %d,%d", line, char, l, c)
+	}
+}
+
+func TestHeadlessInsert(t *testing.T) {
+	h := newHeadless(80, 24, nil)
+	h.Keys("i h e l l o SPC w o r l d ESC")
+	expectContents(t, h, "hello world\n")
+	expectCursor(t, h, 0, 10)
+	if h.Mode() != "normal" {
+		t.Fatalf("expected normal mode, got %s", h.Mode())
+	}
+	h.Keys("o n e x t RET l i n e C-c")
+	expectContents(t, h, "hello world\nnext\nline\n")
+}
+
+func TestHeadlessMotions(t *testing.T) {
+	h := newHeadless(80, 24, []string{tempFile(t, "one two three\nfour\nfive\n")})
+	h.Keys("w w")
+	expectCursor(t, h, 0, 8)
+	h.Keys("b")
+	expectCursor(t, h, 0, 4)
+	h.Keys("e")
+	expectCursor(t, h, 0, 6)
+	h.Keys("$")
+	expectCursor(t, h, 0, 13)
+	h.Keys("G")
+	expectCursor(t, h, 2, 0)
+	h.Keys("g g l l j")
+	expectCursor(t, h, 1, 2)
+}
+
+func TestHeadlessUndoRedo(t *testing.T) {
+	h := newHeadless(80, 24, []string{tempFile(t, "a\nb\nc\n")})
+	h.Keys("j d d")
+	expectContents(t, h, "a\nc\n")
+	h.Keys("u")
+	expectContents(t, h, "a\nb\nc\n")
+	h.Keys("C-r")
+	expectContents(t, h, "a\nc\n")
+	h.Keys("x")
+	expectContents(t, h, "a\n\n")
+}
+
+func TestHeadlessVisual(t *testing.T) {
+	h := newHeadless(80, 24, []string{tempFile(t, "one two three\n")})
+	h.Keys("w v e")
+	if h.Mode() != "normal+visual" {
+		t.Fatalf("expected visual mode, got %s", h.Mode())
+	}
+	h.Keys("d")
+	expectContents(t, h, "one  three\n")
+	if h.Mode() != "normal" {
+		t.Fatalf("expected normal mode, got %s", h.Mode())
+	}
+}
+
+func TestHeadlessWriteAndQuit(t *testing.T) {
+	path := tempFile(t, "text\n")
+	h := newHeadless(80, 24, []string{path})
+	h.Keys("A ! ESC : w RET")
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "text!\n" {
+		t.Fatalf("expected file to be written, got %q", string(data))
+	}
+	h.Keys(": q RET")
+	if !editorQuitting {
+		t.Fatal("expected closing the last buffer to quit")
+	}
+}
+
+func TestHeadlessRender(t *testing.T) {
+	h := newHeadless(40, 10, []string{tempFile(t, "first\nsecond\n")})
+	if h.Line(0) != "1 first" || h.Line(1) != "2 second" {
+		t.Fatalf("unexpected text rows %q %q", h.Line(0), h.Line(1))
+	}
+	status := h.Line(8)
+	if !strings.HasPrefix(status, " normal  file.txt") || !strings.HasSuffix(status, "(1,1) 2") {
+		t.Fatalf("unexpected status bar %q", status)
+	}
+	h.Keys(": e d i t")
+	if h.Line(9) != ":edit" {
+		t.Fatalf("unexpected prompt %q", h.Line(9))
+	}
+	if r, s := h.Cell(2, 0); r != 'f' || s != style("cursor") {
+		t.Fatalf("expected cursor on first char, got %q", r)
+	}
+}
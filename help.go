@@ -0,0 +1,269 @@
+package main
+
+import (
+	"embed"
+	"path"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// Help is made of text files embedded in the binary. In them, *tag* marks
+// a place help can be opened at and |tag| links to it.
+
+//go:embed help/*.txt
+var helpFiles embed.FS
+
+type helpLocation struct {
+	topic string
+	line  int
+}
+
+var (
+	helpTags    map[string]helpLocation
+	helpHistory []helpLocation
+	helpTopic   = "" // shown in the help buffer
+
+	// Set by :describe-key, the next key sequence is described, not run
+	describingKeys = false
+)
+
+func initHelp() {
+	helpTags = nil
+	helpHistory = []helpLocation{}
+	helpTopic = ""
+	describingKeys = false
+
+	addMode("help")
+	bindDesc("help", k("RET"), "Follow link", helpFollowLink)
+	bindDesc("help", k("C-]"), "Follow link", helpFollowLink)
+	bindDesc("help", k("C-o"), "Back", helpBack)
+	bindDesc("help", k("q"), "Close help", func(vt *ViewTree, b *Buffer, kl *KeyList) {
+		closeCurrentBuffer(true)
+	})
+
+	addCommandDesc("help", "Opens help on a topic, command or option", func(args []string) {
+		topic := "index"
+		if len(args) > 1 && args[1] != "" {
+			topic = args[1]
+		}
+		loc, ok := findHelp(topic)
+		if !ok {
+			messageError("No help for '" + topic + "'")
+			return
+		}
+		helpHistory = []helpLocation{}
+		showHelp(loc)
+	})
+	addAlias("h", "help")
+	addCommandCompletion("help", func(prefix string) []string {
+		if helpTags == nil {
+			loadHelpTags()
+		}
+		topics := helpTopics()
+		for tag := range helpTags {
+			topics = append(topics, tag)
+		}
+		return completeFrom(prefix, topics)
+	})
+	addCommandDesc("describe-key", "Waits for a key sequence then tells which binding handles it", func(args []string) {
+		describingKeys = true
+		message("Describe key: type a key sequence")
+	})
+	addCommandDesc("describe-command", "Tells what a command does", func(args []string) {
+		if len(args) < 2 {
+			messageError("Usage: describe-command <name>")
+			return
+		}
+		describeCommand(args[1])
+	})
+	addCommandCompletion("describe-command", completeCommandName)
+	addCommandDesc("tutor", "Opens the tutorial in a scratch buffer", func(args []string) {
+		openTutor()
+	})
+}
+
+func helpTopics() []string {
+	topics := []string{}
+	entries, _ := helpFiles.ReadDir("help")
+	for _, entry := range entries {
+		topic := strings.TrimSuffix(entry.Name(), ".txt")
+		if topic != "tutor" {
+			topics = append(topics, topic)
+		}
+	}
+	sort.Strings(topics)
+	return topics
+}
+
+func helpLines(topic string) []string {
+	contents, err := helpFiles.ReadFile(path.Join("help", topic+".txt"))
+	if err != nil {
+		return nil
+	}
+	return strings.Split(strings.TrimRight(string(contents), "\n"), "\n")
+}
+
+// Indexes the *tags* of all help files
+func loadHelpTags() {
+	helpTags = map[string]helpLocation{}
+	for _, topic := range helpTopics() {
+		for i, line := range helpLines(topic) {
+			for _, word := range strings.Fields(line) {
+				if len(word) > 2 && strings.HasPrefix(word, "*") && strings.HasSuffix(word, "*") {
+					helpTags[word[1:len(word)-1]] = helpLocation{topic, i}
+				}
+			}
+		}
+	}
+}
+
+// Finds where help for topic is, trying tags, then topics, then commands
+// (with their aliases resolved)
+func findHelp(topic string) (helpLocation, bool) {
+	if helpTags == nil {
+		loadHelpTags()
+	}
+	if loc, ok := helpTags[topic]; ok {
+		return loc, true
+	}
+	if helpLines(topic) != nil {
+		return helpLocation{topic, 0}, true
+	}
+	name := strings.TrimPrefix(topic, ":")
+	if full, ok := commandAliases[name]; ok {
+		name = full
+	}
+	if loc, ok := helpTags[":"+name]; ok {
+		return loc, true
+	}
+	return helpLocation{}, false
+}
+
+// Shows the read-only help buffer at loc
+func showHelp(loc helpLocation) {
+	var b *Buffer
+	if b = findBuffer("*help*"); b == nil {
+		b = openBufferNamed("*help*")
+		b.AddMode("help")
+	}
+	b.Data = [][]rune{}
+	for _, line := range helpLines(loc.topic) {
+		b.Data = append(b.Data, []rune(line))
+	}
+	b.ReadOnly = true
+	helpTopic = loc.topic
+	hook_trigger_buffer("modified", b)
+	showBuffer(b.Name)
+	b.MoveTo(0, loc.line)
+	currentViewTree.Leaf.LineOffset = loc.line
+}
+
+// Returns the |link| under the cursor, if any
+func helpLinkUnderCursor(b *Buffer) string {
+	line := string(b.Data[b.Cursor.Line])
+	runes := []rune(line)
+	start := -1
+	for i, r := range runes {
+		if r != '|' {
+			continue
+		}
+		if start < 0 {
+			start = i
+			continue
+		}
+		if b.Cursor.Char >= start && b.Cursor.Char <= i && i > start+1 {
+			return string(runes[start+1 : i])
+		}
+		start = -1
+	}
+	return ""
+}
+
+func helpFollowLink(vt *ViewTree, b *Buffer, kl *KeyList) {
+	link := helpLinkUnderCursor(b)
+	if link == "" {
+		message("No link under cursor")
+		return
+	}
+	loc, ok := findHelp(link)
+	if !ok {
+		messageError("No help for '" + link + "'")
+		return
+	}
+	helpHistory = append(helpHistory, helpLocation{helpTopic, b.Cursor.Line})
+	showHelp(loc)
+}
+
+func helpBack(vt *ViewTree, b *Buffer, kl *KeyList) {
+	if len(helpHistory) == 0 {
+		message("No previous help location")
+		return
+	}
+	loc := helpHistory[len(helpHistory)-1]
+	helpHistory = helpHistory[:len(helpHistory)-1]
+	showHelp(loc)
+}
+
+// Reports which binding handles kl (nil if none) in the message bar
+func describeKeys(kl *KeyList, b *ModeBinding) {
+	if b == nil {
+		message(kl.String() + " is not bound")
+		return
+	}
+	what := "a function"
+	if name := runtime.FuncForPC(reflect.ValueOf(b.f).Pointer()).Name(); !strings.Contains(name, ".func") {
+		what = name[strings.LastIndex(name, ".")+1:]
+	}
+	if mapping := findMapping(b.mode, b.k); mapping != nil {
+		what = "'" + mapping.Rhs + "'"
+	}
+	desc := ""
+	if b.desc != "" && !strings.HasPrefix(what, "'") {
+		desc = ": " + b.desc
+	}
+	message(kl.String() + " runs " + what + " in " + b.mode + " mode" + desc)
+}
+
+func describeCommand(name string) {
+	full := name
+	if alias, ok := commandAliases[name]; ok {
+		full = alias
+	}
+	if _, ok := commands[full]; !ok {
+		messageError("No command named '" + name + "'")
+		return
+	}
+	aliases := []string{}
+	for alias, command := range commandAliases {
+		if command == full {
+			aliases = append(aliases, alias)
+		}
+	}
+	sort.Strings(aliases)
+	text := full
+	if len(aliases) > 0 {
+		text += " (" + strings.Join(aliases, ", ") + ")"
+	}
+	if desc := commandDescriptions[full]; desc != "" {
+		text += ": " + desc
+	} else {
+		text += " has no description"
+	}
+	message(text)
+}
+
+// Opens a scratch copy of the tutorial, free to be edited
+func openTutor() {
+	b := findBuffer("*tutor*")
+	if b == nil {
+		b = openBufferNamed("*tutor*")
+		b.Data = [][]rune{}
+		for _, line := range helpLines("tutor") {
+			b.Data = append(b.Data, []rune(line))
+		}
+		hook_trigger_buffer("modified", b)
+	}
+	showBuffer(b.Name)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHelpLinks(t *testing.T) {
+	h := newHeadless(80, 24, nil)
+	h.Keys(": h e l p RET")
+	b := h.Buffer()
+	if b.Name != "*help*" || !b.ReadOnly || !strings.HasPrefix(string(b.Data[0]), "*index*") {
+		t.Fatalf("expected the help index, got %q", b.Name)
+	}
+	h.Keys("x")
+	if h.Message() != "Buffer is read-only" {
+		t.Fatalf("expected help to be read-only, got %q", h.Message())
+	}
+
+	// Follow |keys| then come back
+	for b.Cursor.Line = 0; !strings.Contains(string(b.Data[b.Cursor.Line]), "|keys|"); b.Cursor.Line++ {
+	}
+	line := b.Cursor.Line
+	b.Cursor.Char = strings.Index(string(b.Data[line]), "|keys|") + 2
+	h.Keys("RET")
+	if !strings.HasPrefix(string(h.Buffer().Data[0]), "*keys*") {
+		t.Fatalf("expected the keys topic, got %q", string(h.Buffer().Data[0]))
+	}
+	h.Keys("C-o")
+	expectCursor(t, h, line, 0)
+
+	h.Keys(": h e l p SPC w q RET")
+	if l, _ := h.Cursor(); !strings.Contains(string(h.Buffer().Data[l]), "*:writequit*") {
+		t.Fatalf("expected help for :writequit, cursor is on %q", string(h.Buffer().Data[l]))
+	}
+	h.Keys(": h e l p SPC n o p e RET")
+	if h.Message() != "No help for 'nope'" {
+		t.Fatalf("unexpected message %q", h.Message())
+	}
+}
+
+func TestHelpTagsLinked(t *testing.T) {
+	loadHelpTags()
+	for _, topic := range helpTopics() {
+		for _, line := range helpLines(topic) {
+			parts := strings.Split(line, "|")
+			for i := 1; i < len(parts)-1; i += 2 {
+				if _, ok := findHelp(parts[i]); !ok && !strings.Contains(parts[i], " ") {
+					t.Errorf("%s links to unknown tag |%s|", topic, parts[i])
+				}
+			}
+		}
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	h := newHeadless(80, 24, nil)
+	h.Keys(": d e s c r i b e - k e y RET g g")
+	if h.Message() != "g g runs moveTop in normal mode: Go to top" {
+		t.Fatalf("unexpected message %q", h.Message())
+	}
+	h.Keys(": d e s c r i b e - k e y RET Q")
+	if h.Message() != "Q is not bound" {
+		t.Fatalf("unexpected message %q", h.Message())
+	}
+	h.Keys(": d e s c r i b e - c o m m a n d SPC w RET")
+	if h.Message() != "write (w): Write buffer to disk, optionally setting it's path" {
+		t.Fatalf("unexpected message %q", h.Message())
+	}
+	h.Keys(": t u t o r RET")
+	if h.Buffer().Name != "*tutor*" || h.Buffer().ReadOnly {
+		t.Fatal("expected an editable tutorial buffer")
+	}
+}
@@ -6,23 +6,7 @@ import (
 	"github.com/gdamore/tcell"
 )
 
-var (
-	style_maps                  = map[*Buffer][][]tcell.Style{}
-	highlighting_reserved_words = []string{
-		"func", "function", "fn", "lambda",
-		"var", "let", "const", "def",
-		"type", "struct", "interface", "class",
-		"if", "else", "for", "of", "in", "while", "break", "continue", "goto", "end",
-		"select", "switch", "case",
-		"import", "export", "package", "from",
-		"go", "async", "await",
-		"raise", "throw", "try", "catch", "except", "finally",
-		"string", "rune", "byte", "int", "float",
-	}
-	highlighting_special_words = []string{
-		"self", "this", "true", "false", "True", "False", "nil", "null", "None",
-	}
-)
+var style_maps = map[*Buffer][][]tcell.Style{}
 
 func highlighting_styles(b *Buffer) [][]tcell.Style {
 	return style_maps[b]
@@ -30,97 +14,138 @@ func highlighting_styles(b *Buffer) [][]tcell.Style {
 
 func init_highlighting() {
 	hook_buffer("modified", highlight_buffer)
+	// Options like filetype change highlighting
+	hook_buffer("option_set", highlight_buffer)
+}
+
+// Syntax state carried from a line to the next
+type highlightState struct {
+	in_string  rune
+	in_comment bool
 }
 
 func highlight_buffer(b *Buffer) {
-	s := style("default")
-	ss := style("special")
 	sse := style("search")
 	svi := style("visual")
-	sts := style("text.string")
-	stn := style("text.number")
-	stc := style("text.comment")
-	str := style("text.reserved")
-	stsp := style("text.special")
+	ssn := style("snippet")
 
+	lang := bufferLanguage(b)
+	if lang == nil {
+		lang = &language{}
+	}
+	state := &highlightState{}
 	style_map := make([][]tcell.Style, len(b.Data))
-	in_string := rune(0)
 	for l := range b.Data {
-		in_line_comment := false
-		word := ""
 		style_map[l] = make([]tcell.Style, len(b.Data[l])+1)
-		for c, char := range b.Data[l] {
-			prev_char := rune(0)
-			if c > 0 {
-				prev_char = b.Data[l][c-1]
-			}
-
-			// for numbers
-			passed_alpha := false
-			if isAlpha(prev_char) {
-				passed_alpha = true
-			}
-			// for special words
-			if isWord(char) {
-				word += string(char)
-			} else {
-				word = ""
-			}
+		highlight_line(lang, b.Data[l], state, style_map[l])
 
+		grep_marks := grepHighlights(b, l)
+		for c := range b.Data[l] {
 			if high_len := search_highlight(b, l, c); high_len > 0 {
-				for i := 0; i < high_len; i++ {
-					if style_map[l][c+i] == 0 {
-						style_map[l][c+i] = sse
-					}
+				for i := 0; i < high_len && c+i < len(b.Data[l]); i++ {
+					style_map[l][c+i] = sse
 				}
 			}
+			if c < len(grep_marks) && grep_marks[c] {
+				style_map[l][c] = sse
+			}
 			if visualHighlight(b, l, c) {
 				style_map[l][c] = svi
-				continue
 			}
-			if in_line_comment {
-				style_map[l][c] = stc
-				continue
+			if snippetHighlight(b, l, c) {
+				style_map[l][c] = ssn
 			}
-			if in_string > 0 && c-1 > 0 && b.Data[l][c-1] == '\\' && (c-2 < 0 || b.Data[l][c-2] != '\\') {
-				style_map[l][c] = sts
-				continue
+		}
+	}
+
+	style_maps[b] = style_map
+}
+
+// Styles line's comments, strings, keywords, constants and numbers as
+// lang defines them
+func highlight_line(lang *language, line []rune, state *highlightState, styles []tcell.Style) {
+	s := style("default")
+	ss := style("special")
+	sts := style("text.string")
+	stn := style("text.number")
+	stc := style("text.comment")
+	str := style("text.reserved")
+	stsp := style("text.special")
+
+	mark := func(c, n int, st tcell.Style) int {
+		for i := c; i < c+n && i < len(line); i++ {
+			styles[i] = st
+		}
+		return c + n
+	}
+	for c := 0; c < len(line); {
+		char := line[c]
+		if state.in_comment {
+			if end := lang.BlockComment[1]; hasPrefixAt(line, c, end) {
+				state.in_comment = false
+				c = mark(c, len([]rune(end)), stc)
+			} else {
+				c = mark(c, 1, stc)
 			}
-			if char == '/' && prev_char == '/' {
-				in_line_comment = true
-				style_map[l][c] = stc
-				style_map[l][c-1] = stc
+			continue
+		}
+		if state.in_string != 0 {
+			if char == '\\' && !strings.ContainsRune(lang.RawStrings, state.in_string) {
+				c = mark(c, 2, sts)
+				continue
 			}
-			if char == '\'' || char == '"' || char == '`' {
-				if in_string == char {
-					in_string = rune(0)
-				} else if in_string == rune(0) {
-					in_string = char
-				}
-				style_map[l][c] = sts
+			if char == state.in_string {
+				state.in_string = 0
 			}
-			if style_map[l][c] != 0 {
-				continue
+			c = mark(c, 1, sts)
+			continue
+		}
+		if beg := lang.BlockComment[0]; beg != "" && hasPrefixAt(line, c, beg) {
+			state.in_comment = true
+			c = mark(c, len([]rune(beg)), stc)
+			continue
+		}
+		if lang.LineComment != "" && hasPrefixAt(line, c, lang.LineComment) {
+			mark(c, len(line)-c, stc)
+			break
+		}
+		if strings.ContainsRune(lang.Strings, char) {
+			state.in_string = char
+			c = mark(c, 1, sts)
+			continue
+		}
+		if isWord(char) {
+			end := c
+			for end < len(line) && isWord(line[end]) {
+				end++
 			}
-			if in_string > 0 {
-				style_map[l][c] = sts
-			} else if listContainsString(highlighting_special_words, word) && c+1 < len(b.Data[l]) && !isWord(b.Data[l][c+1]) {
-				for i := len(word) - 1; i >= 0; i-- {
-					style_map[l][c-i] = stsp
-				}
-			} else if listContainsString(highlighting_reserved_words, word) && c+1 < len(b.Data[l]) && !isWord(b.Data[l][c+1]) {
-				for i := len(word) - 1; i >= 0; i-- {
-					style_map[l][c-i] = str
-				}
-			} else if !passed_alpha && isNum(char) {
-				style_map[l][c] = stn
-			} else if strings.ContainsRune(specialChars, b.Data[l][c]) {
-				style_map[l][c] = ss
-			} else {
-				style_map[l][c] = s
+			word := string(line[c:end])
+			st := s
+			if listContainsString(lang.Keywords, word) {
+				st = str
+			} else if listContainsString(lang.Constants, word) {
+				st = stsp
+			} else if isNum(char) {
+				st = stn
 			}
+			c = mark(c, end-c, st)
+			continue
+		}
+		if strings.ContainsRune(specialChars, char) {
+			c = mark(c, 1, ss)
+		} else {
+			c = mark(c, 1, s)
 		}
 	}
+	if state.in_string != 0 && !strings.ContainsRune(lang.MultilineStrings, state.in_string) {
+		state.in_string = 0
+	}
+}
 
-	style_maps[b] = style_map
+func hasPrefixAt(line []rune, c int, prefix string) bool {
+	p := []rune(prefix)
+	if len(p) == 0 || c+len(p) > len(line) {
+		return false
+	}
+	return string(line[c:c+len(p)]) == prefix
 }
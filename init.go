@@ -1,8 +1,6 @@
 package main
 
 import (
-	"os"
-
 	"github.com/gdamore/tcell"
 	"github.com/gdamore/tcell/encoding"
 )
@@ -34,9 +32,11 @@ func initTermEvents() {
 	}()
 }
 
-func initBuffers() {
-	for _, arg := range os.Args[1:] {
-		openBufferFromFile(arg)
+func initBuffers(files []string) {
+	buffers = []*Buffer{}
+	alternateBuffer = nil
+	for _, file := range files {
+		openBufferFromFile(file)
 	}
 	if len(buffers) == 0 {
 		openBufferNamed("*scratch*")
@@ -18,13 +18,14 @@ const (
 	KeyTypeAlpha
 	KeyTypeNum
 	KeyTypeAlphaNum
+	KeyTypeLeader
 )
 
 func NewKeyFromEvent(ev *tcell.EventKey) *Key {
 	k, r, m := ev.Key(), ev.Rune(), ev.Modifiers()
 
 	keyName := ev.Name()
-	if strings.HasPrefix(keyName, "Ctrl+") {
+	if strings.HasPrefix(keyName, "Ctrl+") && len(keyName) == 6 {
 		k = tcell.KeyRune
 		r = unicode.ToLower([]rune(keyName[5:6])[0])
 	}
@@ -52,6 +53,8 @@ func NewKey(rep string) *Key {
 		return &Key{Key: KeyTypeAlpha}
 	} else if rep == "$alphanum" {
 		return &Key{Key: KeyTypeAlphaNum}
+	} else if rep == "$leader" {
+		return &Key{Key: KeyTypeLeader}
 	}
 
 	parts := strings.Split(rep, "-")
@@ -92,6 +95,20 @@ func NewKey(rep string) *Key {
 		k = tcell.KeyEscape
 	case "TAB":
 		k = tcell.KeyTab
+	case "BTAB":
+		k = tcell.KeyBacktab
+	case "UP":
+		k = tcell.KeyUp
+	case "DOWN":
+		k = tcell.KeyDown
+	case "LEFT":
+		k = tcell.KeyLeft
+	case "RIGHT":
+		k = tcell.KeyRight
+	case "HOME":
+		k = tcell.KeyHome
+	case "END":
+		k = tcell.KeyEnd
 	default:
 		k = tcell.KeyRune
 		r = []rune(lastPart)[0]
@@ -109,6 +126,8 @@ func (k *Key) String() string {
 		return "$alpha"
 	} else if k.Key == KeyTypeAlphaNum {
 		return "$alphanum"
+	} else if k.Key == KeyTypeLeader {
+		return "$leader"
 	}
 
 	mods := []string{}
@@ -137,6 +156,20 @@ func (k *Key) String() string {
 		name = "ESC"
 	case tcell.KeyTab:
 		name = "TAB"
+	case tcell.KeyBacktab:
+		name = "BTAB"
+	case tcell.KeyUp:
+		name = "UP"
+	case tcell.KeyDown:
+		name = "DOWN"
+	case tcell.KeyLeft:
+		name = "LEFT"
+	case tcell.KeyRight:
+		name = "RIGHT"
+	case tcell.KeyHome:
+		name = "HOME"
+	case tcell.KeyEnd:
+		name = "END"
 	}
 	if k.Key == tcell.KeyRune && k.Chr == ' ' {
 		name = "SPC"
@@ -149,8 +182,24 @@ func (k *Key) IsRune() bool {
 	return k.Mod == 0 && k.Key == tcell.KeyRune
 }
 
+// The key $leader stands for, read from the "leader" option when matching
+// so changing it applies to existing bindings
+func leaderKey() *Key {
+	leader := configGet("leader", nil)
+	if leader == "" || leader == "$leader" {
+		leader = "SPC"
+	}
+	return NewKey(leader)
+}
+
 // TODO implement alphanum match
 func (k1 *Key) Matches(k2 *Key) bool {
+	if k1.Key == KeyTypeLeader {
+		k1 = leaderKey()
+	}
+	if k2.Key == KeyTypeLeader {
+		k2 = leaderKey()
+	}
 	if k1.Key == KeyTypeCatchall || k2.Key == KeyTypeCatchall {
 		return true
 	}
@@ -194,6 +243,22 @@ func (kl *KeyList) String() string {
 	return strings.Join(rep, " ")
 }
 
+// Scores how specific the keys are, literal keys counting more than key
+// classes and $any the least
+func (kl *KeyList) Specificity() int {
+	score := 0
+	for _, k := range kl.keys {
+		switch k.Key {
+		case KeyTypeCatchall:
+		case KeyTypeAlpha, KeyTypeNum, KeyTypeAlphaNum:
+			score++
+		default:
+			score += 2
+		}
+	}
+	return score
+}
+
 func (kl *KeyList) AddKey(k *Key) {
 	kl.keys = append(kl.keys, k)
 }
@@ -0,0 +1,489 @@
+package main
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Languages describe filetypes: how files of theirs are recognized, how
+// they're highlighted, commented and indented. A buffer's filetype is
+// detected when it's opened, from a modeline, its file name or a #! line,
+// and buffers of a filetype with a language get a mode named after it.
+// Its bindings handle keys in normal mode before the editor's, scripts
+// adding their own with ry.bind("go", ...).
+
+type language struct {
+	Name         string
+	Extensions   []string
+	Filenames    []string // whole file names, like Makefile
+	Interpreters []string // programs of #! lines running it
+
+	LineComment      string
+	BlockComment     [2]string
+	Strings          string // runes quoting strings
+	MultilineStrings string // those of Strings whose strings span lines
+	RawStrings       string // those of Strings in which \ escapes nothing
+	Keywords         []string
+	Constants        []string
+
+	IndentAfter string // runes ending a line that indent the next one
+	DedentOn    string // runes dedenting a blank line when typed
+	// Option values for its buffers, [filetype.<name>] tables overriding
+	// them
+	Options map[string]interface{}
+}
+
+var (
+	languages     = map[string]*language{}
+	languageOrder = []*language{} // as registered, for detection
+)
+
+var builtinLanguages = []*language{
+	{
+		Name:             "go",
+		Extensions:       []string{"go"},
+		LineComment:      "//",
+		BlockComment:     [2]string{"/*", "*/"},
+		Strings:          "\"'`",
+		MultilineStrings: "`",
+		RawStrings:       "`",
+		Keywords: []string{
+			"break", "case", "chan", "const", "continue", "default", "defer", "else",
+			"fallthrough", "for", "func", "go", "goto", "if", "import", "interface",
+			"map", "package", "range", "return", "select", "struct", "switch", "type", "var",
+			"bool", "byte", "error", "float32", "float64", "int", "int64", "rune", "string", "uint",
+		},
+		Constants:   []string{"true", "false", "nil", "iota"},
+		IndentAfter: "{([",
+		DedentOn:    "})]",
+		Options:     map[string]interface{}{"tab_to_spaces": false},
+	},
+	{
+		Name:         "c",
+		Extensions:   []string{"c", "h"},
+		LineComment:  "//",
+		BlockComment: [2]string{"/*", "*/"},
+		Strings:      "\"'",
+		Keywords: []string{
+			"auto", "break", "case", "char", "const", "continue", "default", "do", "double",
+			"else", "enum", "extern", "float", "for", "goto", "if", "int", "long", "register",
+			"return", "short", "signed", "sizeof", "static", "struct", "switch", "typedef",
+			"union", "unsigned", "void", "volatile", "while",
+		},
+		Constants:   []string{"NULL", "true", "false"},
+		IndentAfter: "{([",
+		DedentOn:    "})]",
+	},
+	{
+		Name:         "cpp",
+		Extensions:   []string{"cpp", "cc", "cxx", "hpp", "hh"},
+		LineComment:  "//",
+		BlockComment: [2]string{"/*", "*/"},
+		Strings:      "\"'",
+		Keywords: []string{
+			"auto", "break", "case", "catch", "char", "class", "const", "continue", "default",
+			"delete", "do", "double", "else", "enum", "float", "for", "if", "int", "long",
+			"namespace", "new", "private", "protected", "public", "return", "static", "struct",
+			"switch", "template", "throw", "try", "typedef", "using", "virtual", "void", "while",
+		},
+		Constants:   []string{"nullptr", "NULL", "true", "false", "this"},
+		IndentAfter: "{([",
+		DedentOn:    "})]",
+	},
+	{
+		Name:             "javascript",
+		Extensions:       []string{"js", "mjs", "cjs", "jsx"},
+		Interpreters:     []string{"node", "deno"},
+		LineComment:      "//",
+		BlockComment:     [2]string{"/*", "*/"},
+		Strings:          "\"'`",
+		MultilineStrings: "`",
+		Keywords: []string{
+			"async", "await", "break", "case", "catch", "class", "const", "continue", "default",
+			"delete", "do", "else", "export", "extends", "finally", "for", "from", "function",
+			"if", "import", "in", "instanceof", "let", "new", "of", "return", "static", "switch",
+			"throw", "try", "typeof", "var", "while", "yield",
+		},
+		Constants:   []string{"true", "false", "null", "undefined", "this"},
+		IndentAfter: "{([",
+		DedentOn:    "})]",
+		Options:     map[string]interface{}{"tab_width": float64(2)},
+	},
+	{
+		Name:             "typescript",
+		Extensions:       []string{"ts", "tsx"},
+		LineComment:      "//",
+		BlockComment:     [2]string{"/*", "*/"},
+		Strings:          "\"'`",
+		MultilineStrings: "`",
+		Keywords: []string{
+			"async", "await", "break", "case", "catch", "class", "const", "continue", "default",
+			"do", "else", "enum", "export", "extends", "finally", "for", "from", "function", "if",
+			"implements", "import", "in", "interface", "let", "new", "of", "private", "public",
+			"readonly", "return", "switch", "throw", "try", "type", "typeof", "var", "while",
+			"any", "boolean", "number", "string", "void",
+		},
+		Constants:   []string{"true", "false", "null", "undefined", "this"},
+		IndentAfter: "{([",
+		DedentOn:    "})]",
+		Options:     map[string]interface{}{"tab_width": float64(2)},
+	},
+	{
+		Name:         "python",
+		Extensions:   []string{"py", "pyw"},
+		Interpreters: []string{"python"},
+		LineComment:  "#",
+		Strings:      "\"'",
+		Keywords: []string{
+			"and", "as", "assert", "async", "await", "break", "class", "continue", "def", "del",
+			"elif", "else", "except", "finally", "for", "from", "global", "if", "import", "in",
+			"is", "lambda", "nonlocal", "not", "or", "pass", "raise", "return", "try", "while",
+			"with", "yield",
+		},
+		Constants:   []string{"True", "False", "None", "self"},
+		IndentAfter: ":([{",
+		DedentOn:    ")]}",
+	},
+	{
+		Name:         "ruby",
+		Extensions:   []string{"rb", "rake"},
+		Filenames:    []string{"Rakefile", "Gemfile"},
+		Interpreters: []string{"ruby"},
+		LineComment:  "#",
+		Strings:      "\"'",
+		Keywords: []string{
+			"begin", "break", "case", "class", "def", "do", "else", "elsif", "end", "ensure",
+			"for", "if", "in", "module", "next", "raise", "require", "rescue", "return", "then",
+			"unless", "until", "when", "while", "yield",
+		},
+		Constants:   []string{"true", "false", "nil", "self"},
+		IndentAfter: "([{|",
+		DedentOn:    ")]}",
+		Options:     map[string]interface{}{"tab_width": float64(2)},
+	},
+	{
+		Name:         "rust",
+		Extensions:   []string{"rs"},
+		LineComment:  "//",
+		BlockComment: [2]string{"/*", "*/"},
+		Strings:      "\"",
+		Keywords: []string{
+			"as", "async", "await", "break", "const", "continue", "crate", "dyn", "else", "enum",
+			"extern", "fn", "for", "if", "impl", "in", "let", "loop", "match", "mod", "move",
+			"mut", "pub", "ref", "return", "static", "struct", "trait", "type", "unsafe", "use",
+			"where", "while",
+		},
+		Constants:   []string{"true", "false", "self", "Self", "None", "Some", "Ok", "Err"},
+		IndentAfter: "{([",
+		DedentOn:    "})]",
+	},
+	{
+		Name:         "shell",
+		Extensions:   []string{"sh", "bash", "zsh"},
+		Filenames:    []string{".bashrc", ".bash_profile", ".profile", ".zshrc"},
+		Interpreters: []string{"sh", "bash", "zsh", "dash", "ksh"},
+		LineComment:  "#",
+		Strings:      "\"'",
+		RawStrings:   "'",
+		Keywords: []string{
+			"case", "do", "done", "elif", "else", "esac", "export", "fi", "for", "function",
+			"if", "in", "local", "return", "then", "until", "while",
+		},
+		Constants:   []string{"true", "false"},
+		IndentAfter: "{(",
+		DedentOn:    "})",
+	},
+	{
+		Name:         "lua",
+		Extensions:   []string{"lua"},
+		Interpreters: []string{"lua", "luajit"},
+		LineComment:  "--",
+		BlockComment: [2]string{"--[[", "]]"},
+		Strings:      "\"'",
+		Keywords: []string{
+			"and", "break", "do", "else", "elseif", "end", "for", "function", "goto", "if",
+			"in", "local", "not", "or", "repeat", "return", "then", "until", "while",
+		},
+		Constants:   []string{"true", "false", "nil", "self"},
+		IndentAfter: "{(",
+		DedentOn:    "})",
+		Options:     map[string]interface{}{"tab_width": float64(2)},
+	},
+	{
+		Name:        "make",
+		Extensions:  []string{"mk"},
+		Filenames:   []string{"Makefile", "makefile", "GNUmakefile"},
+		LineComment: "#",
+		Keywords:    []string{"ifeq", "ifneq", "ifdef", "ifndef", "else", "endif", "include", "define", "endef"},
+		IndentAfter: ":",
+		Options:     map[string]interface{}{"tab_to_spaces": false},
+	},
+	{
+		Name:        "yaml",
+		Extensions:  []string{"yaml", "yml"},
+		LineComment: "#",
+		Strings:     "\"'",
+		RawStrings:  "'",
+		Constants:   []string{"true", "false", "null"},
+		IndentAfter: ":",
+		Options:     map[string]interface{}{"tab_width": float64(2)},
+	},
+	{
+		Name:        "toml",
+		Extensions:  []string{"toml"},
+		LineComment: "#",
+		Strings:     "\"'",
+		RawStrings:  "'",
+		Constants:   []string{"true", "false"},
+		IndentAfter: "[{",
+		DedentOn:    "]}",
+	},
+	{
+		Name:        "json",
+		Extensions:  []string{"json"},
+		Strings:     "\"",
+		Constants:   []string{"true", "false", "null"},
+		IndentAfter: "{[",
+		DedentOn:    "}]",
+		Options:     map[string]interface{}{"tab_width": float64(2)},
+	},
+	{
+		Name:       "markdown",
+		Extensions: []string{"md", "markdown"},
+		Strings:    "`",
+		RawStrings: "`",
+	},
+}
+
+// Other names filetypes go by in modelines
+var filetypeAliases = map[string]string{
+	"sh":           "shell",
+	"bash":         "shell",
+	"zsh":          "shell",
+	"shell-script": "shell",
+	"js":           "javascript",
+	"ts":           "typescript",
+	"py":           "python",
+	"rb":           "ruby",
+	"rs":           "rust",
+	"c++":          "cpp",
+	"yml":          "yaml",
+	"md":           "markdown",
+	"makefile":     "make",
+}
+
+func initLanguages() {
+	languages = map[string]*language{}
+	languageOrder = []*language{}
+	for _, lang := range builtinLanguages {
+		addLanguage(lang)
+	}
+}
+
+// Registers a language and its mode
+func addLanguage(lang *language) {
+	languages[lang.Name] = lang
+	languageOrder = append(languageOrder, lang)
+	addMode(lang.Name)
+	mustFindMode(lang.Name).normalOnly = true
+	bindDesc(lang.Name, k("g c c"), "Toggle comment", func(vt *ViewTree, b *Buffer, kl *KeyList) {
+		toggleComment(b, b.Cursor.Line)
+	})
+}
+
+// Language of b's filetype, nil for those without one
+func bufferLanguage(b *Buffer) *language {
+	return languages[configGet("filetype", b)]
+}
+
+// Gives b the mode of its filetype's language, dropping any other's
+func updateLanguageMode(b *Buffer) {
+	ft := configGet("filetype", b)
+	for _, name := range append([]string{}, b.Modes...) {
+		if languages[name] != nil && name != ft {
+			b.RemoveMode(name)
+		}
+	}
+	if languages[ft] != nil {
+		b.AddMode(ft)
+	}
+}
+
+// Filetype of b going by, in order, a modeline, its file name and a #!
+// line. Unknown extensions are their own filetype.
+func detectFiletype(b *Buffer) string {
+	if ft := modelineFiletype(b); ft != "" {
+		return ft
+	}
+	ft := filetypeFromPath(b.Path)
+	if languages[ft] != nil {
+		return ft
+	}
+	if sft := shebangFiletype(b); sft != "" {
+		return sft
+	}
+	return ft
+}
+
+// Guesses a filetype from a file name or its extension
+func filetypeFromPath(path string) string {
+	name := filepath.Base(path)
+	ext := strings.TrimPrefix(filepath.Ext(path), ".")
+	for _, lang := range languageOrder {
+		if listContainsString(lang.Filenames, name) || ext != "" && listContainsString(lang.Extensions, ext) {
+			return lang.Name
+		}
+	}
+	return ext
+}
+
+var (
+	vimModeline   = regexp.MustCompile(`(?:^|\s)(?:vi|vim|ex):.*?\b(?:ft|filetype)=([\w+-]+)`)
+	emacsModeline = regexp.MustCompile(`-\*-\s*(?:.*?\bmode:\s*([\w+-]+)|([\w+-]+))\s*(?:;.*?)?-\*-`)
+)
+
+// Filetype set by a vim (vim: set ft=go:) or emacs (-*- mode: go -*-)
+// modeline in the first or last 5 lines
+func modelineFiletype(b *Buffer) string {
+	for l, line := range b.Data {
+		if l >= 5 && l < len(b.Data)-5 {
+			continue
+		}
+		s := string(line)
+		if m := vimModeline.FindStringSubmatch(s); m != nil {
+			return normalizeFiletype(m[1])
+		}
+		if m := emacsModeline.FindStringSubmatch(s); m != nil {
+			return normalizeFiletype(m[1] + m[2])
+		}
+	}
+	return ""
+}
+
+// Filetype of the interpreter a #! line runs, going past env
+func shebangFiletype(b *Buffer) string {
+	if len(b.Data) == 0 || !strings.HasPrefix(string(b.Data[0]), "#!") {
+		return ""
+	}
+	fields := strings.Fields(string(b.Data[0])[2:])
+	for i, field := range fields {
+		program := filepath.Base(field)
+		if i == 0 && program == "env" || strings.HasPrefix(field, "-") || strings.Contains(field, "=") {
+			continue
+		}
+		// python3.11 runs python
+		program = strings.TrimRight(program, "0123456789.")
+		for _, lang := range languageOrder {
+			if listContainsString(lang.Interpreters, program) {
+				return lang.Name
+			}
+		}
+		return ""
+	}
+	return ""
+}
+
+func normalizeFiletype(ft string) string {
+	ft = strings.ToLower(ft)
+	if alias, ok := filetypeAliases[ft]; ok {
+		return alias
+	}
+	return ft
+}
+
+// What a tab inserts in b
+func indentUnit(b *Buffer) string {
+	if configGetBool("tab_to_spaces", b) {
+		return strings.Repeat(" ", int(configGetNumber("tab_width", b)))
+	}
+	return "\t"
+}
+
+// Blanks starting line
+func lineIndent(line []rune) []rune {
+	i := 0
+	for i < len(line) && (line[i] == ' ' || line[i] == '\t') {
+		i++
+	}
+	return append([]rune{}, line[:i]...)
+}
+
+// Indentation of a line following one starting with before: the same,
+// one level more after what opens a block in b's language
+func nextLineIndent(b *Buffer, before []rune) string {
+	indent := string(lineIndent(before))
+	trimmed := []rune(strings.TrimRight(string(before), " \t"))
+	if lang := bufferLanguage(b); lang != nil && len(trimmed) > 0 && strings.ContainsRune(lang.IndentAfter, trimmed[len(trimmed)-1]) {
+		indent += indentUnit(b)
+	}
+	return indent
+}
+
+// Takes a level of indentation off the cursor line when r, closing a
+// block, is typed with only blanks before it
+func dedentClosing(b *Buffer, r rune) {
+	lang := bufferLanguage(b)
+	if lang == nil || !strings.ContainsRune(lang.DedentOn, r) {
+		return
+	}
+	before := b.Data[b.Cursor.Line][:b.Cursor.Char]
+	if len(before) == 0 || strings.TrimLeft(string(before), " \t") != "" {
+		return
+	}
+	n := 1
+	if before[len(before)-1] == ' ' {
+		for n < int(configGetNumber("tab_width", b)) && n < len(before) && before[len(before)-1-n] == ' ' {
+			n++
+		}
+	}
+	b.RemoveAt(NewLocation(b.Cursor.Line, b.Cursor.Char-n), n)
+	b.MoveTo(b.Cursor.Char-n, b.Cursor.Line)
+}
+
+// Comments line l out with its language's line comment (or a block
+// comment when it has none), or back in when it is
+func toggleComment(b *Buffer, l int) {
+	lang := bufferLanguage(b)
+	if lang == nil {
+		return
+	}
+	beg, end := lang.LineComment, ""
+	if beg == "" {
+		beg, end = lang.BlockComment[0], lang.BlockComment[1]
+	}
+	if beg == "" {
+		messageError("No comments in " + lang.Name)
+		return
+	}
+	line := string(b.Data[l])
+	text := strings.TrimLeft(line, " \t")
+	if text == "" {
+		return
+	}
+	indent := len([]rune(line)) - len([]rune(text))
+	loc := NewLocation(l, indent)
+
+	b.BeginUndoGroup()
+	defer b.EndUndoGroup()
+	if strings.HasPrefix(text, beg) && strings.HasSuffix(text, end) {
+		n := len([]rune(beg))
+		if strings.HasPrefix(text[len(beg):], " ") {
+			n++
+		}
+		if end != "" {
+			m := len([]rune(end))
+			if strings.HasSuffix(strings.TrimSuffix(text, end), " ") {
+				m++
+			}
+			b.RemoveAt(NewLocation(l, len(b.Data[l])-m), m)
+		}
+		b.RemoveAt(loc, n)
+	} else {
+		if end != "" {
+			b.InsertAt(NewLocation(l, len(b.Data[l])), []rune(" "+end))
+		}
+		b.InsertAt(loc, []rune(beg+" "))
+	}
+	b.MoveTo(b.Cursor.Char, b.Cursor.Line)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gdamore/tcell"
+)
+
+func TestDetectFiletype(t *testing.T) {
+	initLanguages()
+	for _, c := range []struct {
+		path, contents, expected string
+	}{
+		{"main.go", "package main", "go"},
+		{"script", "#!/usr/bin/env python3\nprint(1)", "python"},
+		{"run", "#!/bin/bash -e\necho", "shell"},
+		{"notes.txt", "# Notes\n\nvim: set ft=markdown:", "markdown"},
+		{"lib.h", "// -*- mode: c++ -*-", "cpp"},
+		{"Makefile", "all:", "make"},
+		{"ci.yml", "on: push", "yaml"},
+		{"main.go", "#!/usr/bin/env python3", "go"},
+		{"data.xyz", "", "xyz"},
+	} {
+		b := NewBuffer(c.path, c.path)
+		b.Data = [][]rune{}
+		for _, line := range strings.Split(c.contents, "\n") {
+			b.Data = append(b.Data, []rune(line))
+		}
+		if ft := detectFiletype(b); ft != c.expected {
+			t.Errorf("%s: expected %q, got %q", c.path, c.expected, ft)
+		}
+	}
+}
+
+func TestLanguageModes(t *testing.T) {
+	dir := writeProject(t, map[string]string{
+		"main.go": "package main\n\nfunc main() {\n\ts := \"for\" // nil\n}\n",
+	})
+	h := newHeadless(80, 24, []string{filepath.Join(dir, "main.go")})
+	if h.Mode() != "normal+go" {
+		t.Fatalf("expected the go mode, got %s", h.Mode())
+	}
+	styles := style_maps[h.Buffer()]
+	if styles[0][0] != style("text.reserved") || styles[3][8] != style("text.string") || styles[3][15] != style("text.comment") {
+		t.Fatal("expected go highlighting")
+	}
+
+	// Comments toggle, blocks indent and closers dedent
+	h.Keys("j j j g c c")
+	expectContents(t, h, "package main\n\nfunc main() {\n\t// s := \"for\" // nil\n}\n")
+	h.Keys("g c c")
+	expectContents(t, h, "package main\n\nfunc main() {\n\ts := \"for\" // nil\n}\n")
+	h.Keys("o i f SPC x SPC { RET y RET }")
+	expectContents(t, h, "package main\n\nfunc main() {\n\ts := \"for\" // nil\n\tif x {\n\t\ty\n\t}\n}\n")
+
+	// Setting the filetype switches mode
+	h.Keys("ESC : s e t SPC f i l e t y p e = p y t h o n RET")
+	if h.Mode() != "normal+python" {
+		t.Fatalf("expected the python mode, got %s", h.Mode())
+	}
+	h.Keys("g c c")
+	expectContents(t, h, "package main\n\nfunc main() {\n\ts := \"for\" // nil\n\tif x {\n\t\ty\n\t# }\n}\n")
+}
+
+func TestFiletypeFollowsPath(t *testing.T) {
+	dir := writeProject(t, map[string]string{"main.go": "package main\n"})
+	h := newHeadless(80, 24, []string{filepath.Join(dir, "main.go")})
+	runCommandLine("w " + filepath.Join(dir, "main.py"))
+	if h.Mode() != "normal+python" {
+		t.Fatalf("expected the python mode once saved as .py, got %s", h.Mode())
+	}
+	// Unless set by hand
+	h.Keys(": s e t l o c a l SPC f i l e t y p e = g o RET")
+	runCommandLine("w " + filepath.Join(dir, "main.rb"))
+	if h.Mode() != "normal+go" {
+		t.Fatalf("expected the go mode kept, got %s", h.Mode())
+	}
+}
+
+func TestHighlightRawStrings(t *testing.T) {
+	initLanguages()
+	state := &highlightState{}
+	var styles []tcell.Style
+	for _, line := range []string{"x := `C:\\`", "y := \"a\\\"b\"", "z"} {
+		styles = make([]tcell.Style, len([]rune(line))+1)
+		highlight_line(languages["go"], []rune(line), state, styles)
+	}
+	if styles[0] == style("text.string") {
+		t.Fatal("expected strings closed, \\ escaping nothing in raw ones")
+	}
+}
@@ -0,0 +1,990 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Language servers run as child processes speaking the Language Server
+// Protocol over stdio, one per filetype and project root. Buffers of a
+// filetype with a server are opened in it and kept in sync change by
+// change; the server then answers :definition, :hover, :references,
+// :rename, :format and completion, and publishes diagnostics.
+//
+// Servers are configured by filetype in config.toml:
+//
+//   [lsp]
+//   go = ["gopls"]
+//   python = ["pylsp"]
+
+type lspMessage struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id,omitempty"`
+	Method  string           `json:"method,omitempty"`
+	Params  interface{}      `json:"params,omitempty"`
+	Result  json.RawMessage  `json:"result,omitempty"`
+	Error   *lspError        `json:"error,omitempty"`
+}
+
+type lspError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type lspPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"` // in UTF-16 code units
+}
+
+type lspRange struct {
+	Start lspPosition `json:"start"`
+	End   lspPosition `json:"end"`
+}
+
+type lspLocation struct {
+	URI   string   `json:"uri"`
+	Range lspRange `json:"range"`
+	// LocationLink fields, some servers answering with those
+	TargetURI            string    `json:"targetUri"`
+	TargetSelectionRange *lspRange `json:"targetSelectionRange"`
+}
+
+type lspTextEdit struct {
+	Range   lspRange `json:"range"`
+	NewText string   `json:"newText"`
+}
+
+type lspClient struct {
+	Filetype string
+	Root     string
+	cmd      *exec.Cmd
+	out      chan *lspMessage
+	nextID   int
+	pending  map[int]func(json.RawMessage, *lspError)
+	ready    bool
+	queued   []*lspMessage
+	dead     bool
+	sync     int // 1 to send whole documents on change, 2 for changes only
+	versions map[*Buffer]int
+}
+
+const (
+	lspSyncNone        = 0
+	lspSyncFull        = 1
+	lspSyncIncremental = 2
+)
+
+var (
+	lspServers = map[string][]string{}
+	// Clients by filetype and root, and by the buffers opened in them
+	lspClients = map[string]*lspClient{}
+	lspBuffers = map[*Buffer]*lspClient{}
+	// Documentation shown by :hover, until the next key
+	hoverPopup *Popup
+)
+
+var defaultLSPServers = map[string][]string{
+	"go":     []string{"gopls"},
+	"python": []string{"pylsp"},
+	"rust":   []string{"rust-analyzer"},
+	"c":      []string{"clangd"},
+	"cpp":    []string{"clangd"},
+}
+
+func initLSP() {
+	stopLanguageServers()
+	lspClients = map[string]*lspClient{}
+	lspBuffers = map[*Buffer]*lspClient{}
+	hoverPopup = nil
+	lspServers = map[string][]string{}
+	for ft, command := range defaultLSPServers {
+		lspServers[ft] = command
+	}
+
+	addOption("lsp", true, "Start language servers for the filetypes that have one")
+
+	hook_buffer("opened", lspAttach)
+	hook_buffer("saved", func(b *Buffer) {
+		if c := lspBuffers[b]; c != nil {
+			c.notify("textDocument/didSave", map[string]interface{}{
+				"textDocument": lspDocument(b),
+			})
+		}
+	})
+	hook_buffer("closed", func(b *Buffer) {
+		if c := lspBuffers[b]; c != nil {
+			c.notify("textDocument/didClose", map[string]interface{}{
+				"textDocument": lspDocument(b),
+			})
+			delete(c.versions, b)
+			delete(lspBuffers, b)
+		}
+	})
+	onBufferChange(lspChange)
+
+	bindDesc("normal", k("g d"), "Go to definition", func(vt *ViewTree, b *Buffer, kl *KeyList) {
+		lspDefinition(b)
+	})
+	bindDesc("normal", k("g r"), "List references", func(vt *ViewTree, b *Buffer, kl *KeyList) {
+		lspReferences(b)
+	})
+	bindDesc("normal", k("K"), "Show documentation", func(vt *ViewTree, b *Buffer, kl *KeyList) {
+		lspHover(b)
+	})
+	bindDesc("insert", k("C-x C-o"), "Complete with the language server", func(vt *ViewTree, b *Buffer, kl *KeyList) {
+		completeInsert(b, []*completionSource{findCompletionSource("lsp")}, 1)
+	})
+	addCompletionSource("lsp", 20, lspComplete)
+
+	addCommandDesc("definition", "Goes to the definition of the symbol under the cursor", func(args []string) {
+		lspDefinition(currentViewTree.Leaf.Buf)
+	})
+	addCommandDesc("hover", "Shows documentation for the symbol under the cursor", func(args []string) {
+		lspHover(currentViewTree.Leaf.Buf)
+	})
+	addCommandDesc("references", "Lists references to the symbol under the cursor in the quickfix list", func(args []string) {
+		lspReferences(currentViewTree.Leaf.Buf)
+	})
+	addCommandDesc("rename", "Renames the symbol under the cursor everywhere it's used", func(args []string) {
+		if len(args) != 2 {
+			messageError("Usage: rename <new name>")
+			return
+		}
+		lspRename(currentViewTree.Leaf.Buf, args[1])
+	})
+	addCommandDesc("format", "Formats the buffer with its language server", func(args []string) {
+		lspFormat(currentViewTree.Leaf.Buf)
+	})
+
+	// lsp = {filetype = ["command", "args"...]} in config.toml
+	configSections["lsp"] = func(value interface{}) error {
+		servers, ok := value.(map[string]interface{})
+		if !ok {
+			return errors.New("lsp must be a table of commands by filetype")
+		}
+		for ft, command := range servers {
+			parts, ok := command.([]interface{})
+			if !ok {
+				return errors.New("lsp." + ft + " must be a list of strings")
+			}
+			args := []string{}
+			for _, part := range parts {
+				arg, ok := part.(string)
+				if !ok {
+					return errors.New("lsp." + ft + " must be a list of strings")
+				}
+				args = append(args, arg)
+			}
+			if len(args) == 0 {
+				delete(lspServers, ft)
+			} else {
+				lspServers[ft] = args
+			}
+		}
+		return nil
+	}
+}
+
+// The filetype and root of the server b opens in, and the command
+// starting it, ft being "" when b has none
+func lspServerFor(b *Buffer) (ft, root string, command []string) {
+	ft = configGet("filetype", b)
+	command, ok := lspServers[ft]
+	if b.Path == "" || !ok || !configGetBool("lsp", b) {
+		return "", "", nil
+	}
+	// Servers that aren't installed are left alone
+	if _, err := exec.LookPath(command[0]); err != nil {
+		return "", "", nil
+	}
+	return ft, projectRoot(filepath.Dir(b.Path)), command
+}
+
+// Opens b in the language server for its filetype and project, starting
+// the server if needed
+func lspAttach(b *Buffer) {
+	ft, root, command := lspServerFor(b)
+	if ft == "" || lspBuffers[b] != nil {
+		return
+	}
+	c, ok := lspClients[ft+":"+root]
+	if !ok {
+		c = startLanguageServer(ft, root, command)
+		lspClients[ft+":"+root] = c
+		// Buffers left without a server when the last one exited
+		for _, other := range buffers {
+			if other != b && lspBuffers[other] == nil {
+				if oft, oroot, _ := lspServerFor(other); oft == ft && oroot == root {
+					lspOpen(c, other)
+				}
+			}
+		}
+	}
+	lspOpen(c, b)
+}
+
+func lspOpen(c *lspClient, b *Buffer) {
+	if c.dead {
+		return
+	}
+	lspBuffers[b] = c
+	c.versions[b] = 1
+	c.notify("textDocument/didOpen", map[string]interface{}{
+		"textDocument": map[string]interface{}{
+			"uri":        lspURI(b.Path),
+			"languageId": c.Filetype,
+			"version":    1,
+			"text":       b.Contents(),
+		},
+	})
+}
+
+func startLanguageServer(ft, root string, command []string) *lspClient {
+	c := &lspClient{
+		Filetype: ft,
+		Root:     root,
+		out:      make(chan *lspMessage, 100),
+		pending:  map[int]func(json.RawMessage, *lspError){},
+		sync:     lspSyncIncremental,
+		versions: map[*Buffer]int{},
+	}
+	c.cmd = exec.Command(command[0], command[1:]...)
+	c.cmd.Dir = root
+	stdin, err := c.cmd.StdinPipe()
+	if err == nil {
+		var stdout io.ReadCloser
+		stdout, err = c.cmd.StdoutPipe()
+		if err == nil {
+			err = c.cmd.Start()
+		}
+		if err == nil {
+			go c.write(stdin)
+			go c.read(stdout)
+		}
+	}
+	if err != nil {
+		messageError("Error starting language server " + command[0] + ": " + err.Error())
+		c.dead = true
+		return c
+	}
+
+	c.ready = true // lets initialize through
+	c.call("initialize", map[string]interface{}{
+		"processId": os.Getpid(),
+		"rootUri":   lspURI(root),
+		"rootPath":  root,
+		"workspaceFolders": []map[string]string{
+			{"uri": lspURI(root), "name": filepath.Base(root)},
+		},
+		"capabilities": map[string]interface{}{
+			"textDocument": map[string]interface{}{
+				"synchronization":    map[string]interface{}{"didSave": true},
+				"hover":              map[string]interface{}{"contentFormat": []string{"plaintext", "markdown"}},
+				"completion":         map[string]interface{}{"completionItem": map[string]interface{}{"snippetSupport": false}},
+				"definition":         map[string]interface{}{},
+				"references":         map[string]interface{}{},
+				"rename":             map[string]interface{}{},
+				"formatting":         map[string]interface{}{},
+				"publishDiagnostics": map[string]interface{}{},
+			},
+			"workspace": map[string]interface{}{
+				"applyEdit":     true,
+				"workspaceEdit": map[string]interface{}{"documentChanges": true},
+			},
+		},
+	}, func(result json.RawMessage, err *lspError) {
+		// Nothing queued would ever be sent
+		if err != nil {
+			c.stop()
+			messageError("Language server for " + c.Filetype + " failed to start: " + err.Message)
+			return
+		}
+		var init struct {
+			Capabilities struct {
+				TextDocumentSync json.RawMessage `json:"textDocumentSync"`
+			} `json:"capabilities"`
+		}
+		json.Unmarshal(result, &init)
+		var sync struct {
+			Change int `json:"change"`
+		}
+		if json.Unmarshal(init.Capabilities.TextDocumentSync, &sync.Change) != nil {
+			json.Unmarshal(init.Capabilities.TextDocumentSync, &sync)
+		}
+		c.sync = sync.Change
+		c.ready = true
+		c.notify("initialized", map[string]interface{}{})
+		for _, msg := range c.queued {
+			c.out <- msg
+		}
+		c.queued = nil
+	})
+	c.ready = false
+	return c
+}
+
+// Stops all language servers, not waiting for them to exit
+func stopLanguageServers() {
+	for _, c := range lspClients {
+		if !c.dead {
+			c.dead = true
+			close(c.out)
+		}
+	}
+}
+
+// Writes messages to the server until out is closed, then closes its
+// input, which servers take as a cue to exit
+func (c *lspClient) write(stdin io.WriteCloser) {
+	for msg := range c.out {
+		data, _ := json.Marshal(msg)
+		_, err := stdin.Write([]byte("Content-Length: " + strconv.Itoa(len(data)) + "\r\n\r\n" + string(data)))
+		if err != nil {
+			break
+		}
+	}
+	stdin.Close()
+	// Drain anything sent once the server was gone
+	for range c.out {
+	}
+}
+
+// Reads messages from the server, handling them on the main loop
+func (c *lspClient) read(stdout io.Reader) {
+	r := bufio.NewReader(stdout)
+	for {
+		length := -1
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				c.cmd.Wait()
+				runInMainLoop(c.exited)
+				return
+			}
+			line = strings.TrimSpace(line)
+			if line == "" {
+				break
+			}
+			if strings.HasPrefix(strings.ToLower(line), "content-length:") {
+				length, _ = strconv.Atoi(strings.TrimSpace(line[len("content-length:"):]))
+			}
+		}
+		if length < 0 {
+			continue
+		}
+		data := make([]byte, length)
+		if _, err := io.ReadFull(r, data); err != nil {
+			c.cmd.Wait()
+			runInMainLoop(c.exited)
+			return
+		}
+		msg := &lspIncoming{}
+		if json.Unmarshal(data, msg) == nil {
+			runInMainLoop(func() { c.handle(msg) })
+		}
+	}
+}
+
+// A message from the server, whose params are decoded by method
+type lspIncoming struct {
+	ID     *json.RawMessage `json:"id"`
+	Method string           `json:"method"`
+	Params json.RawMessage  `json:"params"`
+	Result json.RawMessage  `json:"result"`
+	Error  *lspError        `json:"error"`
+}
+
+func (c *lspClient) exited() {
+	if c.dead {
+		return
+	}
+	c.stop()
+	messageError("Language server for " + c.Filetype + " exited")
+}
+
+// Marks c dead, closing the server's input and forgetting its buffers
+func (c *lspClient) stop() {
+	c.dead = true
+	c.queued = nil
+	close(c.out)
+	for b, bc := range lspBuffers {
+		if bc == c {
+			delete(lspBuffers, b)
+		}
+	}
+	// The next file opened starts it again
+	if key := c.Filetype + ":" + c.Root; lspClients[key] == c {
+		delete(lspClients, key)
+	}
+}
+
+func (c *lspClient) send(msg *lspMessage) {
+	msg.JSONRPC = "2.0"
+	if c.dead {
+		return
+	}
+	if !c.ready {
+		c.queued = append(c.queued, msg)
+		return
+	}
+	c.out <- msg
+}
+
+func (c *lspClient) notify(method string, params interface{}) {
+	c.send(&lspMessage{Method: method, Params: params})
+}
+
+// Sends a request, calling f with its result on success
+func (c *lspClient) request(method string, params interface{}, f func(json.RawMessage)) {
+	c.call(method, params, func(result json.RawMessage, err *lspError) {
+		if err != nil {
+			messageError(method + ": " + err.Message)
+			return
+		}
+		f(result)
+	})
+}
+
+// Sends a request, calling f with its result or error
+func (c *lspClient) call(method string, params interface{}, f func(json.RawMessage, *lspError)) {
+	c.nextID++
+	id := json.RawMessage(strconv.Itoa(c.nextID))
+	c.pending[c.nextID] = f
+	c.send(&lspMessage{ID: &id, Method: method, Params: params})
+}
+
+func (c *lspClient) handle(msg *lspIncoming) {
+	if c.dead {
+		return
+	}
+	switch {
+	case msg.Method != "" && msg.ID != nil:
+		c.send(&lspMessage{ID: msg.ID, Result: c.serverRequest(msg)})
+	case msg.Method != "":
+		c.serverNotification(msg)
+	case msg.ID != nil:
+		id, _ := strconv.Atoi(string(*msg.ID))
+		if f, ok := c.pending[id]; ok {
+			delete(c.pending, id)
+			f(msg.Result, msg.Error)
+		}
+	}
+}
+
+// Answers requests from the server, with null for those we don't know
+func (c *lspClient) serverRequest(msg *lspIncoming) json.RawMessage {
+	switch msg.Method {
+	case "workspace/configuration":
+		var params struct {
+			Items []interface{} `json:"items"`
+		}
+		json.Unmarshal(msg.Params, &params)
+		return json.RawMessage("[" + strings.TrimSuffix(strings.Repeat("null,", len(params.Items)), ",") + "]")
+	case "workspace/applyEdit":
+		var params struct {
+			Edit json.RawMessage `json:"edit"`
+		}
+		json.Unmarshal(msg.Params, &params)
+		applyWorkspaceEdit(params.Edit)
+		return json.RawMessage(`{"applied":true}`)
+	}
+	return json.RawMessage("null")
+}
+
+func (c *lspClient) serverNotification(msg *lspIncoming) {
+	switch msg.Method {
+	case "textDocument/publishDiagnostics":
+		var params struct {
+			URI         string `json:"uri"`
+			Diagnostics []struct {
+				Range    lspRange `json:"range"`
+				Severity int      `json:"severity"`
+				Message  string   `json:"message"`
+			} `json:"diagnostics"`
+		}
+		if json.Unmarshal(msg.Params, &params) != nil {
+			return
+		}
+		path := lspPath(params.URI)
+		lines := lspLines(path)
+		diags := []*Diagnostic{}
+		for _, d := range params.Diagnostics {
+			severity := DiagnosticSeverity(d.Severity)
+			if severity < DiagnosticError || severity > DiagnosticHint {
+				severity = DiagnosticError
+			}
+			diags = append(diags, &Diagnostic{
+				Severity: severity,
+				Beg:      lspToLocation(lines, d.Range.Start),
+				End:      lspToLocation(lines, d.Range.End),
+				Message:  d.Message,
+			})
+		}
+		setDiagnostics(path, "lsp", diags)
+	case "window/showMessage":
+		var params struct {
+			Type    int    `json:"type"`
+			Message string `json:"message"`
+		}
+		json.Unmarshal(msg.Params, &params)
+		if params.Type == 1 {
+			messageError(params.Message)
+		} else {
+			message(params.Message)
+		}
+	}
+}
+
+// Sends the server the change made to b, ranges being in the document as
+// it was before it
+func lspChange(b *Buffer, typ ActionType, loc *Location, data []rune) {
+	c := lspBuffers[b]
+	if c == nil {
+		return
+	}
+	if c.sync == lspSyncNone {
+		return
+	}
+	c.versions[b]++
+	var change interface{}
+	if c.sync == lspSyncFull {
+		change = map[string]interface{}{"text": b.Contents()}
+	} else {
+		start := lspPosition{Line: loc.Line, Character: utf16Len(b.Data[loc.Line][:loc.Char])}
+		end := start
+		text := string(data)
+		if typ != ActionTypeInsert {
+			lines := strings.Split(string(data), "\n")
+			end.Line += len(lines) - 1
+			if len(lines) == 1 {
+				end.Character += utf16Len(data)
+			} else {
+				end.Character = utf16Len([]rune(lines[len(lines)-1]))
+			}
+			text = ""
+		}
+		change = map[string]interface{}{"range": lspRange{Start: start, End: end}, "text": text}
+	}
+	c.notify("textDocument/didChange", map[string]interface{}{
+		"textDocument": map[string]interface{}{
+			"uri":     lspURI(b.Path),
+			"version": c.versions[b],
+		},
+		"contentChanges": []interface{}{change},
+	})
+}
+
+func lspURI(path string) string {
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String()
+}
+
+func lspPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return uri
+	}
+	return filepath.FromSlash(u.Path)
+}
+
+func lspDocument(b *Buffer) map[string]string {
+	return map[string]string{"uri": lspURI(b.Path)}
+}
+
+// Parameters of requests about the cursor position in b
+func lspCursor(b *Buffer) map[string]interface{} {
+	return map[string]interface{}{
+		"textDocument": lspDocument(b),
+		"position":     lspFromLocation(b.Data, b.Cursor),
+	}
+}
+
+func utf16Len(runes []rune) int {
+	n := 0
+	for _, r := range runes {
+		if r >= 0x10000 {
+			n += 2
+		} else {
+			n++
+		}
+	}
+	return n
+}
+
+func lspFromLocation(lines [][]rune, loc *Location) lspPosition {
+	line := []rune{}
+	if loc.Line < len(lines) {
+		line = lines[loc.Line]
+	}
+	return lspPosition{Line: loc.Line, Character: utf16Len(line[:min(loc.Char, len(line))])}
+}
+
+// Location of pos in lines, clamped to them
+func lspToLocation(lines [][]rune, pos lspPosition) *Location {
+	if pos.Line >= len(lines) {
+		if len(lines) == 0 {
+			return NewLocation(0, 0)
+		}
+		return NewLocation(len(lines)-1, len(lines[len(lines)-1]))
+	}
+	line := lines[max(pos.Line, 0)]
+	c, units := 0, 0
+	for c < len(line) && units < pos.Character {
+		units += utf16Len(line[c : c+1])
+		c++
+	}
+	return NewLocation(max(pos.Line, 0), c)
+}
+
+// Contents of the file at path, from its buffer if it has one
+func lspLines(path string) [][]rune {
+	if b := findBufferByPath(path); b != nil {
+		return b.Data
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	lines := [][]rune{}
+	for _, line := range strings.Split(string(data), "\n") {
+		lines = append(lines, []rune(line))
+	}
+	return lines
+}
+
+// Client b is opened in, complaining when there is none
+func lspClientFor(b *Buffer) *lspClient {
+	c := lspBuffers[b]
+	if c == nil {
+		messageError("No language server for this buffer")
+	}
+	return c
+}
+
+// Locations from a definition or references result
+func lspLocations(result json.RawMessage) []lspLocation {
+	locs := []lspLocation{}
+	if json.Unmarshal(result, &locs) != nil {
+		var loc lspLocation
+		if json.Unmarshal(result, &loc) != nil || loc.URI == "" {
+			return nil
+		}
+		locs = []lspLocation{loc}
+	}
+	for i, loc := range locs {
+		if loc.TargetURI != "" {
+			locs[i].URI = loc.TargetURI
+			if loc.TargetSelectionRange != nil {
+				locs[i].Range = *loc.TargetSelectionRange
+			}
+		}
+	}
+	return locs
+}
+
+// Quickfix entries for locations, their line as text
+func lspQuickfix(locs []lspLocation) []*quickfixEntry {
+	entries := []*quickfixEntry{}
+	for _, loc := range locs {
+		path := lspPath(loc.URI)
+		lines := lspLines(path)
+		l := lspToLocation(lines, loc.Range.Start)
+		text := ""
+		if l.Line < len(lines) {
+			text = strings.TrimSpace(string(lines[l.Line]))
+		}
+		entries = append(entries, &quickfixEntry{Path: path, Line: l.Line, Col: l.Char, Text: text})
+	}
+	return entries
+}
+
+func lspDefinition(b *Buffer) {
+	c := lspClientFor(b)
+	if c == nil {
+		return
+	}
+	c.request("textDocument/definition", lspCursor(b), func(result json.RawMessage) {
+		entries := lspQuickfix(lspLocations(result))
+		switch len(entries) {
+		case 0:
+			messageError("No definition found")
+		case 1:
+			e := entries[0]
+			if nb := showFile(e.Path); nb != nil {
+				nb.MoveTo(e.Col, e.Line)
+				currentViewTree.Leaf.CenterPending = true
+			}
+		default:
+			setQuickfix(entries)
+			quickfixGo(0)
+		}
+	})
+}
+
+func lspReferences(b *Buffer) {
+	c := lspClientFor(b)
+	if c == nil {
+		return
+	}
+	params := lspCursor(b)
+	params["context"] = map[string]bool{"includeDeclaration": true}
+	c.request("textDocument/references", params, func(result json.RawMessage) {
+		entries := lspQuickfix(lspLocations(result))
+		if len(entries) == 0 {
+			messageError("No references found")
+			return
+		}
+		setQuickfix(entries)
+		showQuickfix()
+	})
+}
+
+func lspHover(b *Buffer) {
+	c := lspClientFor(b)
+	if c == nil {
+		return
+	}
+	c.request("textDocument/hover", lspCursor(b), func(result json.RawMessage) {
+		var hover struct {
+			Contents json.RawMessage `json:"contents"`
+		}
+		json.Unmarshal(result, &hover)
+		text := strings.TrimSpace(lspMarkup(hover.Contents))
+		if text == "" {
+			message("No documentation")
+			return
+		}
+		lines := []string{}
+		for _, line := range strings.Split(text, "\n") {
+			// Code blocks show as they are, without fences
+			if !strings.HasPrefix(line, "```") {
+				lines = append(lines, strings.Replace(line, "\t", "    ", -1))
+			}
+		}
+		hoverPopup = &Popup{Lines: lines, Selected: -1}
+	})
+}
+
+// Text of hover contents: MarkupContent, a MarkedString or a list of those
+func lspMarkup(contents json.RawMessage) string {
+	var s string
+	if json.Unmarshal(contents, &s) == nil {
+		return s
+	}
+	var list []json.RawMessage
+	if json.Unmarshal(contents, &list) == nil {
+		parts := []string{}
+		for _, item := range list {
+			parts = append(parts, lspMarkup(item))
+		}
+		return strings.Join(parts, "\n\n")
+	}
+	var markup struct {
+		Value string `json:"value"`
+	}
+	json.Unmarshal(contents, &markup)
+	return markup.Value
+}
+
+// Draws the hover documentation above the cursor, or under it when
+// there's no room
+func renderHover(width, height int) {
+	v := currentViewTree.Leaf
+	lines := hoverPopup.Lines
+	w := 0
+	for _, line := range lines {
+		w = max(w, len([]rune(line))+4)
+	}
+	w = min(w, min(width, 80))
+	h := min(len(lines)+2, max(height/2, 3))
+	x := max(min(v.CursorX-2, width-w), 0)
+	y := v.CursorY - h
+	if y < 0 {
+		y = v.CursorY + 1
+	}
+	renderPopup(hoverPopup, x, y, w, h)
+}
+
+func lspRename(b *Buffer, name string) {
+	c := lspClientFor(b)
+	if c == nil {
+		return
+	}
+	params := lspCursor(b)
+	params["newName"] = name
+	c.request("textDocument/rename", params, func(result json.RawMessage) {
+		if string(result) == "null" || len(result) == 0 {
+			messageError("Nothing to rename here")
+			return
+		}
+		edits, files := applyWorkspaceEdit(result)
+		message("Renamed " + strconv.Itoa(edits) + " occurrences in " + strconv.Itoa(files) + " files")
+	})
+}
+
+// Applies the edits of a WorkspaceEdit, as one undo step per file.
+// Files with no buffer are opened then saved once edited.
+func applyWorkspaceEdit(data json.RawMessage) (edits, files int) {
+	var edit struct {
+		Changes         map[string][]lspTextEdit `json:"changes"`
+		DocumentChanges []struct {
+			TextDocument struct {
+				URI string `json:"uri"`
+			} `json:"textDocument"`
+			Edits []lspTextEdit `json:"edits"`
+		} `json:"documentChanges"`
+	}
+	if err := json.Unmarshal(data, &edit); err != nil {
+		messageError("Invalid edit: " + err.Error())
+		return 0, 0
+	}
+	byPath := map[string][]lspTextEdit{}
+	for uri, textEdits := range edit.Changes {
+		byPath[lspPath(uri)] = append(byPath[lspPath(uri)], textEdits...)
+	}
+	for _, change := range edit.DocumentChanges {
+		path := lspPath(change.TextDocument.URI)
+		byPath[path] = append(byPath[path], change.Edits...)
+	}
+	paths := []string{}
+	for path := range byPath {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		b := findBufferByPath(path)
+		opened := b == nil
+		if opened {
+			if b = openBufferFromFile(path); b == nil {
+				continue
+			}
+		}
+		applyTextEdits(b, byPath[path])
+		if opened {
+			b.Save()
+		}
+		edits += len(byPath[path])
+		files++
+	}
+	return edits, files
+}
+
+// Applies edits to b as one undo step, last ones first so that earlier
+// ranges stay valid
+func applyTextEdits(b *Buffer, edits []lspTextEdit) {
+	// Last first, so positions of the others still hold. Inserts at the
+	// same position end up in the order they're given.
+	order := make([]int, len(edits))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool {
+		p, q := edits[order[i]].Range.Start, edits[order[j]].Range.Start
+		if p.Line != q.Line {
+			return p.Line > q.Line
+		}
+		if p.Character != q.Character {
+			return p.Character > q.Character
+		}
+		return order[i] > order[j]
+	})
+	cursor := b.Cursor.Clone()
+	b.BeginUndoGroup()
+	for _, i := range order {
+		edit := edits[i]
+		beg := lspToLocation(b.Data, edit.Range.Start)
+		end := lspToLocation(b.Data, edit.Range.End)
+		if n := runesBetween(b, beg, end); n > 0 {
+			b.RemoveAt(beg, n)
+		}
+		if edit.NewText != "" {
+			b.InsertAt(beg, []rune(edit.NewText))
+		}
+	}
+	b.EndUndoGroup()
+	line := min(cursor.Line, len(b.Data)-1)
+	b.MoveTo(min(cursor.Char, len(b.Data[line])), line)
+}
+
+// Number of runes from beg to end, counting line ends
+func runesBetween(b *Buffer, beg, end *Location) int {
+	if end.Line == beg.Line {
+		return max(end.Char-beg.Char, 0)
+	}
+	n := len(b.Data[beg.Line]) - beg.Char + 1
+	for l := beg.Line + 1; l < end.Line; l++ {
+		n += len(b.Data[l]) + 1
+	}
+	return n + end.Char
+}
+
+func lspFormat(b *Buffer) {
+	c := lspClientFor(b)
+	if c == nil {
+		return
+	}
+	c.request("textDocument/formatting", map[string]interface{}{
+		"textDocument": lspDocument(b),
+		"options": map[string]interface{}{
+			"tabSize":      int(configGetNumber("tab_width", b)),
+			"insertSpaces": configGetBool("tab_to_spaces", b),
+		},
+	}, func(result json.RawMessage) {
+		edits := []lspTextEdit{}
+		json.Unmarshal(result, &edits)
+		if len(edits) == 0 {
+			message("Already formatted")
+			return
+		}
+		applyTextEdits(b, edits)
+		message("Formatted")
+	})
+}
+
+// Completion source asking the server of the buffer, if it has one
+func lspComplete(req *completionRequest, reply func([]completionItem)) {
+	b := req.Buf
+	c := lspBuffers[b]
+	if b == nil || c == nil {
+		reply(nil)
+		return
+	}
+	params := map[string]interface{}{
+		"textDocument": lspDocument(b),
+		"position":     lspFromLocation(b.Data, req.Cursor),
+	}
+	c.request("textDocument/completion", params, func(result json.RawMessage) {
+		type item struct {
+			Label      string       `json:"label"`
+			Detail     string       `json:"detail"`
+			InsertText string       `json:"insertText"`
+			TextEdit   *lspTextEdit `json:"textEdit"`
+		}
+		var list struct {
+			Items []item `json:"items"`
+		}
+		if json.Unmarshal(result, &list.Items) != nil {
+			json.Unmarshal(result, &list)
+		}
+		// Edits can only replace the word typed
+		beg := lspPosition{Line: req.Cursor.Line, Character: utf16Len([]rune(req.Text)) - utf16Len([]rune(req.Prefix))}
+		items := []completionItem{}
+		for _, it := range list.Items {
+			ci := completionItem{Label: it.Label, Insert: it.InsertText, Detail: it.Detail}
+			if it.TextEdit != nil && it.TextEdit.Range.Start == beg {
+				ci.Insert = it.TextEdit.NewText
+			}
+			items = append(items, ci)
+		}
+		reply(items)
+	})
+}
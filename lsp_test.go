@@ -0,0 +1,370 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Runs stubLSPServer when the test binary is started as a language server
+func TestHelperLSPServer(t *testing.T) {
+	switch os.Args[len(os.Args)-1] {
+	case "lsp-stub":
+		stubLSPServer(os.Stdin, os.Stdout, false)
+	case "lsp-stub-broken":
+		stubLSPServer(os.Stdin, os.Stdout, true)
+	default:
+		return
+	}
+	os.Exit(0)
+}
+
+// A language server knowing just enough for tests: it keeps the
+// documents it's sent up to date, flags lines with "bad" in them and
+// finds words rather than symbols. A broken one fails to initialize.
+func stubLSPServer(in io.Reader, out io.Writer, broken bool) {
+	docs := map[string][]string{}
+	r := bufio.NewReader(in)
+	send := func(msg map[string]interface{}) {
+		msg["jsonrpc"] = "2.0"
+		data, _ := json.Marshal(msg)
+		io.WriteString(out, "Content-Length: "+strconv.Itoa(len(data))+"\r\n\r\n"+string(data))
+	}
+	publish := func(uri string) {
+		diags := []interface{}{}
+		for l, line := range docs[uri] {
+			if c := strings.Index(line, "bad"); c >= 0 {
+				diags = append(diags, map[string]interface{}{
+					"range":    stubRange(l, c, l, c+3),
+					"severity": 2,
+					"message":  "bad word",
+				})
+			}
+		}
+		send(map[string]interface{}{"method": "textDocument/publishDiagnostics", "params": map[string]interface{}{
+			"uri": uri, "diagnostics": diags,
+		}})
+	}
+
+	for {
+		length := 0
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if line = strings.TrimSpace(line); line == "" {
+				break
+			}
+			length, _ = strconv.Atoi(strings.TrimPrefix(line, "Content-Length: "))
+		}
+		data := make([]byte, length)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return
+		}
+		var msg struct {
+			ID     *json.RawMessage
+			Method string
+			Params struct {
+				TextDocument struct {
+					URI  string
+					Text string
+				}
+				ContentChanges []struct {
+					Range *lspRange
+					Text  string
+				}
+				Position lspPosition
+				NewName  string
+			}
+		}
+		json.Unmarshal(data, &msg)
+		uri := msg.Params.TextDocument.URI
+		pos := msg.Params.Position
+		var result interface{}
+		switch msg.Method {
+		case "initialize":
+			if broken {
+				send(map[string]interface{}{"id": msg.ID, "error": map[string]interface{}{"code": -32603, "message": "broken"}})
+				continue
+			}
+			result = map[string]interface{}{"capabilities": map[string]interface{}{"textDocumentSync": 2}}
+		case "exit":
+			return
+		case "textDocument/didOpen":
+			docs[uri] = strings.Split(msg.Params.TextDocument.Text, "\n")
+			publish(uri)
+		case "textDocument/didChange":
+			for _, change := range msg.Params.ContentChanges {
+				text := strings.Join(docs[uri], "\n")
+				beg := stubOffset(docs[uri], change.Range.Start)
+				end := stubOffset(docs[uri], change.Range.End)
+				docs[uri] = strings.Split(text[:beg]+change.Text+text[end:], "\n")
+			}
+			publish(uri)
+		case "textDocument/hover":
+			result = map[string]interface{}{"contents": map[string]string{
+				"kind": "markdown", "value": "```\nhover: " + stubWordAt(docs[uri], pos) + "\n```",
+			}}
+		case "textDocument/definition":
+			for l, line := range docs[uri] {
+				if c := strings.Index(line, "func "+stubWordAt(docs[uri], pos)); c >= 0 {
+					result = map[string]interface{}{"uri": uri, "range": stubRange(l, c+5, l, c+5)}
+				}
+			}
+		case "textDocument/references":
+			locs := []interface{}{}
+			for _, rng := range stubOccurrences(docs[uri], stubWordAt(docs[uri], pos)) {
+				locs = append(locs, map[string]interface{}{"uri": uri, "range": rng})
+			}
+			result = locs
+		case "textDocument/rename":
+			edits := []interface{}{}
+			for _, rng := range stubOccurrences(docs[uri], stubWordAt(docs[uri], pos)) {
+				edits = append(edits, map[string]interface{}{"range": rng, "newText": msg.Params.NewName})
+			}
+			result = map[string]interface{}{"changes": map[string]interface{}{uri: edits}}
+		case "textDocument/completion":
+			line := docs[uri][pos.Line][:pos.Character]
+			prefix := regexp.MustCompile(`\w*$`).FindString(line)
+			items := []interface{}{}
+			seen := map[string]bool{prefix: true}
+			for _, word := range regexp.MustCompile(`\w+`).FindAllString(strings.Join(docs[uri], "\n"), -1) {
+				if strings.HasPrefix(word, prefix) && !seen[word] {
+					seen[word] = true
+					items = append(items, map[string]string{"label": word, "detail": "word"})
+				}
+			}
+			result = map[string]interface{}{"isIncomplete": false, "items": items}
+		case "textDocument/formatting":
+			edits := []interface{}{}
+			for l, line := range docs[uri] {
+				if trimmed := strings.TrimRight(line, " "); trimmed != line {
+					edits = append(edits, map[string]interface{}{
+						"range": stubRange(l, len(trimmed), l, len(line)), "newText": "",
+					})
+				}
+			}
+			result = edits
+		}
+		if msg.ID != nil {
+			send(map[string]interface{}{"id": msg.ID, "result": result})
+		}
+	}
+}
+
+func stubRange(l1, c1, l2, c2 int) lspRange {
+	return lspRange{Start: lspPosition{Line: l1, Character: c1}, End: lspPosition{Line: l2, Character: c2}}
+}
+
+func stubOffset(lines []string, pos lspPosition) int {
+	offset := 0
+	for l := 0; l < pos.Line; l++ {
+		offset += len(lines[l]) + 1
+	}
+	return offset + pos.Character
+}
+
+func stubWordAt(lines []string, pos lspPosition) string {
+	for _, m := range regexp.MustCompile(`\w+`).FindAllStringIndex(lines[pos.Line], -1) {
+		if m[0] <= pos.Character && pos.Character < m[1] {
+			return lines[pos.Line][m[0]:m[1]]
+		}
+	}
+	return ""
+}
+
+func stubOccurrences(lines []string, word string) []lspRange {
+	ranges := []lspRange{}
+	re := regexp.MustCompile(`\b` + regexp.QuoteMeta(word) + `\b`)
+	for l, line := range lines {
+		for _, m := range re.FindAllStringIndex(line, -1) {
+			ranges = append(ranges, stubRange(l, m[0], l, m[1]))
+		}
+	}
+	return ranges
+}
+
+// Opens files of the stub filetype in a headless editor, served by the
+// test binary
+func newStubLSP(t *testing.T, files map[string]string) (*headless, string) {
+	dir := writeProject(t, files)
+	h := newHeadlessScreen(80, 24)
+	lspServers["stub"] = []string{os.Args[0], "-test.run=^TestHelperLSPServer$", "--", "lsp-stub"}
+	h.Open([]string{filepath.Join(dir, "main.stub")})
+	t.Cleanup(stopLanguageServers)
+	return h, dir
+}
+
+// Handles messages from servers until cond is true
+func waitFor(t *testing.T, h *headless, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for " + what)
+		}
+		time.Sleep(5 * time.Millisecond)
+		h.Process()
+	}
+}
+
+func TestLSPSync(t *testing.T) {
+	h, _ := newStubLSP(t, map[string]string{
+		"go.mod":    "module stub\n",
+		"main.stub": "func main\nbad call\n",
+	})
+	waitFor(t, h, "diagnostics", func() bool { return len(bufferDiagnostics(h.Buffer())) == 1 })
+	if d := bufferDiagnostics(h.Buffer())[0]; d.Beg.Line != 1 || d.Beg.Char != 0 || d.Message != "bad word" {
+		t.Fatalf("unexpected diagnostic %+v", d)
+	}
+
+	// Edits are sent as changes, the server's copy following along
+	h.Keys("j x x x x i g o o d ESC o b a d ESC")
+	waitFor(t, h, "diagnostics", func() bool {
+		diags := bufferDiagnostics(h.Buffer())
+		return len(diags) == 1 && diags[0].Beg.Line == 2
+	})
+	h.Keys("0 x x x")
+	waitFor(t, h, "diagnostics", func() bool { return len(bufferDiagnostics(h.Buffer())) == 0 })
+	h.Keys("i b a d ESC")
+	waitFor(t, h, "diagnostics", func() bool { return len(bufferDiagnostics(h.Buffer())) == 1 })
+	h.Keys("g g A b a d ESC j 0 i BAK ESC")
+	expectContents(t, h, "func mainbadgoodcall\nbad\n")
+	waitFor(t, h, "diagnostics", func() bool {
+		diags := bufferDiagnostics(h.Buffer())
+		return len(diags) == 2 && diags[0].Beg.Char == 9 && diags[1].Beg.Line == 1
+	})
+}
+
+func TestLSPFeatures(t *testing.T) {
+	h, dir := newStubLSP(t, map[string]string{
+		"go.mod":    "module stub\n",
+		"main.stub": "func main\n  call helper  \nfunc helper\nhelper done\n",
+	})
+	b := h.Buffer()
+	waitFor(t, h, "server", func() bool { return lspBuffers[b] != nil && lspBuffers[b].ready })
+
+	h.Keys("j w w K")
+	waitFor(t, h, "hover", func() bool { return hoverPopup != nil })
+	if len(hoverPopup.Lines) != 1 || hoverPopup.Lines[0] != "hover: helper" {
+		t.Fatalf("unexpected hover %q", hoverPopup.Lines)
+	}
+	h.Keys("g d")
+	waitFor(t, h, "definition", func() bool { line, _ := h.Cursor(); return line == 2 })
+	if hoverPopup != nil {
+		t.Fatal("expected the hover popup gone")
+	}
+	if _, char := h.Cursor(); char != 5 {
+		t.Fatalf("expected the cursor on helper, got %d", char)
+	}
+
+	h.Keys("g r")
+	waitFor(t, h, "references", func() bool { return len(quickfixList) == 3 })
+	if h.Buffer().Name != "*quickfix*" || !strings.HasSuffix(string(h.Buffer().Data[2]), "4:1: helper done") {
+		t.Fatalf("expected the references listed, got %q", h.Contents())
+	}
+	h.Keys("q")
+
+	h.Keys(": r e n a m e SPC a s s i s t RET")
+	waitFor(t, h, "rename", func() bool { return strings.Contains(b.Contents(), "assist done") })
+	expectContents(t, h, "func main\n  call assist  \nfunc assist\nassist done\n")
+	if h.Message() != "Renamed 3 occurrences in 1 files" {
+		t.Fatalf("unexpected message %q", h.Message())
+	}
+	h.Keys("u")
+	expectContents(t, h, "func main\n  call helper  \nfunc helper\nhelper done\n")
+
+	h.Keys(": f o r m a t RET")
+	waitFor(t, h, "format", func() bool { return !strings.Contains(b.Contents(), "  \n") })
+	expectContents(t, h, "func main\n  call helper\nfunc helper\nhelper done\n")
+
+	// Completions preview in the buffer, ESC going back to what was typed
+	h.Keys("G o h e C-x C-o")
+	waitFor(t, h, "completion", func() bool { return editorCompletion != nil })
+	expectContents(t, h, "func main\n  call helper\nfunc helper\nhelper done\nhelper\n")
+	if !strings.Contains(h.Line(6), "helper  word") {
+		t.Fatalf("expected the completion menu, got %q", h.Line(6))
+	}
+	h.Keys("ESC")
+	expectContents(t, h, "func main\n  call helper\nfunc helper\nhelper done\nhe\n")
+	if h.Mode() != "insert" {
+		t.Fatalf("expected insert mode, got %s", h.Mode())
+	}
+	h.Keys("C-x C-o")
+	waitFor(t, h, "completion", func() bool { return editorCompletion != nil })
+	h.Keys("SPC x ESC")
+	expectContents(t, h, "func main\n  call helper\nfunc helper\nhelper done\nhelper x\n")
+
+	if _, err := os.Stat(filepath.Join(dir, "main.stub")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLSPRestart(t *testing.T) {
+	h, dir := newStubLSP(t, map[string]string{
+		"go.mod":     "module stub\n",
+		"main.stub":  "func main\n",
+		"other.stub": "bad\n",
+	})
+	main := h.Buffer()
+	c := lspBuffers[main]
+	waitFor(t, h, "server", func() bool { return c != nil && c.ready })
+	c.cmd.Process.Kill()
+	waitFor(t, h, "exit", func() bool { return len(lspClients) == 0 })
+	if lspBuffers[main] != nil {
+		t.Fatal("expected the buffer left without a server")
+	}
+
+	// Servers that crashed start again with the next file, taking back
+	// the files opened before
+	runCommandLine("e " + filepath.Join(dir, "other.stub"))
+	waitFor(t, h, "diagnostics", func() bool { return len(bufferDiagnostics(h.Buffer())) == 1 })
+	if lspBuffers[h.Buffer()] == c {
+		t.Fatal("expected a new server")
+	}
+	if lspBuffers[main] != lspBuffers[h.Buffer()] {
+		t.Fatal("expected the first file opened in the new server")
+	}
+}
+
+func TestLSPInitializeError(t *testing.T) {
+	dir := writeProject(t, map[string]string{"go.mod": "module stub\n", "main.stub": "bad\n"})
+	h := newHeadlessScreen(80, 24)
+	lspServers["stub"] = []string{os.Args[0], "-test.run=^TestHelperLSPServer$", "--", "lsp-stub-broken"}
+	h.Open([]string{filepath.Join(dir, "main.stub")})
+	t.Cleanup(stopLanguageServers)
+	c := lspBuffers[h.Buffer()]
+	waitFor(t, h, "initialize", func() bool { return c.dead })
+	if lspBuffers[h.Buffer()] != nil || len(lspClients) != 0 || len(c.queued) != 0 {
+		t.Fatal("expected the server dropped")
+	}
+	if h.Message() != "Language server for stub failed to start: broken" {
+		t.Fatalf("unexpected message %q", h.Message())
+	}
+}
+
+func TestApplyTextEdits(t *testing.T) {
+	newHeadless(80, 24, nil)
+	b := NewBuffer("edits", "")
+	b.Data = [][]rune{[]rune("ab"), []rune("cd")}
+	at := func(line, char int) lspRange {
+		return lspRange{lspPosition{line, char}, lspPosition{line, char}}
+	}
+	applyTextEdits(b, []lspTextEdit{
+		{at(0, 1), "1"},
+		{lspRange{lspPosition{0, 0}, lspPosition{0, 1}}, "X"},
+		{at(0, 1), "2"},
+		{at(1, 2), "!"},
+	})
+	if b.Contents() != "X12b\ncd!\n" {
+		t.Fatalf("expected inserts in order, got %q", b.Contents())
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"strings"
+)
+
+// Mappings are user defined bindings whose right hand side is either an ex
+// command (starting with ":") or more keys, handled as if they were typed.
+//
+//   :nmap $leader w :write
+//   :nnoremap j => j z z
+//   :imap C-s => ESC : w RET
+//
+// Keys from a recursive mapping can trigger other mappings; keys from a
+// noremap one only trigger the built-in bindings. A left hand side of more
+// than one key is separated from the right hand side with "=>".
+
+type Mapping struct {
+	Mode    string
+	Keys    *KeyList
+	Rhs     string
+	Noremap bool
+}
+
+const mappingMaxDepth = 100
+
+var (
+	mappingList []*Mapping
+
+	// While above 0, keys only trigger built-in bindings
+	mappingsSuspended = 0
+	mappingDepth      = 0
+	mappingAborted    = false
+)
+
+func initMappings() {
+	mappingList = []*Mapping{}
+	mappingsSuspended = 0
+	mappingDepth = 0
+	mappingAborted = false
+
+	addOption("leader", "SPC", "Key $leader stands for in bindings and mappings")
+
+	// Keys use $ and quotes of their own, map commands take raw arguments
+	mapCommand := func(modeNames []string, noremap bool) func([]string) {
+		return func(args []string) {
+			words := strings.Fields(args[1])
+			if len(words) == 0 {
+				showMappings()
+				return
+			}
+			lhs, rhs, err := parseMapArgs(words)
+			if err != nil {
+				messageError(err.Error())
+				return
+			}
+			for _, mode := range modeNames {
+				if err := addMapping(mode, lhs, rhs, noremap); err != nil {
+					messageError(err.Error())
+					return
+				}
+			}
+		}
+	}
+	unmapCommand := func(modeNames []string) func([]string) {
+		return func(args []string) {
+			if strings.TrimSpace(args[1]) == "" {
+				messageError("Usage: " + args[0] + " <keys>")
+				return
+			}
+			found := false
+			for _, mode := range modeNames {
+				found = removeMapping(mode, args[1]) || found
+			}
+			if !found {
+				messageError("No mapping for '" + args[1] + "'")
+			}
+		}
+	}
+
+	for _, prefix := range []string{"", "n", "i", "v"} {
+		modeNames := mapModes(prefix)
+		in := " in " + strings.Join(modeNames, ", ") + " modes"
+		addRawCommand(prefix+"map", "Maps keys to other keys or a :command"+in, mapCommand(modeNames, false))
+		addRawCommand(prefix+"noremap", "Maps keys, ignoring other mappings,"+in, mapCommand(modeNames, true))
+		addRawCommand(prefix+"unmap", "Removes a mapping"+in, unmapCommand(modeNames))
+	}
+
+	// [map.<mode>] and [noremap.<mode>] tables in config.toml
+	configSections["map"] = func(value interface{}) error {
+		return configLoadMappings(value, false)
+	}
+	configSections["noremap"] = func(value interface{}) error {
+		return configLoadMappings(value, true)
+	}
+}
+
+// Modes a :map variant applies to, visual covering both visual modes
+func mapModes(prefix string) []string {
+	switch prefix {
+	case "n":
+		return []string{"normal"}
+	case "i":
+		return []string{"insert"}
+	case "v":
+		return []string{"visual", "visual-line"}
+	}
+	return []string{"normal", "visual", "visual-line"}
+}
+
+func parseMapArgs(args []string) (string, string, error) {
+	for i, arg := range args {
+		if arg == "=>" {
+			if i == 0 || i == len(args)-1 {
+				break
+			}
+			return strings.Join(args[:i], " "), strings.Join(args[i+1:], " "), nil
+		}
+	}
+	if len(args) < 2 {
+		return "", "", errors.New("Usage: map <keys> <keys or :command>")
+	}
+	return args[0], strings.Join(args[1:], " "), nil
+}
+
+func addMapping(modeName, lhs, rhs string, noremap bool) error {
+	mode := findMode(modeName)
+	if mode == nil {
+		return errors.New("No mode named '" + modeName + "'")
+	}
+	keys := k(lhs)
+	if len(keys.keys) == 0 || strings.TrimSpace(rhs) == "" {
+		return errors.New("Mappings need keys and something to map them to")
+	}
+	removeMapping(modeName, lhs)
+
+	mapping := &Mapping{Mode: modeName, Keys: keys, Rhs: rhs, Noremap: noremap}
+	mappingList = append(mappingList, mapping)
+	mode.mappings = append(mode.mappings, &ModeBinding{k: keys, desc: rhs, mode: modeName, f: func(vt *ViewTree, b *Buffer, kl *KeyList) {
+		runMapping(mapping)
+	}})
+	return nil
+}
+
+// Removes the mapping for lhs in a mode, returning whether there was one
+func removeMapping(modeName, lhs string) bool {
+	mode := findMode(modeName)
+	if mode == nil {
+		return false
+	}
+	rep := k(lhs).String()
+	found := false
+	for i, binding := range mode.mappings {
+		if binding.k.String() == rep {
+			mode.mappings = append(mode.mappings[:i], mode.mappings[i+1:]...)
+			found = true
+			break
+		}
+	}
+	for i, mapping := range mappingList {
+		if mapping.Mode == modeName && mapping.Keys.String() == rep {
+			mappingList = append(mappingList[:i], mappingList[i+1:]...)
+			break
+		}
+	}
+	return found
+}
+
+func findMapping(modeName string, keys *KeyList) *Mapping {
+	for _, mapping := range mappingList {
+		if mapping.Mode == modeName && mapping.Keys.String() == keys.String() {
+			return mapping
+		}
+	}
+	return nil
+}
+
+func runMapping(m *Mapping) {
+	if mappingDepth >= mappingMaxDepth {
+		messageError("Mapping for '" + m.Keys.String() + "' is recursive")
+		mappingAborted = true
+		return
+	}
+	mappingDepth++
+	defer func() {
+		mappingDepth--
+		if mappingDepth == 0 {
+			mappingAborted = false
+		}
+	}()
+
+	// ": w RET" are keys typing a command, ":write" runs it directly
+	if strings.HasPrefix(m.Rhs, ":") && len(m.Rhs) > 1 && m.Rhs[1] != ' ' {
+		runCommandLine(m.Rhs[1:])
+		return
+	}
+
+	if m.Noremap {
+		mappingsSuspended++
+		defer func() { mappingsSuspended-- }()
+	}
+	for _, key := range k(m.Rhs).keys {
+		if key.Key == KeyTypeLeader {
+			key = leaderKey()
+		}
+		if mappingAborted {
+			return
+		}
+		handleKey(key)
+	}
+}
+
+func configLoadMappings(value interface{}, noremap bool) error {
+	modeTables, ok := value.(map[string]interface{})
+	if !ok {
+		return errors.New("mappings must be in tables named after modes")
+	}
+	for modeName, table := range modeTables {
+		maps, ok := table.(map[string]interface{})
+		if !ok {
+			return errors.New("mappings for " + modeName + " must be a table")
+		}
+		modeNames := []string{modeName}
+		if modeName == "visual" {
+			modeNames = mapModes("v")
+		}
+		for lhs, rhs := range maps {
+			rhsString, ok := rhs.(string)
+			if !ok {
+				return errors.New("mapping for '" + lhs + "' must be a string")
+			}
+			for _, mode := range modeNames {
+				if err := addMapping(mode, lhs, rhsString, noremap); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Lists user mappings in a buffer
+func showMappings() {
+	lines := []string{}
+	for _, m := range mappingList {
+		kind := "map"
+		if m.Noremap {
+			kind = "noremap"
+		}
+		lines = append(lines, padr(m.Mode, 12, ' ')+padr(kind, 9, ' ')+padr(m.Keys.String(), 20, ' ')+m.Rhs)
+	}
+	sort.Strings(lines)
+	if len(lines) == 0 {
+		message("No mappings")
+		return
+	}
+
+	var b *Buffer
+	if b = findBuffer("*mappings*"); b == nil {
+		b = openBufferNamed("*mappings*")
+	}
+	b.Data = [][]rune{}
+	for _, line := range lines {
+		b.Data = append(b.Data, []rune(line))
+	}
+	hook_trigger_buffer("modified", b)
+	showBuffer(b.Name)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestMapKeys(t *testing.T) {
+	h := newHeadless(80, 24, []string{tempFile(t, "one\ntwo\nthree\n")})
+	h.Keys(": n m a p SPC Q SPC d SPC d RET")
+	h.Keys("Q")
+	expectContents(t, h, "two\nthree\n")
+
+	// Recursive mappings see other mappings, noremap ones don't
+	h.Keys(": n m a p SPC X SPC Q RET : n n o r e m a p SPC Y SPC Q RET")
+	h.Keys("X")
+	expectContents(t, h, "three\n")
+	h.Keys("Y")
+	expectContents(t, h, "three\n")
+
+	h.Keys(": n u n m a p SPC Q RET Q")
+	expectContents(t, h, "three\n")
+}
+
+func TestMapOverridesBinding(t *testing.T) {
+	h := newHeadless(80, 24, []string{tempFile(t, "one\ntwo\nthree\n")})
+	// j keeps moving down in the mapping as noremap skips the mapping itself
+	h.Keys(": n n o r e m a p SPC j SPC j SPC j RET j")
+	expectCursor(t, h, 2, 0)
+	h.Keys(": n m a p SPC x SPC x RET x")
+	if h.Message() != "Mapping for 'x' is recursive" {
+		t.Fatalf("unexpected message %q", h.Message())
+	}
+}
+
+func TestMapCommandAndLeader(t *testing.T) {
+	path := tempFile(t, "one\n")
+	h := newHeadless(80, 24, []string{path})
+	h.Keys(": n m a p SPC $ l e a d e r SPC w SPC = > SPC : w r i t e RET")
+	h.Keys("x SPC w")
+	if contents, _ := ioutil.ReadFile(path); string(contents) != "ne\n" {
+		t.Fatalf("expected the mapping to write the file, got %q", contents)
+	}
+	h.Keys(": s e t SPC l e a d e r = , RET x , w")
+	if contents, _ := ioutil.ReadFile(path); string(contents) != "e\n" {
+		t.Fatalf("expected the new leader to be used, got %q", contents)
+	}
+}
+
+func TestMapConfig(t *testing.T) {
+	h := newHeadless(80, 24, []string{tempFile(t, "one\ntwo\n")})
+	path := filepath.Join(filepath.Dir(tempFile(t, "")), "config.toml")
+	err := ioutil.WriteFile(path, []byte(`
+[map.normal]
+"g d" = "d d"
+
+[noremap.insert]
+"C-l" = "ESC"
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := configLoad(path); err != nil {
+		t.Fatal(err)
+	}
+	h.Keys("g d i x C-l")
+	expectContents(t, h, "xtwo\n")
+	if h.Mode() != "normal" {
+		t.Fatalf("expected normal mode, got %s", h.Mode())
+	}
+}
@@ -2,18 +2,18 @@ package main
 
 func initVisual() {
 	addMode("visual")
-	bind("visual", k("ESC"), exitVisualMode)
-	bind("visual", k("y"), visualModeYank)
-	bind("visual", k("d"), visualModeDelete)
-	bind("visual", k("p"), visualModePaste)
-	bind("visual", k("c"), visualModeChange)
+	bindDesc("visual", k("ESC"), "Exit", exitVisualMode)
+	bindDesc("visual", k("y"), "Copy", visualModeYank)
+	bindDesc("visual", k("d"), "Delete", visualModeDelete)
+	bindDesc("visual", k("p"), "Paste", visualModePaste)
+	bindDesc("visual", k("c"), "Change", visualModeChange)
 
 	addMode("visual-line")
-	bind("visual-line", k("ESC"), exitVisualMode)
-	bind("visual-line", k("y"), visualModeYank)
-	bind("visual-line", k("d"), visualModeDelete)
-	bind("visual-line", k("p"), visualModePaste)
-	bind("visual-line", k("c"), visualModeChange)
+	bindDesc("visual-line", k("ESC"), "Exit", exitVisualMode)
+	bindDesc("visual-line", k("y"), "Copy", visualModeYank)
+	bindDesc("visual-line", k("d"), "Delete", visualModeDelete)
+	bindDesc("visual-line", k("p"), "Paste", visualModePaste)
+	bindDesc("visual-line", k("c"), "Change", visualModeChange)
 
 	hook_buffer("moved", visualRehighlight)
 }
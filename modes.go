@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/gdamore/tcell"
 )
@@ -11,33 +12,137 @@ import (
 type CommandFn func(*ViewTree, *Buffer, *KeyList)
 
 type ModeBinding struct {
-	k *KeyList
-	f CommandFn
+	k    *KeyList
+	f    CommandFn
+	desc string // shown in the which-key popup, optional
+	mode string
 }
 
 type Mode struct {
 	name     string
 	bindings []*ModeBinding
+	// User mappings (see mappings.go), tried before bindings
+	mappings []*ModeBinding
+	// Buffer modes binding plain keys (like q) only handle them in normal
+	// mode, leaving the buffer editable in insert mode
+	normalOnly bool
 }
 
 var modes = map[string]*Mode{}
 
-func modeHandle(m *Mode, kl *KeyList) *KeyList {
-	var match *KeyList = nil
-	var matchBinding *ModeBinding = nil
-	for _, binding := range m.bindings {
-		if matched := kl.HasSuffix(binding.k); matched != nil {
-			if match == nil || len(matched.keys) > len(match.keys) {
-				matchBinding = binding
-				match = matched
+// Finds the binding for exactly the keys in kl in the modes given, in order
+// of priority. pending tells if a longer binding starts with kl in a mode
+// at least as important as the one found, meaning more keys could come.
+// When many bindings match, the most specific one wins, then mappings
+// over built-in bindings.
+func findBinding(modeNames []string, kl *KeyList) (binding *ModeBinding, pending bool) {
+	for _, name := range modeNames {
+		m := mustFindMode(name)
+		candidates := m.bindings
+		if mappingsSuspended == 0 {
+			candidates = append(append([]*ModeBinding{}, m.mappings...), m.bindings...)
+		}
+		bestScore := -1
+		for _, b := range candidates {
+			if len(b.k.keys) == len(kl.keys) {
+				if score := b.k.Specificity(); score > bestScore && b.k.Matches(kl) {
+					binding, bestScore = b, score
+				}
+			} else if len(b.k.keys) > len(kl.keys) && (&KeyList{b.k.keys[:len(kl.keys)]}).Matches(kl) {
+				pending = true
 			}
 		}
+		if binding != nil {
+			return binding, pending
+		}
+	}
+	return nil, pending
+}
+
+// Modes handling keys right now, buffer modes first. The prompt takes
+// keys for itself, so buffer modes are left out while it's open.
+func activeModes() []string {
+	if editorMode == "prompt" || editorMode == "picker" {
+		return []string{editorMode}
 	}
-	if match != nil {
-		matchBinding.f(currentViewTree, currentViewTree.Leaf.Buf, match)
-		return match
+	names := []string{}
+	if editorCompletion != nil && editorMode == "insert" {
+		names = append(names, "completion")
+	}
+	if editorSnippet != nil && editorMode == "insert" && currentViewTree.Leaf.Buf == editorSnippet.Buf {
+		if editorSnippet.fresh {
+			names = append(names, "placeholder")
+		}
+		names = append(names, "snippet")
+	}
+	for _, name := range currentViewTree.Leaf.Buf.Modes {
+		if editorMode == "normal" || !mustFindMode(name).normalOnly {
+			names = append(names, name)
+		}
 	}
-	return nil
+	return append(names, editorMode)
+}
+
+// Runs bindings for the keys entered. When they could be the start of a
+// longer binding, waits for more keys, or the timeout. Keys that can't be
+// part of any binding are dropped. flush runs what matches without waiting.
+func dispatchKeys(flush bool) {
+	keyTimeoutGen++
+	for len(keysEntered.keys) > 0 {
+		kl := keysEntered
+		binding, pending := findBinding(activeModes(), kl)
+		if pending && !flush {
+			// Keys only starting longer bindings wait for more as long as
+			// needed, the which-key popup showing what can follow
+			keysPendingSince = time.Now()
+			if binding != nil {
+				startKeyTimeout()
+			}
+			return
+		}
+
+		if describingKeys {
+			describingKeys = false
+			keysEntered = k("")
+			describeKeys(kl, binding)
+			return
+		}
+
+		// Without a binding for all of them, run the longest start of the
+		// keys that has one, else drop the first key and try again
+		n := len(kl.keys)
+		for binding == nil && n > 1 {
+			n--
+			binding, _ = findBinding(activeModes(), &KeyList{kl.keys[:n]})
+		}
+		rest := &KeyList{append([]*Key{}, kl.keys[n:]...)}
+		if binding == nil {
+			keysEntered = &KeyList{kl.keys[1:]}
+			continue
+		}
+
+		// The binding can feed keys itself (mappings), give it a clean slate
+		keysEntered = k("")
+		lastKey = &KeyList{kl.keys[:n]}
+		binding.f(currentViewTree, currentViewTree.Leaf.Buf, lastKey)
+		keysEntered.keys = append(keysEntered.keys, rest.keys...)
+	}
+}
+
+var keyTimeoutGen = 0
+
+// Runs what the pending keys match once the "timeout" option's
+// milliseconds pass without another key
+func startKeyTimeout() {
+	gen := keyTimeoutGen
+	timeout := time.Duration(configGetNumber("timeout", nil)) * time.Millisecond
+	time.AfterFunc(timeout, func() {
+		runInMainLoop(func() {
+			if gen == keyTimeoutGen {
+				dispatchKeys(true)
+			}
+		})
+	})
 }
 
 func findMode(name string) *Mode {
@@ -59,58 +164,67 @@ func mustFindMode(name string) *Mode {
 // Adds a new empty mode to the mode list, if not already present
 func addMode(name string) {
 	if _, ok := modes[name]; !ok {
-		modes[name] = &Mode{name: name, bindings: []*ModeBinding{}}
+		modes[name] = &Mode{name: name, bindings: []*ModeBinding{}, mappings: []*ModeBinding{}}
 	}
 }
 
 func bind(mode_name string, k *KeyList, f CommandFn) {
+	bindDesc(mode_name, k, "", f)
+}
+
+// Binds keys like bind, with a short description of what they do
+func bindDesc(mode_name string, k *KeyList, desc string, f CommandFn) {
 	mode := mustFindMode(mode_name)
 
 	// If this key is bound, update bound function
 	for _, binding := range mode.bindings {
 		if k.String() == binding.k.String() {
 			binding.f = f
+			binding.desc = desc
+			return
 		}
 	}
 	// Else, it's a new binding, add it
-	mode.bindings = append(mode.bindings, &ModeBinding{k: k, f: f})
+	mode.bindings = append(mode.bindings, &ModeBinding{k: k, f: f, desc: desc, mode: mode_name})
 }
 
 func initModes() {
+	modes = map[string]*Mode{}
+
 	addMode("normal")
-	bind("normal", k("m $alpha"), commandMark)
-	bind("normal", k("' $alpha"), commandMoveToMark)
-	bind("normal", k(":"), promptCommand)
-	bind("normal", k("h"), moveLeft)
-	bind("normal", k("j"), moveDown)
-	bind("normal", k("k"), moveUp)
-	bind("normal", k("l"), moveRight)
-	bind("normal", k("0"), moveLineBeg)
-	bind("normal", k("$"), moveLineEnd)
-	bind("normal", k("g g"), moveTop)
-	bind("normal", k("G"), moveBottom)
-	bind("normal", k("C-u"), moveJumpUp)
-	bind("normal", k("C-d"), moveJumpDown)
-	bind("normal", k("z z"), moveCenterLine)
-	bind("normal", k("w"), moveWordForward)
-	bind("normal", k("e"), moveWordEndForward)
-	bind("normal", k("b"), moveWordBackward)
+	bindDesc("normal", k("m $alpha"), "Set mark", commandMark)
+	bindDesc("normal", k("' $alpha"), "Go to mark", commandMoveToMark)
+	bindDesc("normal", k(":"), "Run a command", promptCommand)
+	bindDesc("normal", k("h"), "Left", moveLeft)
+	bindDesc("normal", k("j"), "Down", moveDown)
+	bindDesc("normal", k("k"), "Up", moveUp)
+	bindDesc("normal", k("l"), "Right", moveRight)
+	bindDesc("normal", k("0"), "Line start", moveLineBeg)
+	bindDesc("normal", k("$"), "Line end", moveLineEnd)
+	bindDesc("normal", k("g g"), "Go to top", moveTop)
+	bindDesc("normal", k("G"), "Go to bottom", moveBottom)
+	bindDesc("normal", k("C-u"), "Jump up", moveJumpUp)
+	bindDesc("normal", k("C-d"), "Jump down", moveJumpDown)
+	bindDesc("normal", k("z z"), "Center line", moveCenterLine)
+	bindDesc("normal", k("w"), "Next word", moveWordForward)
+	bindDesc("normal", k("e"), "Word end", moveWordEndForward)
+	bindDesc("normal", k("b"), "Previous word", moveWordBackward)
 	bind("normal", k("C-c"), cancelKeysEntered)
 	bind("normal", k("C-g"), cancelKeysEntered)
 	bind("normal", k("ESC ESC"), cancelKeysEntered)
-	bind("normal", k("i"), enterInsertMode)
-	bind("normal", k("a"), enterInsertModeAppend)
-	bind("normal", k("A"), enterInsertModeEol)
-	bind("normal", k("o"), enterInsertModeNl)
-	bind("normal", k("O"), enterInsertModeNlUp)
-	bind("normal", k("x"), removeChar)
-	bind("normal", k("d d"), removeLine)
-	bind("normal", k("y y"), commandCopyLine)
-	bind("normal", k("p"), commandPaste)
-	bind("normal", k("u"), commandUndo)
-	bind("normal", k("C-r"), commandRedo)
-	bind("normal", k("v"), enterVisualMode)
-	bind("normal", k("V"), enterVisualBlockMode)
+	bindDesc("normal", k("i"), "Insert", enterInsertMode)
+	bindDesc("normal", k("a"), "Append", enterInsertModeAppend)
+	bindDesc("normal", k("A"), "Append at line end", enterInsertModeEol)
+	bindDesc("normal", k("o"), "Open line below", enterInsertModeNl)
+	bindDesc("normal", k("O"), "Open line above", enterInsertModeNlUp)
+	bindDesc("normal", k("x"), "Delete char", removeChar)
+	bindDesc("normal", k("d d"), "Delete line", removeLine)
+	bindDesc("normal", k("y y"), "Copy line", commandCopyLine)
+	bindDesc("normal", k("p"), "Paste", commandPaste)
+	bindDesc("normal", k("u"), "Undo", commandUndo)
+	bindDesc("normal", k("C-r"), "Redo", commandRedo)
+	bindDesc("normal", k("v"), "Visual mode", enterVisualMode)
+	bindDesc("normal", k("V"), "Visual line mode", enterVisualBlockMode)
 
 	addMode("insert")
 	bind("insert", k("ESC"), enterNormalMode)
@@ -119,14 +233,6 @@ func initModes() {
 	bind("insert", k("BAK"), insertBackspace)
 	bind("insert", k("$any"), insert)
 
-	addMode("prompt")
-	bind("prompt", k("C-c"), promptCancel)
-	bind("prompt", k("C-g"), promptCancel)
-	bind("prompt", k("ESC"), promptCancel)
-	bind("prompt", k("RET"), promptFinish)
-	bind("prompt", k("BAK"), promptBackspace)
-	bind("prompt", k("$any"), promptInsert)
-
 	addMode("buffers")
 	bind("buffers", k("q"), func(vt *ViewTree, b *Buffer, kl *KeyList) {
 		closeCurrentBuffer(true)
@@ -147,8 +253,7 @@ func initModes() {
 		runCommand([]string{"edit", file_path})
 	})
 
-	// TODO Remove once we have user configurable bindings
-	bind("normal", k("SPC b"), func(vt *ViewTree, b *Buffer, kl *KeyList) {
+	bindDesc("normal", k("$leader b"), "Buffers", func(vt *ViewTree, b *Buffer, kl *KeyList) {
 		runCommand([]string{"buffers"})
 	})
 	editCurrentFolder := func(vt *ViewTree, b *Buffer, kl *KeyList) {
@@ -158,9 +263,9 @@ func initModes() {
 			runCommand([]string{"edit", filepath.Dir(b.Path)})
 		}
 	}
-	bind("normal", k("SPC f"), editCurrentFolder)
+	bindDesc("normal", k("$leader f"), "Browse folder", editCurrentFolder)
 	bind("normal", k("-"), editCurrentFolder)
-	bind("normal", k("SPC n"), func(vt *ViewTree, b *Buffer, kl *KeyList) {
+	bindDesc("normal", k("$leader n"), "Clear search", func(vt *ViewTree, b *Buffer, kl *KeyList) {
 		runCommand([]string{"clearsearch"})
 	})
 }
@@ -215,6 +320,9 @@ func cancelKeysEntered(vt *ViewTree, b *Buffer, kl *KeyList) {
 // Enter in a new mode
 func enterMode(mode string) {
 	editorMode = mode
+	if mode != "insert" {
+		completionClose()
+	}
 	// TODO maybe not the best place to clear this
 	message("")
 }
@@ -236,23 +344,31 @@ func enterInsertModeEol(vt *ViewTree, b *Buffer, kl *KeyList) {
 }
 func enterInsertModeNl(vt *ViewTree, b *Buffer, kl *KeyList) {
 	moveLineEnd(vt, b, kl)
-	b.Insert([]rune("\n"))
-	b.Move(0, 1) // ensure a valid position
+	indent := []rune(nextLineIndent(b, b.Data[b.Cursor.Line]))
+	b.Insert(append([]rune("\n"), indent...))
+	b.MoveTo(len(indent), b.Cursor.Line+1)
 	enterMode("insert")
 }
 func enterInsertModeNlUp(vt *ViewTree, b *Buffer, kl *KeyList) {
 	moveLineBeg(vt, b, kl)
-	b.Insert([]rune("\n"))
-	b.Move(0, 0) // ensure a valid position
+	indent := lineIndent(b.Data[b.Cursor.Line])
+	b.Insert(append(indent, '\n'))
+	b.MoveTo(len(indent), b.Cursor.Line)
 	enterMode("insert")
 }
 
 func insertEnter(vt *ViewTree, b *Buffer, kl *KeyList) {
-	i := 0
-	for ; i < len(b.Data[b.Cursor.Line]) && isSpace(b.Data[b.Cursor.Line][i]); i++ {
+	line := b.Data[b.Cursor.Line]
+	indent := nextLineIndent(b, line[:b.Cursor.Char])
+	text := "\n" + indent
+	// Between brackets, the closing one goes on a line of its own
+	if lang := bufferLanguage(b); lang != nil && b.Cursor.Char < len(line) &&
+		strings.ContainsRune(lang.DedentOn, line[b.Cursor.Char]) && b.Cursor.Char > 0 &&
+		strings.ContainsRune(lang.IndentAfter, line[b.Cursor.Char-1]) {
+		text += "\n" + string(lineIndent(line))
 	}
-	b.Insert([]rune("\n" + strings.Repeat(" ", i)))
-	b.MoveTo(i, b.Cursor.Line+1)
+	b.Insert([]rune(text))
+	b.MoveTo(len([]rune(indent)), b.Cursor.Line+1)
 }
 func insertBackspace(vt *ViewTree, b *Buffer, kl *KeyList) {
 	if b.Cursor.Char == 0 {
@@ -290,6 +406,7 @@ func insert(vt *ViewTree, b *Buffer, kl *KeyList) {
 			b.Move(1, 0)
 		}
 	} else if k.Key == tcell.KeyRune && k.Mod == 0 {
+		dedentClosing(b, k.Chr)
 		b.Insert([]rune{k.Chr})
 		moveRight(vt, b, kl)
 	} else {
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKeysWaitForLongerBinding(t *testing.T) {
+	h := newHeadless(80, 24, []string{tempFile(t, "one\ntwo\nthree\n")})
+	h.Keys("j j g")
+	expectCursor(t, h, 2, 0)
+	if keysEntered.String() != "g" {
+		t.Fatalf("expected g to wait, keys entered are %q", keysEntered)
+	}
+	h.Keys("g")
+	expectCursor(t, h, 0, 0)
+
+	// The shorter binding runs once the timeout passes
+	h.Keys(": n n o r e m a p SPC d SPC x RET d")
+	expectContents(t, h, "one\ntwo\nthree\n")
+	h.Timeout()
+	expectContents(t, h, "ne\ntwo\nthree\n")
+	h.Keys("d d")
+	expectContents(t, h, "two\nthree\n")
+}
+
+func TestKeysDropUnmatched(t *testing.T) {
+	h := newHeadless(80, 24, []string{tempFile(t, "one\n")})
+	h.Keys("z x")
+	expectContents(t, h, "ne\n")
+	if len(keysEntered.keys) != 0 {
+		t.Fatalf("expected no keys left, got %q", keysEntered)
+	}
+}
+
+func TestKeysSpecificity(t *testing.T) {
+	h := newHeadless(80, 24, nil)
+	h.Keys(": i m a p SPC j SPC k SPC = > SPC E S C RET")
+	h.Keys("i a j k")
+	expectContents(t, h, "a\n")
+	if h.Mode() != "normal" {
+		t.Fatalf("expected normal mode, got %s", h.Mode())
+	}
+	// j runs the $any binding once x shows it isn't the start of "j k"
+	h.Keys("A j x ESC")
+	expectContents(t, h, "ajx\n")
+}
+
+func TestKeysTimeout(t *testing.T) {
+	h := newHeadless(80, 24, []string{tempFile(t, "one\n")})
+	configSet("timeout", float64(10))
+	h.Keys(": n n o r e m a p SPC d SPC x RET d")
+	deadline := time.Now().Add(time.Second)
+	for h.Contents() != "ne\n" && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+		h.Process()
+	}
+	expectContents(t, h, "ne\n")
+}
+
+func TestWhichKeyPopup(t *testing.T) {
+	h := newHeadless(80, 24, nil)
+	configSet("which_key_delay", float64(0))
+	h.Keys("g")
+	if line := h.Line(21); !strings.Contains(line, "g → Go to top") {
+		t.Fatalf("expected the popup to list g g, got %q", line)
+	}
+	h.Keys("SPC")
+	found := false
+	for y := 0; y < 23; y++ {
+		if strings.Contains(h.Line(y), "b → Buffers") {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatal("expected the popup to list leader bindings")
+	}
+	h.Keys("C-g")
+	if strings.Contains(h.Line(21), "→") {
+		t.Fatal("expected the popup to close")
+	}
+
+	items := whichKeyItems([]string{"normal"}, k(""))
+	for _, item := range items {
+		if item.key == "z" && item.desc != "+1 binding" {
+			t.Fatalf("expected z to start a group, got %q", item.desc)
+		}
+	}
+}
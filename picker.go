@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// A picker lists items, fuzzy filtered by what is typed at its prompt,
+// and opens the selected one. Items can keep coming in while it's open.
+
+type Picker struct {
+	Title   string
+	Items   []string
+	Loading bool
+	// Opens item, in a new window when split is "split" or "vsplit"
+	Open func(item, split string)
+	// Lines previewing item, nil for none
+	Preview func(item string) []string
+	// Runs when the picker goes away, opened or cancelled
+	Close func()
+
+	matches  []fuzzyMatch
+	selected int
+	query    string
+	count    int // items matched so far
+
+	previewItem  string
+	previewLines []string
+}
+
+var editorPicker *Picker
+
+func initPicker() {
+	editorPicker = nil
+
+	addMode("picker")
+	bind("picker", k("C-c"), pickerCancel)
+	bind("picker", k("C-g"), pickerCancel)
+	bind("picker", k("ESC"), pickerCancel)
+	bind("picker", k("RET"), func(vt *ViewTree, b *Buffer, kl *KeyList) {
+		pickerOpen("")
+	})
+	bind("picker", k("C-v"), func(vt *ViewTree, b *Buffer, kl *KeyList) {
+		pickerOpen("vsplit")
+	})
+	bind("picker", k("C-s"), func(vt *ViewTree, b *Buffer, kl *KeyList) {
+		pickerOpen("split")
+	})
+	for _, key := range []string{"UP", "C-p", "C-k"} {
+		bind("picker", k(key), func(vt *ViewTree, b *Buffer, kl *KeyList) {
+			pickerMove(-1)
+		})
+	}
+	for _, key := range []string{"DOWN", "C-n", "C-j", "TAB"} {
+		bind("picker", k(key), func(vt *ViewTree, b *Buffer, kl *KeyList) {
+			pickerMove(1)
+		})
+	}
+	bind("picker", k("BAK"), promptBackspace)
+	bind("picker", k("DEL"), promptDelete)
+	bind("picker", k("LEFT"), promptLeft)
+	bind("picker", k("RIGHT"), promptRight)
+	bind("picker", k("C-a"), promptLineBeg)
+	bind("picker", k("C-e"), promptLineEnd)
+	bind("picker", k("C-w"), promptDeleteWord)
+	bind("picker", k("C-u"), promptDeleteLineBeg)
+	bind("picker", k("$any"), promptInsert)
+
+	bindDesc("normal", k("$leader p"), "Find file in project", func(vt *ViewTree, b *Buffer, kl *KeyList) {
+		findFile("")
+	})
+	addCommandDesc("find", "Finds a file in the project, fuzzy matching its path", func(args []string) {
+		findFile(strings.Join(args[1:], " "))
+	})
+}
+
+// Shows p, its prompt starting with query
+func openPicker(p *Picker, query string) {
+	editorPicker = p
+	editorPrompt = p.Title
+	promptSetValue(query)
+	p.refresh()
+	enterMode("picker")
+}
+
+func closePicker() {
+	p := editorPicker
+	editorPicker = nil
+	enterMode("normal")
+	if p != nil && p.Close != nil {
+		p.Close()
+	}
+}
+
+func pickerCancel(vt *ViewTree, b *Buffer, kl *KeyList) {
+	closePicker()
+}
+
+func pickerOpen(split string) {
+	p := editorPicker
+	p.refresh()
+	if len(p.matches) == 0 {
+		return
+	}
+	item := p.matches[p.selected].str
+	closePicker()
+	p.Open(item, split)
+}
+
+func pickerMove(n int) {
+	p := editorPicker
+	p.refresh()
+	if len(p.matches) > 0 {
+		p.selected = ((p.selected+n)%len(p.matches) + len(p.matches)) % len(p.matches)
+	}
+}
+
+// Adds items, as a background job finds them
+func (p *Picker) Add(items []string) {
+	p.Items = append(p.Items, items...)
+}
+
+// Matches items again when the query changed or items came in
+func (p *Picker) refresh() {
+	if p.query == editorPromptValue && p.count == len(p.Items) {
+		return
+	}
+	if p.query != editorPromptValue {
+		p.selected = 0
+	}
+	p.query = editorPromptValue
+	p.count = len(p.Items)
+	p.matches = fuzzyFilter(p.query, p.Items)
+	if p.selected >= len(p.matches) {
+		p.selected = max(len(p.matches)-1, 0)
+	}
+}
+
+func (p *Picker) Selected() string {
+	if len(p.matches) == 0 {
+		return ""
+	}
+	return p.matches[p.selected].str
+}
+
+// Draws the matches in a popup above the prompt, with a preview of the
+// selected item at their right when there's room
+func renderPicker(width, height int) {
+	p := editorPicker
+	p.refresh()
+
+	h := max(height/2, 3)
+	y := height - 1 - h
+	listWidth := width
+	if p.Preview != nil && width >= 80 {
+		listWidth = width * 2 / 5
+	}
+
+	title := strconv.Itoa(len(p.matches)) + "/" + strconv.Itoa(len(p.Items))
+	if p.Loading {
+		title += " …"
+	}
+	lines := []string{}
+	marks := [][]int{}
+	for _, m := range p.matches {
+		lines = append(lines, m.str)
+		marks = append(marks, m.positions)
+		// Only what can be seen with the selection
+		if len(lines) >= max(p.selected+1, h-2) {
+			break
+		}
+	}
+	renderPopup(&Popup{Title: title, Lines: lines, Marks: marks, Selected: p.selected}, 0, y, listWidth, h)
+
+	if listWidth < width {
+		item := p.Selected()
+		if item != p.previewItem {
+			p.previewItem = item
+			p.previewLines = nil
+			if item != "" {
+				p.previewLines = p.Preview(item)
+			}
+		}
+		renderPopup(&Popup{Title: item, Lines: p.previewLines, Selected: -1}, listWidth, y, width-listWidth, h)
+	}
+}
+
+// Reads the first lines of a file for previews, leaving binary files out
+func previewFile(path string, maxLines int) []string {
+	f, err := os.Open(path)
+	if err != nil {
+		return []string{err.Error()}
+	}
+	defer f.Close()
+	data := make([]byte, 64*1024)
+	n, err := io.ReadFull(f, data)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return []string{err.Error()}
+	}
+	data = data[:n]
+	if bytes.IndexByte(data, 0) >= 0 {
+		return []string{"(binary file)"}
+	}
+	lines := strings.Split(string(data), "\n")
+	if len(lines) > maxLines {
+		lines = lines[:maxLines]
+	}
+	for i, line := range lines {
+		lines[i] = strings.Replace(line, "\t", "    ", -1)
+	}
+	return lines
+}
+
+// Opens a picker over the project's files, walked in the background
+func findFile(query string) {
+	root := currentProjectRoot()
+	done := make(chan struct{})
+	p := &Picker{Title: "find: ", Loading: true}
+	p.Open = func(item, split string) {
+		if split != "" {
+			splitWindow(split == "vsplit")
+		}
+		showFile(filepath.Join(root, item))
+	}
+	p.Preview = func(item string) []string {
+		return previewFile(filepath.Join(root, item), editorHeight)
+	}
+	p.Close = func() {
+		close(done)
+	}
+
+	go func() {
+		batch := []string{}
+		flush := func() {
+			items := batch
+			batch = []string{}
+			runInMainLoop(func() {
+				p.Add(items)
+			})
+		}
+		walkProject(root, done, func(path string) bool {
+			rel, err := filepath.Rel(root, path)
+			if err != nil {
+				rel = path
+			}
+			batch = append(batch, rel)
+			if len(batch) >= 512 {
+				flush()
+			}
+			return true
+		})
+		flush()
+		runInMainLoop(func() {
+			p.Loading = false
+		})
+	}()
+
+	openPicker(p, query)
+}
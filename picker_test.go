@@ -0,0 +1,159 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestFuzzyFilter(t *testing.T) {
+	candidates := []string{"render.go", "remote_test.go", "README.md", "modes/ruby.go", "ry.go"}
+	matches := fuzzyFilter("rgo", candidates)
+	got := []string{}
+	for _, m := range matches {
+		got = append(got, m.str)
+	}
+	if len(got) != 4 || got[0] != "ry.go" {
+		t.Fatalf("unexpected ranking %v", got)
+	}
+	if _, ok := fuzzyMatchString("xyz", "ry.go"); ok {
+		t.Fatal("expected no match")
+	}
+	m, _ := fuzzyMatchString("RM", "README.md")
+	if m.positions[0] != 0 || m.positions[1] != 4 {
+		t.Fatalf("unexpected positions %v", m.positions)
+	}
+}
+
+func writeProject(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "ry-project")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, contents := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestWalkProject(t *testing.T) {
+	dir := writeProject(t, map[string]string{
+		".git/HEAD":      "ref: refs/heads/main\n",
+		".gitignore":     "*.log\nbuild/\n/top.txt\n",
+		"main.go":        "package main\n",
+		"debug.log":      "",
+		"top.txt":        "",
+		"build/out":      "",
+		"sub/top.txt":    "",
+		"sub/.gitignore": "!keep.log\n",
+		"sub/keep.log":   "",
+		"sub/deep/a.log": "",
+		"sub/deep/b.go":  "",
+	})
+	defer os.RemoveAll(dir)
+
+	if root := projectRoot(filepath.Join(dir, "sub", "deep")); root != dir {
+		t.Fatalf("expected root %s, got %s", dir, root)
+	}
+	got := []string{}
+	walkProject(dir, nil, func(path string) bool {
+		rel, _ := filepath.Rel(dir, path)
+		got = append(got, filepath.ToSlash(rel))
+		return true
+	})
+	sort.Strings(got)
+	expected := []string{".gitignore", "main.go", "sub/.gitignore", "sub/deep/b.go", "sub/keep.log", "sub/top.txt"}
+	if len(got) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, got)
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Fatalf("expected %v, got %v", expected, got)
+		}
+	}
+}
+
+func waitPicker(t *testing.T, h *headless) {
+	deadline := time.Now().Add(5 * time.Second)
+	for editorPicker != nil && editorPicker.Loading {
+		if time.Now().After(deadline) {
+			t.Fatal("picker still loading")
+		}
+		time.Sleep(5 * time.Millisecond)
+		h.Process()
+	}
+}
+
+func TestFindFile(t *testing.T) {
+	dir := writeProject(t, map[string]string{
+		"go.mod":             "module example\n",
+		"main.go":            "package main\n",
+		"cmd/server/main.go": "package server\n",
+		"docs/notes.txt":     "notes\n",
+	})
+	defer os.RemoveAll(dir)
+
+	h := newHeadless(100, 24, []string{filepath.Join(dir, "main.go")})
+	h.Keys("SPC p")
+	if h.Mode() != "picker+go" {
+		t.Fatalf("expected picker mode, got %s", h.Mode())
+	}
+	waitPicker(t, h)
+	h.Keys("n o t")
+	if editorPicker.Selected() != filepath.Join("docs", "notes.txt") {
+		t.Fatalf("unexpected selection %q", editorPicker.Selected())
+	}
+	h.Keys("RET")
+	if h.Mode() != "normal" || h.Buffer().Path != filepath.Join(dir, "docs", "notes.txt") {
+		t.Fatalf("expected notes.txt opened, got %s in %s", h.Buffer().Path, h.Mode())
+	}
+
+	h.Keys(": f i n d SPC s e r v RET")
+	waitPicker(t, h)
+	h.Keys("C-v")
+	if len(rootViewTree.Leaves()) != 2 || rootViewTree.Left == nil {
+		t.Fatal("expected a vertical split")
+	}
+	if h.Buffer().Path != filepath.Join(dir, "cmd", "server", "main.go") {
+		t.Fatalf("unexpected buffer %s", h.Buffer().Path)
+	}
+
+	h.Keys("SPC p ESC")
+	if h.Mode() != "normal+go" || editorPicker != nil {
+		t.Fatalf("expected the picker closed, got %s", h.Mode())
+	}
+}
+
+func TestWindows(t *testing.T) {
+	h := newHeadless(80, 24, nil)
+	first := h.Buffer()
+	h.Keys("C-w s")
+	if len(rootViewTree.Leaves()) != 2 || rootViewTree.Top == nil {
+		t.Fatal("expected a horizontal split")
+	}
+	h.Keys("C-w v")
+	if len(rootViewTree.Leaves()) != 3 {
+		t.Fatal("expected three windows")
+	}
+	h.Keys("C-w k")
+	if currentViewTree != rootViewTree.Top {
+		t.Fatal("expected the top window focused")
+	}
+	h.Keys("C-w o")
+	if len(rootViewTree.Leaves()) != 1 || h.Buffer() != first {
+		t.Fatal("expected a single window")
+	}
+	h.Keys(": v s RET C-w c")
+	if len(rootViewTree.Leaves()) != 1 {
+		t.Fatal("expected the split closed")
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"github.com/gdamore/tcell"
+)
+
+// A box drawn over the views, for menus and hints
+type Popup struct {
+	Title    string
+	Lines    []string
+	Selected int     // index of the line shown selected, -1 for none
+	Marks    [][]int // rune indexes to emphasize in each line, if any
+}
+
+// Draws p in the rectangle at x,y of size w,h, border included, clipping
+// lines that don't fit
+func renderPopup(p *Popup, x, y, w, h int) {
+	if w < 3 || h < 3 {
+		return
+	}
+	s := style("popup")
+	sb := style("popup.border")
+
+	for sy := y; sy < y+h; sy++ {
+		for sx := x; sx < x+w; sx++ {
+			screen.SetContent(sx, sy, ' ', nil, s)
+		}
+	}
+	for sx := x + 1; sx < x+w-1; sx++ {
+		screen.SetContent(sx, y, tcell.RuneHLine, nil, sb)
+		screen.SetContent(sx, y+h-1, tcell.RuneHLine, nil, sb)
+	}
+	for sy := y + 1; sy < y+h-1; sy++ {
+		screen.SetContent(x, sy, tcell.RuneVLine, nil, sb)
+		screen.SetContent(x+w-1, sy, tcell.RuneVLine, nil, sb)
+	}
+	screen.SetContent(x, y, tcell.RuneULCorner, nil, sb)
+	screen.SetContent(x+w-1, y, tcell.RuneURCorner, nil, sb)
+	screen.SetContent(x, y+h-1, tcell.RuneLLCorner, nil, sb)
+	screen.SetContent(x+w-1, y+h-1, tcell.RuneLRCorner, nil, sb)
+	if p.Title != "" {
+		write(sb, x+2, y, truncate(" "+p.Title+" ", w-4))
+	}
+
+	// Scroll so the selected line is visible
+	offset := 0
+	if p.Selected >= h-2 {
+		offset = p.Selected - (h - 3)
+	}
+	for i := offset; i < len(p.Lines) && i-offset < h-2; i++ {
+		ls := s
+		if i == p.Selected {
+			ls = style("popup.selected")
+			for sx := x + 1; sx < x+w-1; sx++ {
+				screen.SetContent(sx, y+1+i-offset, ' ', nil, ls)
+			}
+		}
+		write(ls, x+2, y+1+i-offset, truncate(p.Lines[i], w-4))
+		if i < len(p.Marks) {
+			line := []rune(p.Lines[i])
+			for _, m := range p.Marks[i] {
+				if m < len(line) && m < w-4 {
+					screen.SetContent(x+2+m, y+1+i-offset, line[m], nil, ls.Bold(true).Underline(true))
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Finds the project dir is part of: the closest parent with a .git or
+// go.mod, or dir itself when there is none
+func projectRoot(dir string) string {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return dir
+	}
+	for d := dir; ; d = filepath.Dir(d) {
+		for _, marker := range []string{".git", "go.mod"} {
+			if _, err := os.Stat(filepath.Join(d, marker)); err == nil {
+				return d
+			}
+		}
+		if filepath.Dir(d) == d {
+			return dir
+		}
+	}
+}
+
+// Project root for the current buffer, or the working directory
+func currentProjectRoot() string {
+	if currentViewTree != nil && currentViewTree.Leaf.Buf.Path != "" {
+		return projectRoot(filepath.Dir(currentViewTree.Leaf.Buf.Path))
+	}
+	wd, _ := os.Getwd()
+	return projectRoot(wd)
+}
+
+// A .gitignore pattern, with the directory of the file it came from
+type ignorePattern struct {
+	dir      string
+	pattern  string
+	negate   bool
+	dirOnly  bool
+	anchored bool
+}
+
+type gitIgnore struct {
+	patterns []ignorePattern
+}
+
+// Adds the patterns of dir/.gitignore, if there is one
+func (g *gitIgnore) Load(dir string) {
+	f, err := os.Open(filepath.Join(dir, ".gitignore"))
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		p := ignorePattern{dir: dir}
+		if strings.HasPrefix(line, "!") {
+			p.negate = true
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			p.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		// Patterns with a slash (other than at the end) are relative to
+		// the .gitignore's directory, others match at any depth
+		if strings.Contains(line, "/") {
+			p.anchored = true
+			line = strings.TrimPrefix(line, "/")
+		}
+		p.pattern = line
+		g.patterns = append(g.patterns, p)
+	}
+}
+
+// Tells if path should be ignored, the last matching pattern winning
+func (g *gitIgnore) Ignored(path string, isDir bool) bool {
+	ignored := false
+	for _, p := range g.patterns {
+		if p.dirOnly && !isDir {
+			continue
+		}
+		rel, err := filepath.Rel(p.dir, path)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			continue
+		}
+		rel = filepath.ToSlash(rel)
+		var matched bool
+		if p.anchored {
+			matched = globMatch(p.pattern, rel)
+		} else {
+			matched = globMatch(p.pattern, filepath.Base(path))
+		}
+		if matched {
+			ignored = !p.negate
+		}
+	}
+	return ignored
+}
+
+// Matches name against a glob pattern where ** matches any number of
+// path segments
+func globMatch(pattern, name string) bool {
+	if !strings.Contains(pattern, "**") {
+		matched, _ := filepath.Match(pattern, name)
+		return matched
+	}
+	parts := strings.SplitN(pattern, "**", 2)
+	prefix, suffix := parts[0], strings.TrimPrefix(parts[1], "/")
+	if !strings.HasPrefix(name, prefix) {
+		return false
+	}
+	rest := name[len(prefix):]
+	for {
+		if globMatch(suffix, rest) {
+			return true
+		}
+		i := strings.Index(rest, "/")
+		if i < 0 {
+			return false
+		}
+		rest = rest[i+1:]
+	}
+}
+
+// Walks the files under root, skipping .git and what .gitignore files
+// ignore, calling fn with each file's path. Stops early when done is
+// closed or fn returns false. A root that's a file is its only path.
+func walkProject(root string, done <-chan struct{}, fn func(path string) bool) {
+	if info, err := os.Stat(root); err == nil && info.Mode().IsRegular() {
+		fn(root)
+		return
+	}
+	ignore := &gitIgnore{}
+	var walk func(dir string) bool
+	walk = func(dir string) bool {
+		select {
+		case <-done:
+			return false
+		default:
+		}
+		saved := len(ignore.patterns)
+		ignore.Load(dir)
+		defer func() { ignore.patterns = ignore.patterns[:saved] }()
+
+		f, err := os.Open(dir)
+		if err != nil {
+			return true
+		}
+		infos, err := f.Readdir(-1)
+		f.Close()
+		if err != nil {
+			return true
+		}
+		for _, info := range infos {
+			path := filepath.Join(dir, info.Name())
+			isDir := info.IsDir()
+			if info.Name() == ".git" || ignore.Ignored(path, isDir) {
+				continue
+			}
+			if isDir {
+				if !walk(path) {
+					return false
+				}
+			} else if info.Mode().IsRegular() && !fn(path) {
+				return false
+			}
+		}
+		return true
+	}
+	walk(root)
+}
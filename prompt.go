@@ -1,6 +1,10 @@
 package main
 
 import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/gdamore/tcell"
@@ -10,15 +14,65 @@ var (
 	editorIsPromptActive                           = false
 	editorPrompt                                   = ""
 	editorPromptValue                              = ""
-	editorPromptCallbackFn   func([]string)        = nil
+	editorPromptCursor                             = 0 // in runes
+	editorPromptCallbackFn   func(string)          = nil
 	editorPromptCompletionFn func(string) []string = nil
+
+	// Completions for the word before the cursor, cycled through with TAB
+	editorPromptCompletions     []completionItem
+	editorPromptCompletionIndex = -1
+	editorPromptCompletionStart = 0
+
+	// Values entered, per prompt text, oldest first
+	promptHistory      = map[string][]string{}
+	promptHistoryIndex = 0
+	promptHistoryDraft = ""
 )
 
-func prompt(prompt string, compFn func(string) []string, cbFn func([]string)) {
+const promptHistorySize = 100
+
+func initPrompt() {
+	promptHistory = map[string][]string{}
+
+	addMode("prompt")
+	bind("prompt", k("C-c"), promptCancel)
+	bind("prompt", k("C-g"), promptCancel)
+	bind("prompt", k("ESC"), promptCancel)
+	bind("prompt", k("RET"), promptFinish)
+	bind("prompt", k("BAK"), promptBackspace)
+	bind("prompt", k("DEL"), promptDelete)
+	bind("prompt", k("C-d"), promptDelete)
+	bind("prompt", k("LEFT"), promptLeft)
+	bind("prompt", k("C-b"), promptLeft)
+	bind("prompt", k("RIGHT"), promptRight)
+	bind("prompt", k("C-f"), promptRight)
+	bind("prompt", k("A-b"), promptWordLeft)
+	bind("prompt", k("A-f"), promptWordRight)
+	bind("prompt", k("HOME"), promptLineBeg)
+	bind("prompt", k("C-a"), promptLineBeg)
+	bind("prompt", k("END"), promptLineEnd)
+	bind("prompt", k("C-e"), promptLineEnd)
+	bind("prompt", k("C-w"), promptDeleteWord)
+	bind("prompt", k("C-u"), promptDeleteLineBeg)
+	bind("prompt", k("UP"), promptHistoryPrev)
+	bind("prompt", k("C-p"), promptHistoryPrev)
+	bind("prompt", k("DOWN"), promptHistoryNext)
+	bind("prompt", k("C-n"), promptHistoryNext)
+	bind("prompt", k("TAB"), promptComplete)
+	bind("prompt", k("BTAB"), promptCompletePrev)
+	bind("prompt", k("$any"), promptInsert)
+}
+
+// Asks for a value, passed as typed to cbFn once entered
+func prompt(prompt string, compFn func(string) []string, cbFn func(string)) {
 	editorPrompt = prompt
 	editorPromptValue = ""
+	editorPromptCursor = 0
 	editorPromptCallbackFn = cbFn
 	editorPromptCompletionFn = compFn
+	promptHistoryIndex = len(promptHistory[prompt])
+	promptHistoryDraft = ""
+	promptUpdateCompletion()
 	enterMode("prompt")
 }
 
@@ -26,23 +80,52 @@ func noopComplete(prefix string) []string {
 	return []string{}
 }
 
+// Sets the prompt's value, moving the cursor to its end
+func promptSetValue(value string) {
+	editorPromptValue = value
+	editorPromptCursor = len([]rune(value))
+	promptUpdateCompletion()
+}
+
+// Edits made to the value end the completion in progress
 func promptUpdateCompletion() {
-	// TODO
+	if editorPromptCompletions != nil {
+		cancelCompletions()
+	}
+	editorPromptCompletions = nil
+	editorPromptCompletionIndex = -1
 }
 
 func promptCancel(vt *ViewTree, b *Buffer, kl *KeyList) {
+	promptUpdateCompletion()
 	enterMode("normal")
 }
 
 func promptFinish(vt *ViewTree, b *Buffer, kl *KeyList) {
+	promptUpdateCompletion()
+	promptHistoryAdd(editorPrompt, editorPromptValue)
 	enterMode("normal")
-	// TODO better args parsing
-	editorPromptCallbackFn(strings.Split(editorPromptValue, " "))
+	editorPromptCallbackFn(editorPromptValue)
+}
+
+// Replaces the runes between from and to with text, moving the cursor
+// after text
+func promptReplace(from, to int, text string) {
+	value := []rune(editorPromptValue)
+	editorPromptValue = string(value[:from]) + text + string(value[to:])
+	editorPromptCursor = from + len([]rune(text))
 }
 
 func promptBackspace(vt *ViewTree, b *Buffer, kl *KeyList) {
-	if len(editorPromptValue) > 0 {
-		editorPromptValue = editorPromptValue[:len(editorPromptValue)-1]
+	if editorPromptCursor > 0 {
+		promptReplace(editorPromptCursor-1, editorPromptCursor, "")
+		promptUpdateCompletion()
+	}
+}
+
+func promptDelete(vt *ViewTree, b *Buffer, kl *KeyList) {
+	if editorPromptCursor < len([]rune(editorPromptValue)) {
+		promptReplace(editorPromptCursor, editorPromptCursor+1, "")
 		promptUpdateCompletion()
 	}
 }
@@ -50,14 +133,310 @@ func promptBackspace(vt *ViewTree, b *Buffer, kl *KeyList) {
 func promptInsert(vt *ViewTree, b *Buffer, kl *KeyList) {
 	k := kl.keys[len(kl.keys)-1]
 	if k.Key == tcell.KeyRune && k.Mod == 0 {
-		editorPromptValue += string(k.Chr)
+		promptReplace(editorPromptCursor, editorPromptCursor, string(k.Chr))
 		promptUpdateCompletion()
 	}
 }
 
+func promptLeft(vt *ViewTree, b *Buffer, kl *KeyList) {
+	editorPromptCursor = max(editorPromptCursor-1, 0)
+}
+
+func promptRight(vt *ViewTree, b *Buffer, kl *KeyList) {
+	editorPromptCursor = min(editorPromptCursor+1, len([]rune(editorPromptValue)))
+}
+
+func promptLineBeg(vt *ViewTree, b *Buffer, kl *KeyList) {
+	editorPromptCursor = 0
+}
+
+func promptLineEnd(vt *ViewTree, b *Buffer, kl *KeyList) {
+	editorPromptCursor = len([]rune(editorPromptValue))
+}
+
+// Position of the start of the word before the cursor
+func promptWordStart() int {
+	value := []rune(editorPromptValue)
+	i := editorPromptCursor
+	for i > 0 && !isWord(value[i-1]) {
+		i--
+	}
+	for i > 0 && isWord(value[i-1]) {
+		i--
+	}
+	return i
+}
+
+func promptWordLeft(vt *ViewTree, b *Buffer, kl *KeyList) {
+	editorPromptCursor = promptWordStart()
+}
+
+func promptWordRight(vt *ViewTree, b *Buffer, kl *KeyList) {
+	value := []rune(editorPromptValue)
+	i := editorPromptCursor
+	for i < len(value) && !isWord(value[i]) {
+		i++
+	}
+	for i < len(value) && isWord(value[i]) {
+		i++
+	}
+	editorPromptCursor = i
+}
+
+func promptDeleteWord(vt *ViewTree, b *Buffer, kl *KeyList) {
+	promptReplace(promptWordStart(), editorPromptCursor, "")
+	promptUpdateCompletion()
+}
+
+func promptDeleteLineBeg(vt *ViewTree, b *Buffer, kl *KeyList) {
+	promptReplace(0, editorPromptCursor, "")
+	promptUpdateCompletion()
+}
+
+func promptHistoryAdd(prompt, value string) {
+	if value == "" {
+		return
+	}
+	history := promptHistory[prompt]
+	// Only keep the latest of duplicates
+	for i, entry := range history {
+		if entry == value {
+			history = append(history[:i], history[i+1:]...)
+			break
+		}
+	}
+	history = append(history, value)
+	if len(history) > promptHistorySize {
+		history = history[len(history)-promptHistorySize:]
+	}
+	promptHistory[prompt] = history
+}
+
+func promptHistoryPrev(vt *ViewTree, b *Buffer, kl *KeyList) {
+	history := promptHistory[editorPrompt]
+	if promptHistoryIndex == 0 {
+		return
+	}
+	if promptHistoryIndex == len(history) {
+		promptHistoryDraft = editorPromptValue
+	}
+	promptHistoryIndex--
+	promptSetValue(history[promptHistoryIndex])
+}
+
+func promptHistoryNext(vt *ViewTree, b *Buffer, kl *KeyList) {
+	history := promptHistory[editorPrompt]
+	if promptHistoryIndex >= len(history) {
+		return
+	}
+	promptHistoryIndex++
+	if promptHistoryIndex == len(history) {
+		promptSetValue(promptHistoryDraft)
+	} else {
+		promptSetValue(history[promptHistoryIndex])
+	}
+}
+
+// Completes the word before the cursor: the first TAB lists completions
+// and picks the first one, the next ones cycle through them
+func promptComplete(vt *ViewTree, b *Buffer, kl *KeyList) {
+	promptCycleCompletion(1)
+}
+
+func promptCompletePrev(vt *ViewTree, b *Buffer, kl *KeyList) {
+	promptCycleCompletion(-1)
+}
+
+func promptCycleCompletion(step int) {
+	if editorPromptCompletions != nil {
+		n := len(editorPromptCompletions)
+		editorPromptCompletionIndex = ((editorPromptCompletionIndex+step)%n + n) % n
+		promptReplace(editorPromptCompletionStart, editorPromptCursor, editorPromptCompletions[editorPromptCompletionIndex].Text())
+		return
+	}
+
+	before := string([]rune(editorPromptValue)[:editorPromptCursor])
+	start := strings.LastIndex(before, " ") + 1
+	req := &completionRequest{Text: before, Prefix: before[start:]}
+	// The prompt's completion function as the only source
+	source := &completionSource{Name: "prompt", Complete: func(req *completionRequest, reply func([]completionItem)) {
+		items := []completionItem{}
+		for _, completion := range editorPromptCompletionFn(req.Text) {
+			items = append(items, completionItem{Label: completion})
+		}
+		reply(items)
+	}}
+	requestCompletions(req, []*completionSource{source}, func(items []completionItem, done bool) {
+		if editorPromptCompletions != nil {
+			editorPromptCompletions = items
+			editorPromptCompletionIndex = min(editorPromptCompletionIndex, len(items)-1)
+			return
+		}
+		if editorMode != "prompt" || string([]rune(editorPromptValue)[:editorPromptCursor]) != before {
+			return
+		}
+		if len(items) == 0 {
+			if done {
+				message("No completions")
+			}
+			return
+		}
+		editorPromptCompletionStart = len([]rune(before[:start]))
+		if len(items) == 1 && done {
+			promptReplace(editorPromptCompletionStart, editorPromptCursor, items[0].Text())
+			return
+		}
+		editorPromptCompletions = items
+		editorPromptCompletionIndex = -1
+		promptCycleCompletion(step)
+	})
+}
+
+// Keeps the completions among candidates starting with prefix, sorted
+// and without duplicates
+func completeFrom(prefix string, candidates []string) []string {
+	completions := []string{}
+	seen := map[string]bool{}
+	for _, candidate := range candidates {
+		if strings.HasPrefix(candidate, prefix) && !seen[candidate] {
+			seen[candidate] = true
+			completions = append(completions, candidate)
+		}
+	}
+	sort.Strings(completions)
+	return completions
+}
+
+// Completes paths, directories ending with a slash
+func completePath(prefix string) []string {
+	dir, base := filepath.Split(expandHome(prefix))
+	listDir := dir
+	if listDir == "" {
+		listDir = "."
+	}
+	f, err := os.Open(listDir)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	infos, err := f.Readdir(-1)
+	if err != nil {
+		return nil
+	}
+	// Keep the prefix as typed (e.g. with ~) in front of the completions
+	typedDir, _ := filepath.Split(prefix)
+	candidates := []string{}
+	for _, info := range infos {
+		if !strings.HasPrefix(info.Name(), base) {
+			continue
+		}
+		if strings.HasPrefix(info.Name(), ".") && !strings.HasPrefix(base, ".") {
+			continue
+		}
+		name := typedDir + info.Name()
+		if info.IsDir() {
+			name += string(filepath.Separator)
+		}
+		candidates = append(candidates, name)
+	}
+	sort.Strings(candidates)
+	return candidates
+}
+
+func completeBufferName(prefix string) []string {
+	names := []string{}
+	for _, b := range buffers {
+		names = append(names, b.Name)
+	}
+	return completeFrom(prefix, names)
+}
+
+func completeOptionName(prefix string) []string {
+	names := []string{}
+	for name, o := range options {
+		names = append(names, name)
+		if _, isBool := o.Default.(bool); isBool {
+			names = append(names, "no"+name)
+		}
+	}
+	return completeFrom(prefix, names)
+}
+
+func completeCommandName(prefix string) []string {
+	names := []string{}
+	for name := range commands {
+		names = append(names, name)
+	}
+	for alias := range commandAliases {
+		names = append(names, alias)
+	}
+	return completeFrom(prefix, names)
+}
+
+// Completes the command name, then the command's arguments with the
+// completion function added for it
+func completeCommand(before string) []string {
+	words := strings.Split(before, " ")
+	if len(words) == 1 {
+		return completeCommandName(words[0])
+	}
+	name := words[0]
+	if full, ok := commandAliases[name]; ok {
+		name = full
+	}
+	if complete, ok := commandCompletions[name]; ok {
+		return complete(words[len(words)-1])
+	}
+	return nil
+}
+
+// Path of the file prompt history is kept in between sessions
+func promptHistoryPath() string {
+	return filepath.Join(dataDir(), "history")
+}
+
+// Loads prompt history saved by savePromptHistory, lines being the prompt
+// and the value separated by a tab
+func loadPromptHistory() {
+	f, err := os.Open(promptHistoryPath())
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), "\t", 2)
+		if len(parts) == 2 {
+			promptHistoryAdd(parts[0], parts[1])
+		}
+	}
+}
+
+func savePromptHistory() {
+	if err := os.MkdirAll(dataDir(), 0755); err != nil {
+		return
+	}
+	f, err := os.Create(promptHistoryPath())
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	prompts := []string{}
+	for prompt := range promptHistory {
+		prompts = append(prompts, prompt)
+	}
+	sort.Strings(prompts)
+	for _, prompt := range prompts {
+		for _, value := range promptHistory[prompt] {
+			if !strings.ContainsAny(prompt+value, "\t\n") {
+				w.WriteString(prompt + "\t" + value + "\n")
+			}
+		}
+	}
+	w.Flush()
+}
+
 func promptCommand(vt *ViewTree, b *Buffer, kl *KeyList) {
-	prompt(":", func(prefix string) []string {
-		// TODO provide command suggestions
-		return []string{}
-	}, runCommand)
+	prompt(":", completeCommand, runCommandLine)
 }
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPromptEditing(t *testing.T) {
+	h := newHeadless(80, 24, nil)
+	h.Keys(": s e t SPC t a b _ w i d t h = 2 LEFT LEFT LEFT BAK BAK")
+	if editorPromptValue != "set tab_wih=2" || editorPromptCursor != 10 {
+		t.Fatalf("unexpected prompt %q with cursor at %d", editorPromptValue, editorPromptCursor)
+	}
+	h.Keys("C-a DEL DEL DEL C-e C-w")
+	if editorPromptValue != " tab_wih=" {
+		t.Fatalf("unexpected prompt %q", editorPromptValue)
+	}
+	h.Keys("A-b C-u")
+	if editorPromptValue != "tab_wih=" || editorPromptCursor != 0 {
+		t.Fatalf("unexpected prompt %q with cursor at %d", editorPromptValue, editorPromptCursor)
+	}
+	h.Keys("ESC")
+	if h.Mode() != "normal" {
+		t.Fatalf("expected normal mode, got %s", h.Mode())
+	}
+}
+
+func TestPromptHistory(t *testing.T) {
+	h := newHeadless(80, 24, nil)
+	h.Keys(": s e t SPC t a b _ w i d t h ? RET")
+	h.Keys(": o p t i o n s RET")
+	h.Keys("/ f o o RET")
+	h.Keys(": x UP")
+	if editorPromptValue != "options" {
+		t.Fatalf("expected the last command, got %q", editorPromptValue)
+	}
+	h.Keys("UP UP")
+	if editorPromptValue != "set tab_width?" {
+		t.Fatalf("expected the first command, got %q", editorPromptValue)
+	}
+	h.Keys("DOWN DOWN")
+	if editorPromptValue != "x" {
+		t.Fatalf("expected what was typed back, got %q", editorPromptValue)
+	}
+	h.Keys("ESC / UP")
+	if editorPromptValue != "foo" {
+		t.Fatalf("expected search history, got %q", editorPromptValue)
+	}
+	h.Keys("ESC")
+
+	dir, _ := ioutil.TempDir("", "ry")
+	os.Setenv("XDG_DATA_HOME", dir)
+	defer os.Unsetenv("XDG_DATA_HOME")
+	savePromptHistory()
+	promptHistory = map[string][]string{}
+	loadPromptHistory()
+	if len(promptHistory[":"]) != 2 || promptHistory["/"][0] != "foo" {
+		t.Fatalf("expected history to be saved and loaded, got %v", promptHistory)
+	}
+}
+
+func TestPromptCompletion(t *testing.T) {
+	h := newHeadless(80, 24, nil)
+	h.Keys(": w r i t e q TAB")
+	if editorPromptValue != "writequit" {
+		t.Fatalf("expected the command to complete, got %q", editorPromptValue)
+	}
+	h.Keys("C-u s e t SPC t a b TAB")
+	if editorPromptValue != "set tab_to_spaces" {
+		t.Fatalf("expected the first option, got %q", editorPromptValue)
+	}
+	if h.Line(20) == "" {
+		t.Fatal("expected the completion menu")
+	}
+	h.Keys("TAB")
+	if editorPromptValue != "set tab_width" {
+		t.Fatalf("expected the next option, got %q", editorPromptValue)
+	}
+	h.Keys("TAB")
+	if editorPromptValue != "set tab_to_spaces" {
+		t.Fatalf("expected completions to cycle, got %q", editorPromptValue)
+	}
+
+	dir := filepath.Dir(tempFile(t, ""))
+	ioutil.WriteFile(filepath.Join(dir, "other.go"), nil, 0644)
+	promptSetValue("edit " + dir + "/o")
+	h.Keys("TAB")
+	if editorPromptValue != "edit "+filepath.Join(dir, "other.go") {
+		t.Fatalf("expected the path to complete, got %q", editorPromptValue)
+	}
+	h.Keys("ESC")
+}
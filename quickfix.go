@@ -0,0 +1,350 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// The quickfix list holds locations found in compiler or test output, in
+// a file or in grep results, gone through with :cnext and :cprev.
+//
+// Output lines are matched against error formats: regexps with the named
+// groups file and line, and optionally col, type (error, warning...) and
+// message. The first format matching a line wins, user ones first.
+
+type quickfixEntry struct {
+	Path string
+	Line int // 0 based, like Col
+	Col  int
+	Type string
+	Text string
+}
+
+var (
+	quickfixList  = []*quickfixEntry{}
+	quickfixIndex = -1
+	errorFormats  = []*regexp.Regexp{}
+)
+
+var defaultErrorFormats = []string{
+	// go test failures, indented under the test's name
+	`^\s+(?P<file>[^\s:]+_test\.go):(?P<line>\d+): (?P<message>.*)$`,
+	// Python tracebacks
+	`^\s*File "(?P<file>[^"]+)", line (?P<line>\d+)(?:, in (?P<message>.*))?$`,
+	// Go, gcc, clang and most others: file:line:col: type: message
+	`^(?P<file>[^\s:][^:]*):(?P<line>\d+):(?:(?P<col>\d+):)?\s*(?:(?P<type>fatal error|error|warning|note):\s*)?(?P<message>.*)$`,
+}
+
+func initQuickfix() {
+	quickfixList = []*quickfixEntry{}
+	quickfixIndex = -1
+	errorFormats = []*regexp.Regexp{}
+	for _, pattern := range defaultErrorFormats {
+		errorFormats = append(errorFormats, regexp.MustCompile(pattern))
+	}
+
+	addOption("makeprg", "go build ./...", "Command :make runs in the project root, followed by its arguments")
+
+	addMode("quickfix")
+	mustFindMode("quickfix").normalOnly = true
+	bindDesc("quickfix", k("RET"), "Go to location", func(vt *ViewTree, b *Buffer, kl *KeyList) {
+		quickfixGo(b.Cursor.Line)
+	})
+	bindDesc("quickfix", k("q"), "Close", func(vt *ViewTree, b *Buffer, kl *KeyList) {
+		closeCurrentBuffer(true)
+	})
+
+	// Arguments go to the shell as typed, quotes included
+	addRawCommand("make", "Runs the makeprg option's command, listing errors in the quickfix list", func(args []string) {
+		command := configGet("makeprg", currentViewTree.Leaf.Buf)
+		if args[1] != "" {
+			command += " " + args[1]
+		}
+		makeCommand(command)
+	})
+	addCommandDesc("cnext", "Goes to the next location of the quickfix list", func(args []string) {
+		quickfixMove(1)
+	})
+	addAlias("cn", "cnext")
+	addCommandDesc("cprev", "Goes to the previous location of the quickfix list", func(args []string) {
+		quickfixMove(-1)
+	})
+	addAlias("cp", "cprev")
+	addAlias("cprevious", "cprev")
+	addCommandDesc("copen", "Shows the quickfix list", func(args []string) {
+		showQuickfix()
+	})
+	addCommandDesc("cfile", "Fills the quickfix list from the locations in a file", func(args []string) {
+		if len(args) < 2 {
+			messageError("Usage: cfile <path>")
+			return
+		}
+		data, err := ioutil.ReadFile(args[1])
+		if err != nil {
+			messageError("Error reading file: " + err.Error())
+			return
+		}
+		wd, _ := os.Getwd()
+		setQuickfix(newErrorParser(wd).Parse(string(data)))
+	})
+	addCommandCompletion("cfile", completePath)
+	addCommandDesc("cbuffer", "Fills the quickfix list from the locations in a buffer, *grep* by default", func(args []string) {
+		name := "*grep*"
+		if len(args) > 1 {
+			name = strings.Join(args[1:], " ")
+		}
+		b := findBuffer(name)
+		if b == nil {
+			messageError("No buffer named '" + name + "'")
+			return
+		}
+		setQuickfix(bufferErrors(b))
+	})
+	addCommandCompletion("cbuffer", completeBufferName)
+
+	// errorformat = ["regexp", ...] in config.toml
+	configSections["errorformat"] = func(value interface{}) error {
+		patterns, ok := value.([]interface{})
+		if !ok {
+			return errors.New("errorformat must be a list of regexps")
+		}
+		for i := len(patterns) - 1; i >= 0; i-- {
+			pattern, ok := patterns[i].(string)
+			if !ok {
+				return errors.New("errorformat must be a list of regexps")
+			}
+			if err := addErrorFormat(pattern); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// Adds an error format, tried before the ones already there
+func addErrorFormat(pattern string) error {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return err
+	}
+	names := strings.Join(re.SubexpNames(), " ")
+	if !strings.Contains(names, "file") || !strings.Contains(names, "line") {
+		return errors.New("error format '" + pattern + "' needs file and line groups")
+	}
+	errorFormats = append([]*regexp.Regexp{re}, errorFormats...)
+	return nil
+}
+
+// Parses locations out of output with the error formats there were when
+// it was made, so it can be used away from the main loop. Relative paths
+// are relative to dir.
+type errorParser struct {
+	formats []*regexp.Regexp
+	dir     string
+	files   map[string][]string // under dir by base name, once needed
+}
+
+func newErrorParser(dir string) *errorParser {
+	return &errorParser{formats: errorFormats, dir: dir}
+}
+
+// Locations in output
+func (p *errorParser) Parse(output string) []*quickfixEntry {
+	entries := []*quickfixEntry{}
+	for _, line := range strings.Split(output, "\n") {
+		if e := p.ParseLine(strings.TrimRight(line, "\r")); e != nil {
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
+
+func (p *errorParser) ParseLine(line string) *quickfixEntry {
+	for _, re := range p.formats {
+		m := re.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		e := &quickfixEntry{}
+		for i, name := range re.SubexpNames() {
+			switch name {
+			case "file":
+				e.Path = m[i]
+			case "line":
+				e.Line, _ = strconv.Atoi(m[i])
+				e.Line--
+			case "col":
+				e.Col, _ = strconv.Atoi(m[i])
+				e.Col--
+			case "type":
+				e.Type = m[i]
+			case "message":
+				e.Text = m[i]
+			}
+		}
+		e.Path = p.resolvePath(e.Path)
+		return e
+	}
+	return nil
+}
+
+// Finds the file a path in output is about. go test gives paths relative
+// to the package, found by their end under dir when not directly in it.
+// Files under dir are only listed once, the first time one is looked for.
+func (p *errorParser) resolvePath(path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	joined := filepath.Join(p.dir, path)
+	if _, err := os.Stat(joined); err == nil {
+		return joined
+	}
+	if p.files == nil {
+		p.files = map[string][]string{}
+		walkProject(p.dir, nil, func(f string) bool {
+			name := filepath.Base(f)
+			p.files[name] = append(p.files[name], f)
+			return true
+		})
+	}
+	suffix := string(filepath.Separator) + filepath.Clean(path)
+	for _, f := range p.files[filepath.Base(path)] {
+		if strings.HasSuffix(f, suffix) {
+			return f
+		}
+	}
+	return joined
+}
+
+// Locations listed in a buffer, grep hits or output
+func bufferErrors(b *Buffer) []*quickfixEntry {
+	entries := []*quickfixEntry{}
+	wd, _ := os.Getwd()
+	p := newErrorParser(wd)
+	for i, line := range b.Data {
+		if _, ok := grepSearches[b]; ok {
+			if path, l, c, ok := grepLocation(b, i); ok {
+				m := grepLineRegexp.FindStringSubmatch(string(line))
+				entries = append(entries, &quickfixEntry{Path: path, Line: l, Col: c, Text: strings.TrimSpace(m[4])})
+			}
+		} else if e := p.ParseLine(string(line)); e != nil {
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
+
+// Replaces the quickfix list, updating its buffer if there is one
+func setQuickfix(entries []*quickfixEntry) {
+	quickfixList = entries
+	quickfixIndex = -1
+	if b := findBuffer("*quickfix*"); b != nil {
+		renderQuickfixBuffer(b)
+	}
+	if len(entries) == 0 {
+		message("Quickfix list is empty")
+		return
+	}
+	message(strconv.Itoa(len(entries)) + " locations in the quickfix list, :copen shows them")
+}
+
+// Runs command in the project root in the background, filling the
+// quickfix list with the locations in its output
+func makeCommand(command string) {
+	dir := currentProjectRoot()
+	message("Running " + command + "...")
+	p := newErrorParser(dir)
+	go func() {
+		cmd := exec.Command("sh", "-c", command)
+		cmd.Dir = dir
+		var out bytes.Buffer
+		cmd.Stdout = &out
+		cmd.Stderr = &out
+		err := cmd.Run()
+		entries := p.Parse(out.String())
+		runInMainLoop(func() {
+			setQuickfix(entries)
+			publishQuickfixDiagnostics("make", entries)
+			if err == nil && len(entries) == 0 {
+				message(command + ": done")
+			} else if err != nil && len(entries) == 0 {
+				lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+				messageError(command + ": " + err.Error() + ": " + lines[0])
+			}
+		})
+	}()
+}
+
+func quickfixLine(e *quickfixEntry) string {
+	line := relativePath(e.Path) + ":" + strconv.Itoa(e.Line+1) + ":" + strconv.Itoa(e.Col+1) + ": "
+	if e.Type != "" {
+		line += e.Type + ": "
+	}
+	return line + e.Text
+}
+
+func renderQuickfixBuffer(b *Buffer) {
+	b.Data = [][]rune{}
+	for _, e := range quickfixList {
+		b.Data = append(b.Data, []rune(quickfixLine(e)))
+	}
+	if len(b.Data) == 0 {
+		b.Data = [][]rune{[]rune{}}
+	}
+	b.MoveTo(0, max(quickfixIndex, 0))
+	hook_trigger_buffer("modified", b)
+}
+
+// Shows the *quickfix* buffer, one location per line
+func showQuickfix() {
+	b := findBuffer("*quickfix*")
+	if b == nil {
+		b = openBufferNamed("*quickfix*")
+		b.AddMode("quickfix")
+		b.ReadOnly = true
+	}
+	renderQuickfixBuffer(b)
+	showBuffer(b.Name)
+}
+
+// Goes to the location i of the quickfix list
+func quickfixGo(i int) {
+	if i < 0 || i >= len(quickfixList) {
+		return
+	}
+	quickfixIndex = i
+	e := quickfixList[i]
+	if b := findBuffer("*quickfix*"); b != nil {
+		b.MoveTo(0, i)
+	}
+	b := showFile(e.Path)
+	if b == nil {
+		return
+	}
+	b.MoveTo(e.Col, e.Line)
+	currentViewTree.Leaf.CenterPending = true
+	message("(" + strconv.Itoa(i+1) + " of " + strconv.Itoa(len(quickfixList)) + ") " + e.Text)
+}
+
+func quickfixMove(n int) {
+	if len(quickfixList) == 0 {
+		messageError("Quickfix list is empty")
+		return
+	}
+	i := quickfixIndex + n
+	if quickfixIndex < 0 && n < 0 {
+		i = len(quickfixList) - 1
+	}
+	if i < 0 || i >= len(quickfixList) {
+		messageError("No more items")
+		return
+	}
+	quickfixGo(i)
+}
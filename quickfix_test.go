@@ -0,0 +1,128 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseErrors(t *testing.T) {
+	initQuickfix()
+	dir := writeProject(t, map[string]string{
+		"go.mod":         "module example\n",
+		"main.go":        "package main\n",
+		"pkg/x_test.go":  "package pkg\n",
+		"scripts/run.py": "",
+		"src/lib.c":      "",
+	})
+	defer os.RemoveAll(dir)
+
+	output := `# example
+./main.go:3:2: undefined: foo
+--- FAIL: TestX (0.00s)
+    x_test.go:12: expected 1, got 2
+FAIL
+Traceback (most recent call last):
+  File "scripts/run.py", line 7, in main
+src/lib.c:10:5: warning: unused variable 'x'
+ok  	example/other	0.01s
+`
+	entries := newErrorParser(dir).Parse(output)
+	expected := []quickfixEntry{
+		{Path: filepath.Join(dir, "main.go"), Line: 2, Col: 1, Text: "undefined: foo"},
+		{Path: filepath.Join(dir, "pkg", "x_test.go"), Line: 11, Text: "expected 1, got 2"},
+		{Path: filepath.Join(dir, "scripts", "run.py"), Line: 6, Text: "main"},
+		{Path: filepath.Join(dir, "src", "lib.c"), Line: 9, Col: 4, Type: "warning", Text: "unused variable 'x'"},
+	}
+	if len(entries) != len(expected) {
+		t.Fatalf("expected %d entries, got %d: %+v", len(expected), len(entries), entries)
+	}
+	for i, e := range entries {
+		if *e != expected[i] {
+			t.Errorf("expected %+v, got %+v", expected[i], *e)
+		}
+	}
+
+	// Parsers keep the formats they were made with
+	before := newErrorParser(dir)
+	if err := addErrorFormat(`^ERR (?P<file>\S+) (?P<line>\d+) (?P<message>.*)$`); err != nil {
+		t.Fatal(err)
+	}
+	if before.ParseLine("ERR main.go 4 oops") != nil {
+		t.Fatal("expected the format added later not used")
+	}
+	if e := newErrorParser(dir).ParseLine("ERR main.go 4 oops"); e == nil || e.Line != 3 || e.Text != "oops" {
+		t.Fatalf("unexpected entry %+v", e)
+	}
+	if err := addErrorFormat(`^(?P<message>.*)$`); err == nil {
+		t.Fatal("expected an error for a format without file and line")
+	}
+}
+
+func TestQuickfix(t *testing.T) {
+	dir := writeProject(t, map[string]string{
+		"go.mod":  "module example\n",
+		"a.go":    "package main\n\nfunc a() {}\n",
+		"b.go":    "package main\n\nfunc b() {\n\tx()\n}\n",
+		"out.txt": "a.go:3:6: first\nb.go:4:2: second\n",
+	})
+	defer os.RemoveAll(dir)
+	wd, _ := os.Getwd()
+	os.Chdir(dir)
+	defer os.Chdir(wd)
+
+	h := newHeadless(80, 24, []string{filepath.Join(dir, "a.go")})
+	h.Keys(": c n RET")
+	if h.Message() != "Quickfix list is empty" {
+		t.Fatalf("unexpected message %q", h.Message())
+	}
+	h.Keys(": c f i l e SPC o u t . t x t RET")
+	h.Keys(": c n RET")
+	if line, char := h.Cursor(); h.Buffer().Name != "a.go" || line != 2 || char != 5 {
+		t.Fatalf("expected a.go at 2,5, got %s at %d,%d", h.Buffer().Name, line, char)
+	}
+	h.Keys(": c n RET")
+	if line, char := h.Cursor(); h.Buffer().Name != "b.go" || line != 3 || char != 1 {
+		t.Fatalf("expected b.go at 3,1, got %s at %d,%d", h.Buffer().Name, line, char)
+	}
+	if h.Message() != "(2 of 2) second" {
+		t.Fatalf("unexpected message %q", h.Message())
+	}
+	h.Keys(": c n RET")
+	if h.Message() != "No more items" {
+		t.Fatalf("unexpected message %q", h.Message())
+	}
+	h.Keys(": c o p e n RET")
+	if h.Contents() != "a.go:3:6: first\nb.go:4:2: second\n" {
+		t.Fatalf("unexpected quickfix buffer %q", h.Contents())
+	}
+	h.Keys("g g RET")
+	if h.Buffer().Name != "a.go" {
+		t.Fatalf("expected a.go, got %s", h.Buffer().Name)
+	}
+
+	// From grep results
+	configSet("grep_rg", false)
+	h.Keys(": g r e p SPC f u n c RET")
+	waitGrep(t, h)
+	h.Keys(": c b u f f e r RET : c n RET")
+	if len(quickfixList) != 2 || h.Buffer().Name == "*grep*" {
+		t.Fatalf("expected 2 locations from grep, got %d", len(quickfixList))
+	}
+
+	// From :make, run in the project root
+	ioutil.WriteFile(filepath.Join(dir, "build.sh"), []byte("echo \"b.go:5:1: $1\" >&2; exit 1\n"), 0755)
+	configSet("makeprg", "sh build.sh")
+	quickfixList = nil
+	h.Keys(": m a k e SPC ' s y n t a x SPC e r r o r ' RET")
+	deadline := time.Now().Add(5 * time.Second)
+	for len(quickfixList) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+		h.Process()
+	}
+	if len(quickfixList) != 1 || quickfixList[0].Text != "syntax error" || quickfixList[0].Path != filepath.Join(dir, "b.go") {
+		t.Fatalf("unexpected quickfix list %+v", quickfixList)
+	}
+}
@@ -0,0 +1,414 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// The remote control server speaks JSON-RPC 2.0 over a Unix socket, one
+// message per line. Requests are handled on the main loop:
+//
+//   open      {"path": "f.go", "line": 42, "wait": true}  -> {"buffer": "f.go"}
+//   command   {"args": ["write"]} or {"line": "e %.orig"} -> {"message": "..."}
+//   buffer    {"name": "f.go"} (defaults to current)     -> {"name", "path", "modified", "cursor", "lines"}
+//   buffers   {}                                         -> [{"name", "path", "modified"}]
+//   subscribe {"hook": "modified"}                       -> true
+//
+// With "wait", open only replies once the buffer is closed. Subscribers
+// get {"method": "hook", "params": {"hook": "modified", "buffer": "f.go"}}
+// notifications when the buffer hook runs.
+
+type remoteMessage struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id,omitempty"`
+	Method  string           `json:"method,omitempty"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+	Result  interface{}      `json:"result,omitempty"`
+	Error   *remoteError     `json:"error,omitempty"`
+}
+
+type remoteError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+const (
+	remoteErrorParse   = -32700
+	remoteErrorMethod  = -32601
+	remoteErrorParams  = -32602
+	remoteErrorCommand = 1
+)
+
+// A client connection, only used from the main loop but for conn
+type remoteConn struct {
+	conn   net.Conn
+	out    chan *remoteMessage
+	closed bool
+}
+
+var (
+	remoteListener    net.Listener
+	remoteSocketPath  = ""
+	remoteSubscribers = map[string][]*remoteConn{}
+	remoteMethods     = map[string]func(*remoteConn, *remoteMessage) (interface{}, error){}
+	remoteWaiting     = map[*Buffer][]remoteWait{}
+)
+
+// An open request waiting for its buffer to be closed
+type remoteWait struct {
+	conn   *remoteConn
+	req    *remoteMessage
+	result interface{}
+}
+
+// Returns the socket path from the --listen flag value or $RY_LISTEN
+func remoteSocket(flagValue string) string {
+	if flagValue != "" {
+		return flagValue
+	}
+	return os.Getenv("RY_LISTEN")
+}
+
+func initRemote() {
+	remoteSubscribers = map[string][]*remoteConn{}
+	remoteWaiting = map[*Buffer][]remoteWait{}
+	remoteMethods = map[string]func(*remoteConn, *remoteMessage) (interface{}, error){
+		"open":      remoteOpen,
+		"command":   remoteCommand,
+		"buffer":    remoteBuffer,
+		"buffers":   remoteBuffers,
+		"subscribe": remoteSubscribe,
+	}
+
+	hook_buffer("closed", func(b *Buffer) {
+		for _, w := range remoteWaiting[b] {
+			w.conn.reply(w.req, w.result, nil)
+		}
+		delete(remoteWaiting, b)
+	})
+}
+
+// Starts listening on the Unix socket at path, replacing stale sockets
+// left by instances that didn't exit cleanly
+func startRemoteServer(path string) error {
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return errors.New("another ry is already listening on " + path)
+	}
+	os.Remove(path)
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	remoteListener = l
+	remoteSocketPath = path
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveRemote(conn)
+		}
+	}()
+	return nil
+}
+
+func stopRemoteServer() {
+	if remoteListener != nil {
+		remoteListener.Close()
+		os.Remove(remoteSocketPath)
+		remoteListener = nil
+	}
+}
+
+func serveRemote(conn net.Conn) {
+	rc := &remoteConn{conn: conn, out: make(chan *remoteMessage, 100)}
+	go func() {
+		enc := json.NewEncoder(conn)
+		for msg := range rc.out {
+			if enc.Encode(msg) != nil {
+				conn.Close()
+			}
+		}
+	}()
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		req := &remoteMessage{}
+		err := json.Unmarshal(scanner.Bytes(), req)
+		done := make(chan bool)
+		runInMainLoop(func() {
+			if err != nil {
+				// Replies to requests whose id couldn't be read have a null one
+				id := json.RawMessage("null")
+				rc.send(&remoteMessage{JSONRPC: "2.0", ID: &id, Error: &remoteError{remoteErrorParse, err.Error()}})
+			} else {
+				remoteHandle(rc, req)
+			}
+			close(done)
+		})
+		<-done
+	}
+
+	runInMainLoop(func() {
+		rc.closed = true
+		for hook, conns := range remoteSubscribers {
+			for i, c := range conns {
+				if c == rc {
+					remoteSubscribers[hook] = append(conns[:i], conns[i+1:]...)
+					break
+				}
+			}
+		}
+		close(rc.out)
+	})
+	conn.Close()
+}
+
+// Runs on the main loop
+func remoteHandle(rc *remoteConn, req *remoteMessage) {
+	method, ok := remoteMethods[req.Method]
+	if !ok {
+		rc.reply(req, nil, &remoteError{remoteErrorMethod, "no method named '" + req.Method + "'"})
+		return
+	}
+	result, err := method(rc, req)
+	if err == errRemoteDeferred {
+		return
+	}
+	if err != nil {
+		code := remoteErrorCommand
+		if _, ok := err.(*json.UnmarshalTypeError); ok {
+			code = remoteErrorParams
+		}
+		rc.reply(req, nil, &remoteError{code, err.Error()})
+		return
+	}
+	rc.reply(req, result, nil)
+}
+
+// Returned by methods that reply later by themselves
+var errRemoteDeferred = errors.New("deferred reply")
+
+func (rc *remoteConn) reply(req *remoteMessage, result interface{}, err *remoteError) {
+	if req.ID == nil {
+		return // notification
+	}
+	if result == nil && err == nil {
+		result = true
+	}
+	rc.send(&remoteMessage{JSONRPC: "2.0", ID: req.ID, Result: result, Error: err})
+}
+
+func (rc *remoteConn) notify(method string, params interface{}) {
+	data, _ := json.Marshal(params)
+	rc.send(&remoteMessage{JSONRPC: "2.0", Method: method, Params: data})
+}
+
+func (rc *remoteConn) send(msg *remoteMessage) {
+	if rc.closed {
+		return
+	}
+	select {
+	case rc.out <- msg:
+	default:
+		// never block the editor on clients not reading
+	}
+}
+
+func remoteParams(req *remoteMessage, params interface{}) error {
+	if len(req.Params) == 0 {
+		return nil
+	}
+	return json.Unmarshal(req.Params, params)
+}
+
+func remoteOpen(rc *remoteConn, req *remoteMessage) (interface{}, error) {
+	params := struct {
+		Path string `json:"path"`
+		Line int    `json:"line"`
+		Wait bool   `json:"wait"`
+	}{}
+	if err := remoteParams(req, &params); err != nil {
+		return nil, err
+	}
+	if params.Path == "" {
+		return nil, errors.New("no path given")
+	}
+	b := showFile(params.Path)
+	if b == nil {
+		return nil, errors.New(editorMessage)
+	}
+	if params.Line > 0 {
+		currentViewTree.Leaf.CenterPending = true
+		b.MoveTo(0, params.Line-1)
+	}
+	result := map[string]string{"buffer": b.Name}
+	if params.Wait {
+		remoteWaiting[b] = append(remoteWaiting[b], remoteWait{conn: rc, req: req, result: result})
+		return nil, errRemoteDeferred
+	}
+	return result, nil
+}
+
+func remoteCommand(rc *remoteConn, req *remoteMessage) (interface{}, error) {
+	params := struct {
+		Args []string `json:"args"`
+		Line string   `json:"line"`
+	}{}
+	if err := remoteParams(req, &params); err != nil {
+		return nil, err
+	}
+	message("")
+	if params.Line != "" {
+		runCommandLine(params.Line)
+	} else {
+		runCommand(params.Args)
+	}
+	if editorMessageType == "error" {
+		return nil, errors.New(editorMessage)
+	}
+	return map[string]string{"message": editorMessage}, nil
+}
+
+type remoteBufferInfo struct {
+	Name     string         `json:"name"`
+	Path     string         `json:"path"`
+	Modified bool           `json:"modified"`
+	Cursor   map[string]int `json:"cursor,omitempty"`
+	Lines    []string       `json:"lines,omitempty"`
+}
+
+func remoteBuffer(rc *remoteConn, req *remoteMessage) (interface{}, error) {
+	params := struct {
+		Name string `json:"name"`
+	}{}
+	if err := remoteParams(req, &params); err != nil {
+		return nil, err
+	}
+	b := currentViewTree.Leaf.Buf
+	if params.Name != "" {
+		if b = findBuffer(params.Name); b == nil {
+			return nil, errors.New("no buffer named '" + params.Name + "'")
+		}
+	}
+	lines := make([]string, len(b.Data))
+	for i, line := range b.Data {
+		lines[i] = string(line)
+	}
+	return &remoteBufferInfo{
+		Name:     b.Name,
+		Path:     b.Path,
+		Modified: b.Modified,
+		Cursor:   map[string]int{"line": b.Cursor.Line + 1, "char": b.Cursor.Char + 1},
+		Lines:    lines,
+	}, nil
+}
+
+func remoteBuffers(rc *remoteConn, req *remoteMessage) (interface{}, error) {
+	infos := []*remoteBufferInfo{}
+	for _, b := range buffers {
+		infos = append(infos, &remoteBufferInfo{Name: b.Name, Path: b.Path, Modified: b.Modified})
+	}
+	return infos, nil
+}
+
+func remoteSubscribe(rc *remoteConn, req *remoteMessage) (interface{}, error) {
+	params := struct {
+		Hook string `json:"hook"`
+	}{}
+	if err := remoteParams(req, &params); err != nil {
+		return nil, err
+	}
+	if params.Hook == "" {
+		return nil, errors.New("no hook given")
+	}
+	if _, ok := remoteSubscribers[params.Hook]; !ok {
+		hook := params.Hook
+		hook_buffer(hook, func(b *Buffer) {
+			for _, c := range remoteSubscribers[hook] {
+				c.notify("hook", map[string]string{"hook": hook, "buffer": b.Name})
+			}
+		})
+	}
+	remoteSubscribers[params.Hook] = append(remoteSubscribers[params.Hook], rc)
+	return true, nil
+}
+
+// Splits "file:42" into a path and line number, line being 0 if absent
+func parseFileLine(arg string) (string, int) {
+	if i := strings.LastIndex(arg, ":"); i > 0 {
+		if line, err := strconv.Atoi(arg[i+1:]); err == nil {
+			return arg[:i], line
+		}
+	}
+	return arg, 0
+}
+
+// Returned by remoteOpenFiles when there's no ry listening on the socket
+var errNoRemote = errors.New("no ry listening")
+
+// Asks the ry listening on socket to open files ("path:line"), then waits
+// for all their buffers to be closed if wait is set. Errors wrap
+// errNoRemote when no ry could be reached.
+func remoteOpenFiles(socket string, files []string, wait bool) error {
+	if socket == "" {
+		return fmt.Errorf("%w: set $RY_LISTEN or --listen", errNoRemote)
+	}
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errNoRemote, err)
+	}
+	defer conn.Close()
+
+	enc := json.NewEncoder(conn)
+	pending := map[string]string{} // files by request id
+	for i, file := range files {
+		path, line := parseFileLine(file)
+		if abs, err := filepath.Abs(path); err == nil {
+			path = abs
+		}
+		params, _ := json.Marshal(map[string]interface{}{"path": path, "line": line, "wait": wait})
+		id := json.RawMessage(strconv.Itoa(i + 1))
+		if err := enc.Encode(&remoteMessage{JSONRPC: "2.0", ID: &id, Method: "open", Params: params}); err != nil {
+			return err
+		}
+		pending[string(id)] = file
+	}
+
+	scanner := bufio.NewScanner(conn)
+	for len(pending) > 0 {
+		if !scanner.Scan() {
+			for i, file := range files {
+				if _, ok := pending[strconv.Itoa(i+1)]; ok {
+					return fmt.Errorf("connection closed before '%s' was done with", file)
+				}
+			}
+		}
+		res := &remoteMessage{}
+		if err := json.Unmarshal(scanner.Bytes(), res); err != nil {
+			return err
+		}
+		if res.ID == nil {
+			continue
+		}
+		file, ok := pending[string(*res.ID)]
+		if !ok {
+			continue
+		}
+		if res.Error != nil {
+			return errors.New(file + ": " + res.Error.Message)
+		}
+		delete(pending, string(*res.ID))
+	}
+	return nil
+}
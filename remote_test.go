@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Starts a remote server for h on a temporary socket, returning its path
+func startTestServer(t *testing.T) string {
+	socket := filepath.Join(t.TempDir(), "ry.sock")
+	if err := startRemoteServer(socket); err != nil {
+		t.Fatal(err)
+	}
+	return socket
+}
+
+// Runs the main loop's queued functions until f returns
+func processUntil(t *testing.T, h *headless, f func()) {
+	t.Helper()
+	done := make(chan bool)
+	go func() {
+		f()
+		close(done)
+	}()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case <-done:
+			return
+		case <-timeout:
+			t.Fatal("timed out waiting for the remote client")
+		default:
+			h.Process()
+			time.Sleep(time.Millisecond)
+		}
+	}
+}
+
+type testClient struct {
+	conn    net.Conn
+	scanner *bufio.Scanner
+}
+
+func (c *testClient) call(method string, params interface{}) *remoteMessage {
+	data, _ := json.Marshal(map[string]interface{}{"jsonrpc": "2.0", "id": 1, "method": method, "params": params})
+	c.conn.Write(append(data, '\n'))
+	return c.read()
+}
+
+func (c *testClient) read() *remoteMessage {
+	msg := &remoteMessage{}
+	if c.scanner.Scan() {
+		json.Unmarshal(c.scanner.Bytes(), msg)
+	}
+	return msg
+}
+
+func TestRemote(t *testing.T) {
+	path := tempFile(t, "one\ntwo\nthree\n")
+	h := newHeadless(80, 24, nil)
+	socket := startTestServer(t)
+	defer stopRemoteServer()
+
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	c := &testClient{conn: conn, scanner: bufio.NewScanner(conn)}
+
+	var res *remoteMessage
+	processUntil(t, h, func() {
+		res = c.call("open", map[string]interface{}{"path": path, "line": 2})
+	})
+	if res.Error != nil || h.Buffer().Path != path {
+		t.Fatalf("open failed: %v", res.Error)
+	}
+	expectCursor(t, h, 1, 0)
+
+	processUntil(t, h, func() {
+		res = c.call("subscribe", map[string]string{"hook": "modified"})
+	})
+	h.Keys("i x ESC")
+	res = c.read()
+	if res.Method != "hook" || string(res.Params) != `{"buffer":"file.txt","hook":"modified"}` {
+		t.Fatalf("expected hook notification, got %s %s", res.Method, res.Params)
+	}
+
+	processUntil(t, h, func() {
+		res = c.call("buffer", nil)
+	})
+	result := res.Result.(map[string]interface{})
+	lines := result["lines"].([]interface{})
+	if result["name"] != "file.txt" || len(lines) != 3 || lines[1] != "xtwo" {
+		t.Fatalf("unexpected buffer %v", result)
+	}
+
+	processUntil(t, h, func() {
+		res = c.call("command", map[string][]string{"args": {"write"}})
+	})
+	if data, _ := ioutil.ReadFile(path); string(data) != "one\nxtwo\nthree\n" {
+		t.Fatalf("command didn't write the buffer, file has %q", string(data))
+	}
+
+	processUntil(t, h, func() {
+		res = c.call("command", map[string][]string{"args": {"nope"}})
+	})
+	if res.Error == nil || res.Error.Message != "No command named 'nope'" {
+		t.Fatalf("expected command error, got %v", res.Error)
+	}
+
+	processUntil(t, h, func() {
+		res = c.call("nope", nil)
+	})
+	if res.Error == nil || res.Error.Code != remoteErrorMethod {
+		t.Fatalf("expected method error, got %v", res.Error)
+	}
+
+	// Lines that aren't JSON get a parse error with a null id
+	processUntil(t, h, func() {
+		conn.Write([]byte("nope\n"))
+		c.scanner.Scan()
+	})
+	if line := c.scanner.Text(); !strings.Contains(line, `"id":null`) || !strings.Contains(line, `"code":-32700`) {
+		t.Fatalf("expected a parse error, got %s", line)
+	}
+}
+
+func TestRemoteOpenWait(t *testing.T) {
+	path := tempFile(t, "text\n")
+	other := filepath.Join(filepath.Dir(path), "other.txt")
+	if err := ioutil.WriteFile(other, []byte("other\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	h := newHeadless(80, 24, nil)
+	socket := startTestServer(t)
+	defer stopRemoteServer()
+
+	if err := remoteOpenFiles(filepath.Join(filepath.Dir(path), "none.sock"), []string{path}, false); !errors.Is(err, errNoRemote) {
+		t.Fatalf("expected no ry to reach, got %v", err)
+	}
+
+	// Both files open before either is waited for
+	opened := make(chan error)
+	go func() {
+		opened <- remoteOpenFiles(socket, []string{path + ":1", other}, true)
+	}()
+	timeout := time.After(5 * time.Second)
+	for h.Buffer().Path != other {
+		select {
+		case <-timeout:
+			t.Fatal("timed out waiting for the files to open")
+		default:
+			h.Process()
+		}
+	}
+	hooks := len(hooks_buffer["closed"])
+	h.Keys(": q RET")
+	select {
+	case err := <-opened:
+		t.Fatalf("returned before the buffers were closed: %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	h.Keys(": b SPC f i l e . t x t RET : q RET")
+	if err := <-opened; err != nil {
+		t.Fatal(err)
+	}
+	if len(remoteWaiting) != 0 || len(hooks_buffer["closed"]) != hooks {
+		t.Fatal("expected the waits answered and forgotten")
+	}
+}
+
+func TestParseFileLine(t *testing.T) {
+	tests := []struct {
+		arg  string
+		path string
+		line int
+	}{
+		{"main.go", "main.go", 0},
+		{"main.go:42", "main.go", 42},
+		{"a:b.go", "a:b.go", 0},
+		{"a:b.go:3", "a:b.go", 3},
+	}
+	for _, test := range tests {
+		if path, line := parseFileLine(test.arg); path != test.path || line != test.line {
+			t.Errorf("%s: got %s %d", test.arg, path, line)
+		}
+	}
+}
@@ -3,6 +3,8 @@ package main
 import (
 	"fmt"
 	"strconv"
+
+	"github.com/gdamore/tcell"
 )
 
 func render() {
@@ -10,7 +12,21 @@ func render() {
 
 	screen.Clear()
 
+	if editorSnippet != nil {
+		checkSnippet()
+	}
+
 	renderViewTree(rootViewTree, 0, 0, width, height-1)
+	renderWhichKey(width, height)
+	if editorPicker != nil {
+		renderPicker(width, height)
+	}
+	if editorCompletion != nil {
+		renderCompletion(width, height)
+	}
+	if hoverPopup != nil {
+		renderHover(width, height)
+	}
 
 	renderMessageBar(width, height)
 
@@ -20,10 +36,8 @@ func render() {
 func renderMessageBar(width, height int) {
 	s := style("default")
 
-	if editorMode == "prompt" {
-		p := editorPrompt + editorPromptValue
-		write(s, 0, height-1, p)
-		write(s.Reverse(true), len(p), height-1, " ")
+	if editorMode == "prompt" || editorMode == "picker" {
+		renderPrompt(s, width, height)
 		return
 	}
 
@@ -33,6 +47,8 @@ func renderMessageBar(width, height int) {
 	}
 	if editorMessage != "" {
 		write(smb, 0, height-1, editorMessage)
+	} else if d := cursorDiagnostic(); d != nil && len(keysEntered.keys) == 0 {
+		write(diagnosticStyle(d), 0, height-1, d.Severity.String()+": "+d.Message)
 	} else {
 		write(smb, 0, height-1, keysEntered.String())
 	}
@@ -40,12 +56,57 @@ func renderMessageBar(width, height int) {
 	write(s, width-len(lastKeyText)-1, height-1, lastKeyText)
 }
 
+func renderPrompt(s tcell.Style, width, height int) {
+	value := []rune(editorPromptValue)
+	x := write(s, 0, height-1, editorPrompt)
+	x += write(s, x, height-1, string(value[:editorPromptCursor]))
+	under := " "
+	if editorPromptCursor < len(value) {
+		under = string(value[editorPromptCursor])
+	}
+	cx := x
+	x += write(s.Reverse(true), x, height-1, under)
+	if editorPromptCursor < len(value) {
+		write(s, x, height-1, string(value[editorPromptCursor+1:]))
+	}
+
+	// Completion menu, above the word being completed
+	if len(editorPromptCompletions) > 0 {
+		lines := completionLines(editorPromptCompletions, false)
+		menuWidth := 0
+		for _, line := range lines {
+			menuWidth = max(menuWidth, len([]rune(line)))
+		}
+		menuWidth = min(menuWidth+4, width)
+		menuHeight := min(len(lines), 10) + 2
+		mx := cx - (editorPromptCursor - editorPromptCompletionStart)
+		mx = max(min(mx-2, width-menuWidth), 0)
+		renderPopup(&Popup{
+			Lines:    lines,
+			Selected: editorPromptCompletionIndex,
+		}, mx, height-1-menuHeight, menuWidth, menuHeight)
+	}
+}
+
 func renderViewTree(vt *ViewTree, x, y, w, h int) {
 	if vt.Leaf != nil {
+		vt.X, vt.Y, vt.W, vt.H = x, y, w, h
 		renderView(vt.Leaf, x, y, w, h)
 		return
 	}
-	panic("unreachable")
+	if vt.Left != nil {
+		// One column between the two for a separator
+		lw := (w - 1) * vt.Size / 100
+		renderViewTree(vt.Left, x, y, lw, h)
+		for sy := y; sy < y+h; sy++ {
+			screen.SetContent(x+lw, sy, tcell.RuneVLine, nil, style("statusbar"))
+		}
+		renderViewTree(vt.Right, x+lw+1, y, w-lw-1, h)
+		return
+	}
+	th := h * vt.Size / 100
+	renderViewTree(vt.Top, x, y, w, th)
+	renderViewTree(vt.Bottom, x, y+th, w, h-th)
 }
 
 func renderView(v *View, x, y, w, h int) {
@@ -59,17 +120,29 @@ func renderView(v *View, x, y, w, h int) {
 	b.LastRenderHeight = h
 
 	styleMap := highlighting_styles(b)
+	diags := bufferDiagnostics(b)
 
 	gutterw := len(strconv.Itoa(len(b.Data))) + 1
+	signw := 0
+	if signColumnShown(b, diags) {
+		signw = 2
+	}
 	sy := y
 	line := v.LineOffset
 	for line < len(b.Data) && sy < y+h-1 {
 		write(sln, x, sy, padl(strconv.Itoa(line+1), gutterw-1, ' '))
+		if d := diagnosticAt(diags, line); d != nil && signw > 0 {
+			write(diagnosticStyle(d), x+gutterw, sy, d.Sign())
+		}
+		marks := diagnosticMarks(diags, line, b.Data[line])
 
-		sx := x + gutterw
+		sx := x + gutterw + signw
 		for c, char := range b.Data[line] {
 			if v == currentViewTree.Leaf && line == b.Cursor.Line && c == b.Cursor.Char {
+				v.CursorX, v.CursorY = sx, sy
 				sx += write(sc, sx, sy, string(char))
+			} else if marks != nil && marks[c] != nil {
+				sx += write(styleMap[line][c].Underline(true), sx, sy, string(char))
 			} else {
 				sx += write(styleMap[line][c], sx, sy, string(char))
 			}
@@ -80,6 +153,7 @@ func renderView(v *View, x, y, w, h int) {
 		if v == currentViewTree.Leaf &&
 			line == b.Cursor.Line &&
 			b.Cursor.Char == len(b.Data[b.Cursor.Line]) {
+			v.CursorX, v.CursorY = sx, sy
 			write(sc, sx, sy, " ")
 		}
 
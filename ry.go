@@ -1,8 +1,11 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
 
 	"github.com/gdamore/tcell"
 )
@@ -15,6 +18,7 @@ var (
 	keysEntered                    = NewKeyList("")
 	lastKey                        = NewKeyList("")
 	termEvents                     = make(chan tcell.Event, 500)
+	mainLoopFuncs                  = make(chan func(), 500)
 	defaultClipboard               = '_'
 	clipboards                     = map[rune][]rune{'_': []rune{}}
 	editorMode                     = "normal"
@@ -22,6 +26,7 @@ var (
 	editorMessageType              = "info"
 	editorWidth                    = 0
 	editorHeight                   = 0
+	editorQuitting                 = false
 	buffers                        = []*Buffer{}
 	screen            tcell.Screen = nil
 	rootViewTree      *ViewTree    = nil
@@ -29,69 +34,155 @@ var (
 )
 
 func main() {
-	if len(os.Args) == 2 && os.Args[1] == "-v" {
+	version := flag.Bool("v", false, "print version and exit")
+	headless := flag.Bool("headless", false, "run without a terminal, printing the buffer once keys are handled")
+	keys := flag.String("keys", "", "keys to handle in headless mode, in key list notation (e.g. \"i a ESC : w RET\")")
+	listen := flag.String("listen", "", "path of the Unix socket to listen on for remote control (default $RY_LISTEN)")
+	remote := flag.Bool("remote", false, "open files (as path or path:line) in the ry listening on the socket")
+	remoteWait := flag.Bool("remote-wait", false, "like --remote, then wait for their buffers to be closed")
+	flag.Parse()
+
+	if *version {
 		fmt.Println("ry v0.0.0")
 		os.Exit(0)
 	}
 
 	defer handlePanics()
 
-	initModes()
-	initCommands()
+	if *headless {
+		os.Exit(runHeadless(*keys, flag.Args()))
+	}
 
-	initConfig()
-	init_hooks()
-	init_highlighting()
-	init_search()
-	initVisual()
-	initTerm()
+	socket := remoteSocket(*listen)
+	files := flag.Args()
+	lines := map[string]int{}
+	if *remote || *remoteWait {
+		err := remoteOpenFiles(socket, files, *remoteWait)
+		if err == nil {
+			os.Exit(0)
+		}
+		if !errors.Is(err, errNoRemote) {
+			fmt.Fprintln(os.Stderr, "ry: "+err.Error())
+			os.Exit(1)
+		}
+		// No ry to reuse, open them in this one
+		for i, file := range files {
+			path, line := parseFileLine(file)
+			files[i] = path
+			if abs, err := filepath.Abs(path); err == nil {
+				lines[abs] = line
+			}
+		}
+	}
+
+	initEditor()
+	loadConfigFile()
+	loadInitScript()
+	loadPromptHistory()
 
 	initScreen()
 	initTermEvents()
-	initBuffers()
+	initBuffers(files)
 	initViews()
+	for _, b := range buffers {
+		if line := lines[b.Path]; line > 0 {
+			b.MoveTo(0, line-1)
+		}
+	}
+
+	if socket != "" {
+		if err := startRemoteServer(socket); err != nil {
+			messageError("Remote control disabled: " + err.Error())
+		}
+		defer stopRemoteServer()
+	}
 
 	render()
 
-top:
-	for {
+	for !editorQuitting {
 		select {
 		case ev := <-termEvents:
-			switch ev := ev.(type) {
-			case *tcell.EventKey:
-				if ev.Key() == tcell.KeyCtrlQ {
-					screen.Fini()
-					screen = nil
-					break top
-					/*
-						} else if ev.Key() == tcell.KeyEscape {
-							kl := k("ESC")
-							enter_normal_mode(current_view_tree, current_view_tree.leaf.buf, kl)
-							last_key = kl
-							keys_entered = k("")
-					*/
-				} else {
-					keysEntered.AddKey(NewKeyFromEvent(ev))
-
-					buf := currentViewTree.Leaf.Buf
-					for _, mode_name := range buf.Modes {
-						if matched := modeHandle(mustFindMode(mode_name), keysEntered); matched != nil {
-							keysEntered = k("")
-							lastKey = matched
-							continue top
-						}
-					}
-					if matched := modeHandle(mustFindMode(editorMode), keysEntered); matched != nil {
-						keysEntered = k("")
-						lastKey = matched
-						continue top
-					}
-				}
-			case *tcell.EventResize:
-				editorWidth, editorHeight = screen.Size()
-			}
+			handleEvent(ev)
+		case f := <-mainLoopFuncs:
+			f()
 		default:
 			render()
 		}
 	}
+
+	screen.Fini()
+	screen = nil
+	savePromptHistory()
+	stopLanguageServers()
+}
+
+// Resets the editor state then sets up modes, commands and the hooks
+// every subsystem needs
+func initEditor() {
+	keysEntered = k("")
+	lastKey = k("")
+	editorMode = "normal"
+	editorQuitting = false
+	message("")
+	marks = map[rune]*Mark{}
+	changeListeners = nil
+	clipboards = map[rune][]rune{defaultClipboard: []rune{}}
+
+	initModes()
+	initPrompt()
+	initCommands()
+
+	initConfig()
+	init_hooks()
+	initLanguages()
+	init_highlighting()
+	init_search()
+	initVisual()
+	initMappings()
+	initWhichKey()
+	initHelp()
+	initWindows()
+	initPicker()
+	initGrep()
+	initSubstitute()
+	initQuickfix()
+	initDiagnostics()
+	initCompletion()
+	initWords()
+	initSnippets()
+	initTags()
+	initLSP()
+	initTerm()
+	initRemote()
+	initScripting()
+}
+
+func handleEvent(ev tcell.Event) {
+	switch ev := ev.(type) {
+	case *tcell.EventKey:
+		if ev.Key() == tcell.KeyCtrlQ {
+			quit()
+		} else {
+			handleKey(NewKeyFromEvent(ev))
+		}
+	case *tcell.EventResize:
+		editorWidth, editorHeight = screen.Size()
+	}
+}
+
+func handleKey(key *Key) {
+	hoverPopup = nil
+	keysEntered.AddKey(key)
+	dispatchKeys(false)
+}
+
+// Queues f to run on the main loop, where it can safely use editor state.
+// Meant for goroutines (servers, background jobs) reporting back.
+func runInMainLoop(f func()) {
+	mainLoopFuncs <- f
+}
+
+// Stops the main loop, exiting the editor
+func quit() {
+	editorQuitting = true
 }
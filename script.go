@@ -0,0 +1,607 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// Scripting is done in Lua. Scripts get an `ry` module to drive the
+// editor, e.g. in ~/.config/ry/init.lua:
+//
+//   ry.set("tab_width", 2)
+//   ry.bind("normal", "SPC w", function(buf) ry.command("write") end)
+//   ry.add_command("lines", function(args)
+//     ry.message(ry.buffer():count() .. " lines")
+//   end)
+//   ry.hook("modified", function(buf) end)
+//
+// Buffers and views are userdata with methods modeled after Vim's Perl
+// interface (buf:get(1, 3), buf:set(2, "text"), view:cursor(), ...).
+// Lines and columns are 1-based on the Lua side.
+
+var luaState *lua.LState
+
+const (
+	luaBufferType = "ry.buffer"
+	luaViewType   = "ry.view"
+)
+
+func initScripting() {
+	if luaState != nil {
+		luaState.Close()
+	}
+	L := lua.NewState()
+	luaState = L
+
+	mod := L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+		"message":          luaMessage,
+		"error":            luaError,
+		"command":          luaCommand,
+		"add_command":      luaAddCommand,
+		"bind":             luaBind,
+		"map":              luaMap,
+		"add_mode":         luaAddMode,
+		"hook":             luaHook,
+		"set":              luaSet,
+		"get":              luaGet,
+		"add_option":       luaAddOption,
+		"add_error_format": luaAddErrorFormat,
+		"add_completion":   luaAddCompletion,
+		"buffer":           luaCurrentBuffer,
+		"buffers":          luaBuffers,
+		"view":             luaCurrentView,
+		"prompt":           luaPrompt,
+		"yes_or_no":        luaYesOrNo,
+	})
+	L.SetGlobal("ry", mod)
+
+	bufferMeta := L.NewTypeMetatable(luaBufferType)
+	L.SetField(bufferMeta, "__index", L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+		"name":            luaBufferName,
+		"path":            luaBufferPath,
+		"count":           luaBufferCount,
+		"get":             luaBufferGet,
+		"set":             luaBufferSet,
+		"append":          luaBufferAppend,
+		"delete":          luaBufferDelete,
+		"insert":          luaBufferInsert,
+		"cursor":          luaBufferCursor,
+		"modified":        luaBufferModified,
+		"save":            luaBufferSave,
+		"modes":           luaBufferModes,
+		"add_mode":        luaBufferAddMode,
+		"remove_mode":     luaBufferRemoveMode,
+		"option":          luaBufferOption,
+		"set_option":      luaBufferSetOption,
+		"set_diagnostics": luaBufferSetDiagnostics,
+	}))
+	viewMeta := L.NewTypeMetatable(luaViewType)
+	L.SetField(viewMeta, "__index", L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+		"buffer": luaViewBuffer,
+		"cursor": luaViewCursor,
+	}))
+
+	addCommandDesc("source", "Runs a Lua script", func(args []string) {
+		if len(args) < 2 {
+			messageError("Usage: source <file>")
+			return
+		}
+		scriptSource(args[1])
+	})
+	addAlias("so", "source")
+	addCommandCompletion("source", completePath)
+	addRawCommand("lua", "Runs Lua code", func(args []string) {
+		scriptRun(args[1])
+	})
+}
+
+// Loads the user's init script, if there is one
+func loadInitScript() {
+	path := filepath.Join(configDir(), "init.lua")
+	if _, err := os.Stat(path); err == nil {
+		scriptSource(path)
+	}
+}
+
+func scriptSource(path string) {
+	if err := luaState.DoFile(expandHome(path)); err != nil {
+		messageError("Error in '" + path + "': " + err.Error())
+	}
+}
+
+func scriptRun(code string) {
+	if err := luaState.DoString(code); err != nil {
+		messageError("Lua error: " + err.Error())
+	}
+}
+
+// Calls a Lua function from Go, reporting errors as editor messages
+func scriptCall(fn lua.LValue, nret int, args ...lua.LValue) []lua.LValue {
+	L := luaState
+	top := L.GetTop()
+	if err := L.CallByParam(lua.P{Fn: fn, NRet: nret, Protect: true}, args...); err != nil {
+		messageError("Lua error: " + err.Error())
+		return nil
+	}
+	rets := []lua.LValue{}
+	for i := top + 1; i <= L.GetTop(); i++ {
+		rets = append(rets, L.Get(i))
+	}
+	L.SetTop(top)
+	return rets
+}
+
+func luaBuffer(L *lua.LState, b *Buffer) lua.LValue {
+	ud := L.NewUserData()
+	ud.Value = b
+	L.SetMetatable(ud, L.GetTypeMetatable(luaBufferType))
+	return ud
+}
+
+func luaView(L *lua.LState, v *View) lua.LValue {
+	ud := L.NewUserData()
+	ud.Value = v
+	L.SetMetatable(ud, L.GetTypeMetatable(luaViewType))
+	return ud
+}
+
+func checkBuffer(L *lua.LState, n int) *Buffer {
+	if b, ok := L.CheckUserData(n).Value.(*Buffer); ok {
+		return b
+	}
+	L.ArgError(n, "buffer expected")
+	return nil
+}
+
+func checkView(L *lua.LState, n int) *View {
+	if v, ok := L.CheckUserData(n).Value.(*View); ok {
+		return v
+	}
+	L.ArgError(n, "view expected")
+	return nil
+}
+
+// Converts config values between Go and Lua
+func luaFromGo(L *lua.LState, v interface{}) lua.LValue {
+	switch v := v.(type) {
+	case string:
+		return lua.LString(v)
+	case float64:
+		return lua.LNumber(v)
+	case bool:
+		return lua.LBool(v)
+	case []string:
+		t := L.NewTable()
+		for _, s := range v {
+			t.Append(lua.LString(s))
+		}
+		return t
+	}
+	return lua.LNil
+}
+
+func luaToGo(v lua.LValue) interface{} {
+	switch v := v.(type) {
+	case lua.LString:
+		return string(v)
+	case lua.LNumber:
+		return float64(v)
+	case lua.LBool:
+		return bool(v)
+	}
+	return nil
+}
+
+func luaStrings(L *lua.LState, from int) []string {
+	strs := []string{}
+	for i := from; i <= L.GetTop(); i++ {
+		strs = append(strs, L.CheckString(i))
+	}
+	return strs
+}
+
+func luaMessage(L *lua.LState) int {
+	message(L.CheckString(1))
+	return 0
+}
+
+func luaError(L *lua.LState) int {
+	messageError(L.CheckString(1))
+	return 0
+}
+
+// ry.command(name, args...) runs a command as if typed after ':'
+func luaCommand(L *lua.LState) int {
+	runCommand(luaStrings(L, 1))
+	return 0
+}
+
+// ry.add_command(name, fn, desc?), fn gets the command's arguments as a table
+func luaAddCommand(L *lua.LState) int {
+	name := L.CheckString(1)
+	fn := L.CheckFunction(2)
+	addCommandDesc(name, L.OptString(3, ""), func(args []string) {
+		scriptCall(fn, 0, luaFromGo(luaState, args[1:]))
+	})
+	return 0
+}
+
+// ry.bind(mode, keys, fn, desc?), fn gets the buffer and the keys matched
+func luaBind(L *lua.LState) int {
+	mode := L.CheckString(1)
+	keys := L.CheckString(2)
+	fn := L.CheckFunction(3)
+	if findMode(mode) == nil {
+		L.ArgError(1, "no mode named '"+mode+"'")
+	}
+	bindDesc(mode, k(keys), L.OptString(4, ""), func(vt *ViewTree, b *Buffer, kl *KeyList) {
+		scriptCall(fn, 0, luaBuffer(luaState, b), lua.LString(kl.String()))
+	})
+	return 0
+}
+
+// ry.map(mode, keys, rhs, noremap?) works like :map, rhs being keys or
+// an ex command starting with ":"
+func luaMap(L *lua.LState) int {
+	if err := addMapping(L.CheckString(1), L.CheckString(2), L.CheckString(3), L.OptBool(4, false)); err != nil {
+		L.RaiseError("%s", err.Error())
+	}
+	return 0
+}
+
+// ry.add_mode(name) creates a mode buffers can be put in
+func luaAddMode(L *lua.LState) int {
+	addMode(L.CheckString(1))
+	return 0
+}
+
+// ry.hook(name, fn), fn gets the buffer the hook runs for
+func luaHook(L *lua.LState) int {
+	name := L.CheckString(1)
+	fn := L.CheckFunction(2)
+	hook_buffer(name, func(b *Buffer) {
+		scriptCall(fn, 0, luaBuffer(luaState, b))
+	})
+	return 0
+}
+
+func luaSet(L *lua.LState) int {
+	key := L.CheckString(1)
+	value := luaToGo(L.CheckAny(2))
+	if value == nil {
+		L.ArgError(2, "string, number or boolean expected")
+	}
+	if err := configSet(key, value); err != nil {
+		L.RaiseError("%s", err.Error())
+	}
+	return 0
+}
+
+func luaGet(L *lua.LState) int {
+	L.Push(luaFromGo(L, configValue(L.CheckString(1), nil)))
+	return 1
+}
+
+// ry.add_option(name, default, description?)
+func luaAddOption(L *lua.LState) int {
+	name := L.CheckString(1)
+	def := luaToGo(L.CheckAny(2))
+	if def == nil {
+		L.ArgError(2, "string, number or boolean expected")
+	}
+	addOption(name, def, L.OptString(3, ""))
+	return 0
+}
+
+// ry.add_error_format(regexp), see :make
+func luaAddErrorFormat(L *lua.LState) int {
+	if err := addErrorFormat(L.CheckString(1)); err != nil {
+		L.RaiseError("%s", err.Error())
+	}
+	return 0
+}
+
+// ry.add_completion(name, fn, priority?), fn gets the buffer and the word
+// before the cursor and returns completions: strings or tables with label
+// and optionally insert and detail
+func luaAddCompletion(L *lua.LState) int {
+	name := L.CheckString(1)
+	fn := L.CheckFunction(2)
+	addCompletionSource(name, L.OptInt(3, 0), func(req *completionRequest, reply func([]completionItem)) {
+		items := []completionItem{}
+		if req.Buf == nil {
+			reply(items)
+			return
+		}
+		rets := scriptCall(fn, 1, luaBuffer(luaState, req.Buf), lua.LString(req.Prefix))
+		if len(rets) == 1 {
+			if list, ok := rets[0].(*lua.LTable); ok {
+				list.ForEach(func(_, v lua.LValue) {
+					if t, ok := v.(*lua.LTable); ok {
+						items = append(items, completionItem{
+							Label:  lua.LVAsString(t.RawGetString("label")),
+							Insert: lua.LVAsString(t.RawGetString("insert")),
+							Detail: lua.LVAsString(t.RawGetString("detail")),
+						})
+					} else if s := lua.LVAsString(v); s != "" {
+						items = append(items, completionItem{Label: s})
+					}
+				})
+			}
+		}
+		reply(items)
+	})
+	return 0
+}
+
+func luaCurrentBuffer(L *lua.LState) int {
+	if currentViewTree == nil {
+		L.RaiseError("no buffer is shown yet")
+	}
+	L.Push(luaBuffer(L, currentViewTree.Leaf.Buf))
+	return 1
+}
+
+func luaBuffers(L *lua.LState) int {
+	t := L.NewTable()
+	for _, b := range buffers {
+		t.Append(luaBuffer(L, b))
+	}
+	L.Push(t)
+	return 1
+}
+
+func luaCurrentView(L *lua.LState) int {
+	if currentViewTree == nil {
+		L.RaiseError("no view is shown yet")
+	}
+	L.Push(luaView(L, currentViewTree.Leaf))
+	return 1
+}
+
+// ry.prompt(text, fn), fn gets what was entered
+func luaPrompt(L *lua.LState) int {
+	text := L.CheckString(1)
+	fn := L.CheckFunction(2)
+	prompt(text, noopComplete, func(value string) {
+		scriptCall(fn, 0, lua.LString(value))
+	})
+	return 0
+}
+
+// ry.yes_or_no(question, fn), fn gets true if the answer was yes
+func luaYesOrNo(L *lua.LState) int {
+	question := L.CheckString(1)
+	fn := L.CheckFunction(2)
+	prompt(question+" (yes or no) ", noopComplete, func(value string) {
+		answer := strings.ToLower(strings.TrimSpace(value))
+		scriptCall(fn, 0, lua.LBool(answer == "yes" || answer == "y"))
+	})
+	return 0
+}
+
+func luaBufferName(L *lua.LState) int {
+	L.Push(lua.LString(checkBuffer(L, 1).Name))
+	return 1
+}
+
+func luaBufferPath(L *lua.LState) int {
+	L.Push(lua.LString(checkBuffer(L, 1).Path))
+	return 1
+}
+
+func luaBufferCount(L *lua.LState) int {
+	L.Push(lua.LNumber(len(checkBuffer(L, 1).Data)))
+	return 1
+}
+
+// Checks argument n is a valid 1-based line number for b, returning it 0-based
+func checkLine(L *lua.LState, b *Buffer, n int, allowEnd bool) int {
+	l := L.CheckInt(n) - 1
+	last := len(b.Data) - 1
+	if allowEnd {
+		last++
+	}
+	if l < 0 || l > last {
+		L.ArgError(n, "line out of range")
+	}
+	return l
+}
+
+// Lines from and to? at arguments 2 and 3, 0-based
+func checkLineRange(L *lua.LState, b *Buffer) (int, int) {
+	from := checkLine(L, b, 2, false)
+	to := from
+	if L.GetTop() >= 3 {
+		to = checkLine(L, b, 3, false)
+		if to < from {
+			L.ArgError(3, "line before from")
+		}
+	}
+	return from, to
+}
+
+// buf:get(from, to?) returns lines from..to
+func luaBufferGet(L *lua.LState) int {
+	b := checkBuffer(L, 1)
+	from, to := checkLineRange(L, b)
+	for l := from; l <= to; l++ {
+		L.Push(lua.LString(string(b.Data[l])))
+	}
+	return to - from + 1
+}
+
+// buf:set(line, text...) replaces lines starting at line
+func luaBufferSet(L *lua.LState) int {
+	b := checkBuffer(L, 1)
+	l := checkLine(L, b, 2, false)
+	for i, text := range luaStrings(L, 3) {
+		if l+i >= len(b.Data) {
+			b.InsertAt(NewLocation(len(b.Data)-1, len(b.Data[len(b.Data)-1])), []rune("\n"+text))
+			continue
+		}
+		b.RemoveAt(NewLocation(l+i, 0), len(b.Data[l+i]))
+		b.InsertAt(NewLocation(l+i, 0), []rune(text))
+	}
+	return 0
+}
+
+// buf:append(line, text...) adds lines after line, 0 adding at the top
+func luaBufferAppend(L *lua.LState) int {
+	b := checkBuffer(L, 1)
+	l := L.CheckInt(2)
+	if l < 0 || l > len(b.Data) {
+		L.ArgError(2, "line out of range")
+	}
+	lines := luaStrings(L, 3)
+	if len(lines) == 0 {
+		return 0
+	}
+	text := []rune(strings.Join(lines, "\n"))
+	if l == 0 {
+		b.InsertAt(NewLocation(0, 0), append(text, '\n'))
+	} else {
+		b.InsertAt(NewLocation(l-1, len(b.Data[l-1])), append([]rune{'\n'}, text...))
+	}
+	return 0
+}
+
+// buf:delete(from, to?) removes lines from..to
+func luaBufferDelete(L *lua.LState) int {
+	b := checkBuffer(L, 1)
+	from, to := checkLineRange(L, b)
+	n := 0
+	for l := from; l <= to; l++ {
+		n += len(b.Data[l]) + 1
+	}
+	if to == len(b.Data)-1 && from > 0 {
+		// remove the newline before instead of the missing one after
+		b.RemoveAt(NewLocation(from-1, len(b.Data[from-1])), n)
+	} else {
+		b.RemoveAt(NewLocation(from, 0), n)
+	}
+	b.MoveTo(b.Cursor.Char, b.Cursor.Line)
+	return 0
+}
+
+// buf:insert(text) inserts text at the cursor
+func luaBufferInsert(L *lua.LState) int {
+	b := checkBuffer(L, 1)
+	b.Insert([]rune(L.CheckString(2)))
+	return 0
+}
+
+// buf:cursor(line?, col?) moves the cursor when given a position, returns
+// the cursor position
+func luaBufferCursor(L *lua.LState) int {
+	b := checkBuffer(L, 1)
+	if L.GetTop() >= 2 {
+		b.MoveTo(L.OptInt(3, 1)-1, L.CheckInt(2)-1)
+	}
+	L.Push(lua.LNumber(b.Cursor.Line + 1))
+	L.Push(lua.LNumber(b.Cursor.Char + 1))
+	return 2
+}
+
+// buf:set_diagnostics(source, {{line=, col=, end_line=, end_col=,
+// severity="error", message=}, ...}), replacing source's diagnostics
+func luaBufferSetDiagnostics(L *lua.LState) int {
+	b := checkBuffer(L, 1)
+	source := L.CheckString(2)
+	list := L.CheckTable(3)
+	if b.Path == "" {
+		L.RaiseError("buffer has no path")
+	}
+	diags := []*Diagnostic{}
+	list.ForEach(func(_, v lua.LValue) {
+		t, ok := v.(*lua.LTable)
+		if !ok {
+			L.RaiseError("diagnostics must be tables")
+		}
+		number := func(field string, def int) int {
+			if n, ok := t.RawGetString(field).(lua.LNumber); ok {
+				return int(n)
+			}
+			return def
+		}
+		line, col := number("line", 1), number("col", 1)
+		d := &Diagnostic{
+			Severity: DiagnosticError,
+			Beg:      NewLocation(line-1, col-1),
+			End:      NewLocation(number("end_line", line)-1, number("end_col", col)-1),
+			Message:  lua.LVAsString(t.RawGetString("message")),
+		}
+		for _, s := range []DiagnosticSeverity{DiagnosticError, DiagnosticWarning, DiagnosticInfo, DiagnosticHint} {
+			if lua.LVAsString(t.RawGetString("severity")) == s.String() {
+				d.Severity = s
+			}
+		}
+		diags = append(diags, d)
+	})
+	setDiagnostics(b.Path, source, diags)
+	return 0
+}
+
+func luaBufferModified(L *lua.LState) int {
+	L.Push(lua.LBool(checkBuffer(L, 1).Modified))
+	return 1
+}
+
+func luaBufferSave(L *lua.LState) int {
+	checkBuffer(L, 1).Save()
+	return 0
+}
+
+func luaBufferModes(L *lua.LState) int {
+	L.Push(luaFromGo(L, checkBuffer(L, 1).Modes))
+	return 1
+}
+
+func luaBufferAddMode(L *lua.LState) int {
+	b := checkBuffer(L, 1)
+	name := L.CheckString(2)
+	if findMode(name) == nil {
+		addMode(name)
+	}
+	b.AddMode(name)
+	return 0
+}
+
+func luaBufferRemoveMode(L *lua.LState) int {
+	checkBuffer(L, 1).RemoveMode(L.CheckString(2))
+	return 0
+}
+
+// buf:option(name) gets the value of an option as seen from the buffer
+func luaBufferOption(L *lua.LState) int {
+	b := checkBuffer(L, 1)
+	L.Push(luaFromGo(L, configValue(L.CheckString(2), b)))
+	return 1
+}
+
+func luaBufferSetOption(L *lua.LState) int {
+	b := checkBuffer(L, 1)
+	value := luaToGo(L.CheckAny(3))
+	if value == nil {
+		L.ArgError(3, "string, number or boolean expected")
+	}
+	if err := b.SetOption(L.CheckString(2), value); err != nil {
+		L.RaiseError("%s", err.Error())
+	}
+	return 0
+}
+
+func luaViewBuffer(L *lua.LState) int {
+	L.Push(luaBuffer(L, checkView(L, 1).Buf))
+	return 1
+}
+
+// view:cursor(line?, col?) works like buf:cursor() on the view's buffer
+func luaViewCursor(L *lua.LState) int {
+	v := checkView(L, 1)
+	L.Replace(1, luaBuffer(L, v.Buf))
+	return luaBufferCursor(L)
+}
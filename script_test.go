@@ -0,0 +1,89 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestScriptBindAndCommand(t *testing.T) {
+	h := newHeadless(80, 24, nil)
+	scriptRun(`
+		ry.set("tab_width", 2)
+		ry.bind("normal", "SPC t", function(buf, keys)
+			buf:insert("bound " .. keys)
+		end)
+		ry.add_command("greet", function(args)
+			ry.message("hello " .. table.concat(args, ","))
+		end)
+		modified = 0
+		ry.hook("modified", function(buf) modified = modified + 1 end)
+	`)
+	if editorMessageType == "error" {
+		t.Fatal(editorMessage)
+	}
+	if configGetNumber("tab_width", nil) != 2 {
+		t.Fatal("expected ry.set to change the config")
+	}
+	h.Keys("SPC t")
+	expectContents(t, h, "bound SPC t\n")
+	h.Keys(": g r e e t SPC a SPC b RET")
+	if h.Message() != "hello a,b" {
+		t.Fatalf("unexpected message %q", h.Message())
+	}
+	if n := luaState.GetGlobal("modified"); n.String() != "1" {
+		t.Fatalf("expected the hook to run once, ran %s times", n)
+	}
+}
+
+func TestScriptBufferAPI(t *testing.T) {
+	h := newHeadless(80, 24, []string{tempFile(t, "one\ntwo\nthree\n")})
+	scriptRun(`
+		local buf = ry.buffer()
+		buf:set(2, "TWO")
+		buf:append(3, "four", "five")
+		buf:append(0, "zero")
+		buf:delete(2)
+		buf:cursor(2, 3)
+		count = buf:count()
+		first, second = buf:get(1, 2)
+		line, col = ry.view():cursor()
+	`)
+	if editorMessageType == "error" {
+		t.Fatal(editorMessage)
+	}
+	expectContents(t, h, "zero\nTWO\nthree\nfour\nfive\n")
+	expectCursor(t, h, 1, 2)
+	for name, expected := range map[string]string{
+		"count": "5", "first": "zero", "second": "TWO", "line": "2", "col": "3",
+	} {
+		if v := luaState.GetGlobal(name).String(); v != expected {
+			t.Errorf("expected %s to be %s, got %s", name, expected, v)
+		}
+	}
+
+	for _, call := range []string{"get", "delete"} {
+		scriptRun("ry.buffer():" + call + "(3, 1)")
+		if editorMessageType != "error" || !strings.Contains(editorMessage, "line before from") {
+			t.Fatalf("expected reversed lines to fail in %s, got %q", call, editorMessage)
+		}
+	}
+
+	scriptRun(`ry.buffer():delete(4, 5)`)
+	expectContents(t, h, "zero\nTWO\nthree\n")
+	h.Keys("u")
+	expectContents(t, h, "zero\nTWO\nthree\nfour\nfive\n")
+}
+
+func TestScriptErrors(t *testing.T) {
+	newHeadless(80, 24, nil)
+	scriptRun(`ry.bind("nope", "x", function() end)`)
+	if editorMessageType != "error" {
+		t.Fatal("expected binding in an unknown mode to fail")
+	}
+	scriptRun(`ry.add_command("boom", function() error("boom") end)`)
+	message("")
+	runCommand([]string{"boom"})
+	if editorMessageType != "error" {
+		t.Fatal("expected errors in commands to be reported")
+	}
+}
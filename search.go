@@ -2,7 +2,7 @@ package main
 
 import (
 	"regexp"
-	"strings"
+	"unicode/utf8"
 )
 
 var (
@@ -14,15 +14,20 @@ var (
 )
 
 func init_search() {
-	bind("normal", k("/"), handle_search_start)
-	bind("normal", k("N"), handle_search_prev)
-	bind("normal", k("n"), handle_search_next)
-	bind("normal", k("*"), handle_search_search_work_under_cursor)
-	bind("normal", k("SPC n"), func(vt *ViewTree, b *Buffer, kl *KeyList) {
+	last_search_buffer = nil
+	last_search = ""
+	last_search_highlight = false
+	last_search_results = []*Location{}
+
+	bindDesc("normal", k("/"), "Search", handle_search_start)
+	bindDesc("normal", k("N"), "Previous match", handle_search_prev)
+	bindDesc("normal", k("n"), "Next match", handle_search_next)
+	bindDesc("normal", k("*"), "Search word", handle_search_search_work_under_cursor)
+	bindDesc("normal", k("$leader n"), "Clear search", func(vt *ViewTree, b *Buffer, kl *KeyList) {
 		search_clear()
 	})
 
-	addCommand("clearsearch", func(args []string) {
+	addCommandDesc("clearsearch", "Hides search result highlights", func(args []string) {
 		search_clear()
 	})
 	addAlias("cs", "clearsearch")
@@ -48,9 +53,11 @@ func search_find_matches(b *Buffer, search string) {
 	last_search_buffer = b
 	last_search_results = []*Location{}
 	for i, line := range b.Data {
-		idxs := re.FindAllStringIndex(string(line), -1)
-		for _, idx := range idxs {
-			last_search_results = append(last_search_results, NewLocation(i, idx[0]))
+		s := string(line)
+		for _, idx := range re.FindAllStringIndex(s, -1) {
+			// Byte offsets to runes
+			c := utf8.RuneCountInString(s[:idx[0]])
+			last_search_results = append(last_search_results, NewLocation(i, c))
 		}
 	}
 }
@@ -66,8 +73,8 @@ func search_start(b *Buffer, search string) {
 }
 
 func handle_search_start(vt *ViewTree, b *Buffer, kl *KeyList) {
-	prompt("/", noopComplete, func(args []string) {
-		search_start(b, strings.Join(args, " "))
+	prompt("/", noopComplete, func(value string) {
+		search_start(b, value)
 	})
 }
 
@@ -122,7 +129,7 @@ func search_highlight(b *Buffer, l, c int) int {
 	}
 	for _, loc := range last_search_results {
 		if l == loc.Line && c == loc.Char {
-			return len(last_search)
+			return utf8.RuneCountInString(last_search)
 		}
 	}
 	return 0
@@ -0,0 +1,653 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gdamore/tcell"
+)
+
+// Snippets expand a prefix typed in insert mode into a template when TAB
+// is pressed after it. Templates use the TextMate syntax VS Code reads
+// too: $1, ${2:default} and ${3|one,two|} are tabstops visited in order
+// with TAB and BTAB, $0 is where the cursor ends up, and variables like
+// $TM_FILENAME are replaced by their value. A tabstop used more than once
+// is mirrored, its copies following what's typed in the first one.
+//
+// The whole expansion, with what's typed in its placeholders, is undone
+// in one step.
+//
+// Snippets are defined by filetype, in the VS Code JSON format, in
+// ~/.config/ry/snippets/<filetype>.json, all.json holding those for every
+// filetype:
+//
+//   {
+//     "Printf": {"prefix": "pf", "body": "fmt.Printf(\"${1:%v}\\n\", $2)"}
+//   }
+
+type snippet struct {
+	Name        string
+	Prefix      string
+	Body        string
+	Description string
+}
+
+// Parsed body: text, or a tabstop with its default
+type snippetNode struct {
+	Text     string
+	Stop     int // -1 for text
+	Children []*snippetNode
+}
+
+type snippetRange struct {
+	Beg, End *Location
+}
+
+type snippetStop struct {
+	N      int
+	Ranges []*snippetRange // the first one is typed in, others mirror it
+}
+
+type snippetSession struct {
+	Buf     *Buffer
+	Stops   []*snippetStop // by number, $0 last
+	Current int
+	fresh   bool            // what's typed replaces the current placeholder
+	ranges  []*snippetRange // all of them, in the order of the buffer
+	target  *snippetRange   // range text is being inserted in, growing it
+}
+
+var (
+	snippetCache  = map[string][]snippet{}
+	editorSnippet *snippetSession
+)
+
+var builtinSnippets = map[string][]snippet{
+	"go": {
+		{
+			Name:        "iferr",
+			Prefix:      "iferr",
+			Body:        "if err != nil {\n\treturn ${1:err}\n}$0",
+			Description: "Return on error",
+		},
+		{
+			Name:   "test",
+			Prefix: "test",
+			Body: "func Test${1:Name}(t *testing.T) {\n" +
+				"\ttests := []struct {\n\t\tname string\n\t\t${2:input string}\n\t\twant ${3:string}\n\t}{\n" +
+				"\t\t{name: \"${4:simple}\"},\n\t}\n" +
+				"\tfor _, tt := range tests {\n\t\tt.Run(tt.name, func(t *testing.T) {\n\t\t\t$0\n\t\t})\n\t}\n}",
+			Description: "Table driven test",
+		},
+		{
+			Name:        "handler",
+			Prefix:      "handler",
+			Body:        "func ${1:handle}(w http.ResponseWriter, r *http.Request) {\n\t$0\n}",
+			Description: "HTTP handler",
+		},
+	},
+}
+
+func initSnippets() {
+	snippetCache = map[string][]snippet{}
+	editorSnippet = nil
+
+	hook_buffer("saved", func(b *Buffer) {
+		if strings.HasPrefix(b.Path, filepath.Join(configDir(), "snippets")) {
+			snippetCache = map[string][]snippet{}
+		}
+	})
+	hook_buffer("modified", syncSnippetMirrors)
+	onBufferChange(snippetChange)
+
+	addCompletionSource("snippets", 15, completeSnippets)
+
+	bindDesc("insert", k("TAB"), "Expand snippet, or indent", func(vt *ViewTree, b *Buffer, kl *KeyList) {
+		if !expandSnippet(b) {
+			insert(vt, b, kl)
+		}
+	})
+
+	addMode("snippet")
+	bindDesc("snippet", k("TAB"), "Next placeholder", func(vt *ViewTree, b *Buffer, kl *KeyList) {
+		selectSnippetStop(editorSnippet.Current + 1)
+	})
+	bindDesc("snippet", k("BTAB"), "Previous placeholder", func(vt *ViewTree, b *Buffer, kl *KeyList) {
+		selectSnippetStop(max(editorSnippet.Current-1, 0))
+	})
+	for _, key := range []string{"ESC", "C-c"} {
+		bind("snippet", k(key), func(vt *ViewTree, b *Buffer, kl *KeyList) {
+			endSnippet()
+			enterNormalMode(vt, b, kl)
+		})
+	}
+
+	// Active while the placeholder jumped to wasn't changed: typing or
+	// deleting replaces it, other keys keep it
+	addMode("placeholder")
+	bind("placeholder", k("$any"), func(vt *ViewTree, b *Buffer, kl *KeyList) {
+		s := editorSnippet
+		s.fresh = false
+		key := kl.keys[len(kl.keys)-1]
+		if key.Key == tcell.KeyRune && key.Mod == 0 || key.Key == tcell.KeyBackspace2 {
+			r := s.Stops[s.Current].Ranges[0]
+			b.RemoveAt(r.Beg, runesBetween(b, r.Beg, r.End))
+			b.MoveTo(r.Beg.Char, r.Beg.Line)
+			if key.Key == tcell.KeyBackspace2 {
+				return
+			}
+		}
+		keysEntered.keys = append(keysEntered.keys, kl.keys...)
+	})
+}
+
+// Snippets for filetype, the user's first
+func snippetsFor(filetype string) []snippet {
+	if snippets, ok := snippetCache[filetype]; ok {
+		return snippets
+	}
+	dir := filepath.Join(configDir(), "snippets")
+	snippets := []snippet{}
+	if filetype != "" {
+		snippets = append(snippets, loadSnippets(filepath.Join(dir, filetype+".json"))...)
+	}
+	snippets = append(snippets, loadSnippets(filepath.Join(dir, "all.json"))...)
+	snippets = append(snippets, builtinSnippets[filetype]...)
+	snippetCache[filetype] = snippets
+	return snippets
+}
+
+// Reads a file of snippets in the VS Code format, where prefix and body
+// can be a string or a list of them, body lines
+func loadSnippets(path string) []snippet {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	var defs map[string]struct {
+		Prefix      json.RawMessage
+		Body        json.RawMessage
+		Description string
+	}
+	if err == nil {
+		err = json.Unmarshal(data, &defs)
+	}
+	if err != nil {
+		messageError("Can't read " + path + ": " + err.Error())
+		return nil
+	}
+	snippets := []snippet{}
+	for name, def := range defs {
+		for _, prefix := range jsonStrings(def.Prefix) {
+			snippets = append(snippets, snippet{
+				Name:        name,
+				Prefix:      prefix,
+				Body:        strings.Join(jsonStrings(def.Body), "\n"),
+				Description: def.Description,
+			})
+		}
+	}
+	sort.Slice(snippets, func(i, j int) bool {
+		return snippets[i].Prefix < snippets[j].Prefix
+	})
+	return snippets
+}
+
+func jsonStrings(data json.RawMessage) []string {
+	var list []string
+	if json.Unmarshal(data, &list) == nil {
+		return list
+	}
+	var s string
+	if json.Unmarshal(data, &s) == nil {
+		return []string{s}
+	}
+	return nil
+}
+
+// Snippet whose prefix ends the text before the cursor, the longest one
+// when several do, and where the prefix starts
+func snippetBeforeCursor(b *Buffer) (*snippet, *Location) {
+	line := b.Data[b.Cursor.Line][:b.Cursor.Char]
+	var found *snippet
+	beg := 0
+	snippets := snippetsFor(configGet("filetype", b))
+	for i, sn := range snippets {
+		prefix := []rune(sn.Prefix)
+		start := len(line) - len(prefix)
+		if len(prefix) == 0 || start < 0 || string(line[start:]) != sn.Prefix {
+			continue
+		}
+		// Prefixes of words don't match the end of longer ones
+		if start > 0 && isWord(line[start-1]) && isWord(prefix[0]) {
+			continue
+		}
+		if found == nil || len(prefix) > len([]rune(found.Prefix)) {
+			found, beg = &snippets[i], start
+		}
+	}
+	return found, NewLocation(b.Cursor.Line, beg)
+}
+
+// Replaces the snippet prefix before the cursor with its body, going to
+// its first tabstop. Tells if there was one.
+func expandSnippet(b *Buffer) bool {
+	sn, beg := snippetBeforeCursor(b)
+	if sn == nil {
+		return false
+	}
+	endSnippet()
+
+	line := b.Data[b.Cursor.Line]
+	indent := 0
+	for indent < len(line) && isSpace(line[indent]) {
+		indent++
+	}
+	tab := "\t"
+	if configGetBool("tab_to_spaces", b) {
+		tab = strings.Repeat(" ", int(configGetNumber("tab_width", b)))
+	}
+	nodes, _ := parseSnippet([]rune(sn.Body), 0, false, snippetVariables(b))
+	text, stops, ranges := renderSnippet(nodes, beg, string(line[:indent]), tab)
+
+	b.BeginUndoGroup()
+	b.RemoveAt(beg, b.Cursor.Char-beg.Char)
+	b.InsertAt(beg, text)
+	editorSnippet = &snippetSession{Buf: b, Stops: stops, ranges: ranges}
+	selectSnippetStop(0)
+	return true
+}
+
+// Values of the variables snippets can use
+func snippetVariables(b *Buffer) map[string]string {
+	now := time.Now()
+	path := b.Path
+	if path == "" {
+		path = b.Name
+	}
+	return map[string]string{
+		"TM_FILENAME":      filepath.Base(path),
+		"TM_FILENAME_BASE": strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)),
+		"TM_DIRECTORY":     filepath.Dir(path),
+		"TM_FILEPATH":      path,
+		"TM_LINE_INDEX":    strconv.Itoa(b.Cursor.Line),
+		"TM_LINE_NUMBER":   strconv.Itoa(b.Cursor.Line + 1),
+		"TM_SELECTED_TEXT": "",
+		"CURRENT_YEAR":     now.Format("2006"),
+		"CURRENT_MONTH":    now.Format("01"),
+		"CURRENT_DATE":     now.Format("02"),
+	}
+}
+
+// Parses a body from i, up to the } closing a placeholder when nested.
+// Variables are replaced by their value in vars, or their default.
+func parseSnippet(body []rune, i int, nested bool, vars map[string]string) ([]*snippetNode, int) {
+	nodes := []*snippetNode{}
+	text := []rune{}
+	flush := func() {
+		if len(text) > 0 {
+			nodes = append(nodes, &snippetNode{Text: string(text), Stop: -1})
+			text = []rune{}
+		}
+	}
+	for i < len(body) {
+		r := body[i]
+		if r == '\\' && i+1 < len(body) && strings.ContainsRune("$}\\", body[i+1]) {
+			text = append(text, body[i+1])
+			i += 2
+			continue
+		}
+		if r == '}' && nested {
+			flush()
+			return nodes, i + 1
+		}
+		if r != '$' || i+1 == len(body) {
+			text = append(text, r)
+			i++
+			continue
+		}
+
+		j := i + 1
+		braced := body[j] == '{'
+		if braced {
+			j++
+		}
+		end := j
+		for end < len(body) && isWord(body[end]) {
+			end++
+		}
+		name := string(body[j:end])
+		n, err := strconv.Atoi(name)
+		isStop := err == nil
+		var children []*snippetNode
+		if name == "" {
+			text = append(text, r)
+			i++
+			continue
+		}
+		if braced {
+			if end < len(body) && body[end] == ':' {
+				children, end = parseSnippet(body, end+1, true, vars)
+			} else if choices := strings.Index(string(body[end:]), "|}"); isStop && end < len(body) && body[end] == '|' && choices > 0 {
+				// Choices go by the first one
+				choice := strings.Split(string(body[end+1:end+choices]), ",")[0]
+				children = []*snippetNode{{Text: choice, Stop: -1}}
+				end += choices + 2
+			} else if end < len(body) && body[end] == '}' {
+				end++
+			} else {
+				text = append(text, r)
+				i++
+				continue
+			}
+		}
+		flush()
+		if isStop {
+			nodes = append(nodes, &snippetNode{Stop: n, Children: children})
+		} else if value, ok := vars[name]; ok {
+			nodes = append(nodes, &snippetNode{Text: value, Stop: -1})
+		} else {
+			nodes = append(nodes, children...)
+		}
+		i = end
+	}
+	flush()
+	return nodes, i
+}
+
+// Text of parsed nodes inserted at loc, with its tabstops. Lines after the
+// first are indented with indent, and tabs written as tab.
+func renderSnippet(nodes []*snippetNode, loc *Location, indent, tab string) ([]rune, []*snippetStop, []*snippetRange) {
+	w := &snippetWriter{
+		loc:       loc.Clone(),
+		indent:    indent,
+		tab:       tab,
+		primaries: map[int]*snippetNode{},
+		stops:     map[int]*snippetStop{},
+	}
+	w.findPrimaries(nodes)
+	w.write(nodes, false)
+
+	stops := []*snippetStop{}
+	for _, stop := range w.stops {
+		stops = append(stops, stop)
+	}
+	if w.stops[0] == nil {
+		// Without a $0, the cursor ends up after the text
+		r := &snippetRange{Beg: w.loc.Clone(), End: w.loc.Clone()}
+		w.ranges = append(w.ranges, r)
+		stops = append(stops, &snippetStop{N: 0, Ranges: []*snippetRange{r}})
+	}
+	sort.Slice(stops, func(i, j int) bool {
+		return stops[j].N == 0 || stops[i].N != 0 && stops[i].N < stops[j].N
+	})
+	return w.text, stops, w.ranges
+}
+
+type snippetWriter struct {
+	text      []rune
+	loc       *Location // where the next rune goes
+	indent    string
+	tab       string
+	primaries map[int]*snippetNode // tabstops typed in, others mirror them
+	stops     map[int]*snippetStop
+	ranges    []*snippetRange
+}
+
+// Picks the first occurrence of each tabstop with a default, else the
+// first one, as the one typed in
+func (w *snippetWriter) findPrimaries(nodes []*snippetNode) {
+	for _, n := range nodes {
+		if n.Stop < 0 {
+			continue
+		}
+		if p := w.primaries[n.Stop]; p == nil || len(p.Children) == 0 && len(n.Children) > 0 {
+			w.primaries[n.Stop] = n
+		}
+		w.findPrimaries(n.Children)
+	}
+}
+
+// Writes nodes, mirrors taking the default of the tabstop they copy.
+// Tabstops written as part of a mirror aren't kept, being plain text.
+func (w *snippetWriter) write(nodes []*snippetNode, plain bool) {
+	for _, n := range nodes {
+		if n.Stop < 0 {
+			w.writeText(n.Text)
+			continue
+		}
+		if plain {
+			w.write(n.Children, true)
+			continue
+		}
+		primary := w.primaries[n.Stop]
+		r := &snippetRange{Beg: w.loc.Clone()}
+		w.ranges = append(w.ranges, r)
+		w.write(primary.Children, n != primary)
+		r.End = w.loc.Clone()
+
+		stop := w.stops[n.Stop]
+		if stop == nil {
+			stop = &snippetStop{N: n.Stop}
+			w.stops[n.Stop] = stop
+		}
+		if n == primary {
+			stop.Ranges = append([]*snippetRange{r}, stop.Ranges...)
+		} else {
+			stop.Ranges = append(stop.Ranges, r)
+		}
+	}
+}
+
+func (w *snippetWriter) writeText(text string) {
+	for _, r := range text {
+		if r == '\t' {
+			w.text = append(w.text, []rune(w.tab)...)
+			w.loc.Char += len([]rune(w.tab))
+		} else if r == '\n' {
+			w.text = append(append(w.text, '\n'), []rune(w.indent)...)
+			w.loc = NewLocation(w.loc.Line+1, len([]rune(w.indent)))
+		} else {
+			w.text = append(w.text, r)
+			w.loc.Char++
+		}
+	}
+}
+
+// Goes to the tabstop at index i, ending the session on $0
+func selectSnippetStop(i int) {
+	s := editorSnippet
+	s.Current = i
+	r := s.Stops[i].Ranges[0]
+	s.Buf.MoveTo(r.End.Char, r.End.Line)
+	s.fresh = !r.Beg.Equal(r.End)
+	if s.Stops[i].N == 0 {
+		endSnippet()
+		return
+	}
+	highlight_buffer(s.Buf)
+}
+
+func endSnippet() {
+	if s := editorSnippet; s != nil {
+		editorSnippet = nil
+		s.Buf.EndUndoGroup()
+		highlight_buffer(s.Buf)
+	}
+}
+
+// Ends the session once out of insert mode or of its buffer
+func checkSnippet() {
+	if editorMode != "insert" || currentViewTree.Leaf.Buf != editorSnippet.Buf {
+		endSnippet()
+	}
+}
+
+// Moves tabstops with the changes made around and in them
+func snippetChange(b *Buffer, typ ActionType, loc *Location, data []rune) {
+	s := editorSnippet
+	if s == nil || b != s.Buf {
+		return
+	}
+	lines, last := changeSize(data)
+
+	if typ != ActionTypeInsert {
+		end := NewLocation(loc.Line+lines, last)
+		if lines == 0 {
+			end.Char += loc.Char
+		}
+		for _, r := range s.ranges {
+			shiftRemove(r.Beg, loc, end, lines)
+			shiftRemove(r.End, loc, end, lines)
+		}
+		return
+	}
+
+	// Text typed at the end of the current placeholder goes in it, as well
+	// as text inserted while syncing a mirror
+	target := s.target
+	if primary := s.Stops[s.Current].Ranges[0]; target == nil && primary.Beg.Before(loc) && loc.Before(primary.End) {
+		target = primary
+	}
+	// Ranges starting where text is inserted move with it when they come
+	// after the target, or without a target
+	moving := target == nil
+	for _, r := range s.ranges {
+		if r == target {
+			if !r.Beg.Equal(loc) && loc.Before(r.Beg) {
+				shiftInsert(r.Beg, loc, lines, last)
+			}
+			shiftInsert(r.End, loc, lines, last)
+			moving = true
+			continue
+		}
+		shifted := false
+		if !r.Beg.Equal(loc) && loc.Before(r.Beg) || r.Beg.Equal(loc) && moving {
+			shiftInsert(r.Beg, loc, lines, last)
+			shifted = true
+		}
+		if !r.End.Equal(loc) && loc.Before(r.End) || r.End.Equal(loc) && shifted {
+			shiftInsert(r.End, loc, lines, last)
+		}
+	}
+}
+
+// Moves p, after loc, past lines and last runes inserted at loc
+func shiftInsert(p, loc *Location, lines, last int) {
+	if p.Line == loc.Line {
+		if lines == 0 {
+			p.Char += last
+		} else {
+			p.Char = last + p.Char - loc.Char
+		}
+	}
+	p.Line += lines
+}
+
+// Moves p back over the removal of loc to end, spanning lines
+func shiftRemove(p, loc, end *Location, lines int) {
+	if p.Before(loc) {
+		return
+	}
+	if p.Before(end) {
+		p.Line, p.Char = loc.Line, loc.Char
+	} else if p.Line == end.Line {
+		p.Line, p.Char = loc.Line, loc.Char+p.Char-end.Char
+	} else {
+		p.Line -= lines
+	}
+}
+
+// Copies what's in each tabstop to its mirrors, keeping the cursor where
+// it is in the current one
+func syncSnippetMirrors(b *Buffer) {
+	s := editorSnippet
+	if s == nil || b != s.Buf || s.target != nil {
+		return
+	}
+	primary := s.Stops[s.Current].Ranges[0]
+	inPrimary := primary.Beg.Before(b.Cursor) && b.Cursor.Before(primary.End)
+	line, char := b.Cursor.Line-primary.Beg.Line, b.Cursor.Char
+	if line == 0 {
+		char -= primary.Beg.Char
+	}
+
+	for _, stop := range s.Stops {
+		text := snippetRangeText(b, stop.Ranges[0])
+		for _, r := range stop.Ranges[1:] {
+			if snippetRangeText(b, r) == text {
+				continue
+			}
+			s.target = r
+			if n := runesBetween(b, r.Beg, r.End); n > 0 {
+				b.RemoveAt(r.Beg, n)
+			}
+			b.InsertAt(r.Beg, []rune(text))
+			s.target = nil
+		}
+	}
+
+	if inPrimary {
+		b.Cursor.Line = primary.Beg.Line + line
+		if line == 0 {
+			char += primary.Beg.Char
+		}
+		b.Cursor.Char = char
+	}
+}
+
+func snippetRangeText(b *Buffer, r *snippetRange) string {
+	text := []rune{}
+	for l := r.Beg.Line; l <= r.End.Line && l < len(b.Data); l++ {
+		beg, end := 0, len(b.Data[l])
+		if l == r.Beg.Line {
+			beg = min(r.Beg.Char, end)
+		}
+		if l == r.End.Line {
+			end = min(r.End.Char, end)
+		}
+		text = append(text, b.Data[l][beg:max(beg, end)]...)
+		if l < r.End.Line {
+			text = append(text, '\n')
+		}
+	}
+	return string(text)
+}
+
+// Tells if the rune at line l and char c is in the current placeholder
+func snippetHighlight(b *Buffer, l, c int) bool {
+	s := editorSnippet
+	if s == nil || s.Buf != b {
+		return false
+	}
+	loc := NewLocation(l, c)
+	for _, r := range s.Stops[s.Current].Ranges {
+		if r.Beg.Before(loc) && !loc.Equal(r.End) && loc.Before(r.End) {
+			return true
+		}
+	}
+	return false
+}
+
+// Completes snippet prefixes, TAB then expanding them
+func completeSnippets(req *completionRequest, reply func([]completionItem)) {
+	if req.Buf == nil {
+		reply(nil)
+		return
+	}
+	items := []completionItem{}
+	for _, sn := range snippetsFor(configGet("filetype", req.Buf)) {
+		detail := sn.Description
+		if detail == "" {
+			detail = sn.Name
+		}
+		items = append(items, completionItem{Label: sn.Prefix, Detail: detail})
+	}
+	reply(items)
+}
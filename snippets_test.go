@@ -0,0 +1,78 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRenderSnippet(t *testing.T) {
+	body := `for ${1:i} := 0; $1 < ${2|n,len(s)|}; $1++ {` + "\n\t$0\n} // \\$ $TM_FILENAME_BASE ${NOPE:none}"
+	nodes, _ := parseSnippet([]rune(body), 0, false, map[string]string{"TM_FILENAME_BASE": "main"})
+	text, stops, ranges := renderSnippet(nodes, NewLocation(3, 2), "  ", "    ")
+	if string(text) != "for i := 0; i < n; i++ {\n      \n  } // $ main none" {
+		t.Fatalf("unexpected text %q", string(text))
+	}
+	if len(stops) != 3 || stops[0].N != 1 || stops[1].N != 2 || stops[2].N != 0 || len(ranges) != 5 {
+		t.Fatalf("unexpected stops %+v", stops)
+	}
+	for i, expected := range []snippetRange{
+		{NewLocation(3, 6), NewLocation(3, 7)},
+		{NewLocation(3, 14), NewLocation(3, 15)},
+		{NewLocation(3, 21), NewLocation(3, 22)},
+	} {
+		if r := stops[0].Ranges[i]; !r.Beg.Equal(expected.Beg) || !r.End.Equal(expected.End) {
+			t.Fatalf("unexpected range %d %v-%v", i, r.Beg, r.End)
+		}
+	}
+	if r := stops[2].Ranges[0]; !r.Beg.Equal(NewLocation(4, 6)) || !r.End.Equal(r.Beg) {
+		t.Fatalf("unexpected $0 %v", r.Beg)
+	}
+}
+
+func TestSnippets(t *testing.T) {
+	dir := writeProject(t, map[string]string{
+		"main.go": "package main\n",
+		"ry/snippets/all.json": `{
+			"For loop": {"prefix": "for", "body": ["for ${1:i} := 0; $1 < ${2:n}; $1++ {", "\t$0", "}"]}
+		}`,
+	})
+	os.Setenv("XDG_CONFIG_HOME", dir)
+	defer os.Unsetenv("XDG_CONFIG_HOME")
+	h := newHeadless(80, 24, []string{filepath.Join(dir, "main.go")})
+
+	// Built-in snippets, placeholders replaced by what's typed
+	h.Keys("o TAB i f e r r TAB")
+	expectContents(t, h, "package main\n\tif err != nil {\n\t\treturn err\n\t}\n")
+	if editorMode != "insert" || style_maps[h.Buffer()][2][9] != style("snippet") {
+		t.Fatal("expected the placeholder highlighted")
+	}
+	h.Keys("f m t . E r r o r f ( ) TAB")
+	expectContents(t, h, "package main\n\tif err != nil {\n\t\treturn fmt.Errorf()\n\t}\n")
+	if editorSnippet != nil {
+		t.Fatal("expected the snippet done at $0")
+	}
+	if line, char := h.Cursor(); line != 3 || char != 2 {
+		t.Fatalf("expected the cursor at the end, got %d:%d", line, char)
+	}
+	h.Keys("ESC u")
+	expectContents(t, h, "package main\n\tiferr\n")
+
+	// Mirrors follow the placeholder typed in
+	h.Keys("G o f o r TAB j")
+	expectContents(t, h, "package main\n\tiferr\n\tfor j := 0; j < n; j++ {\n\t\t\n\t}\n")
+	h.Keys("BAK k TAB BAK 1 0 BTAB x")
+	expectContents(t, h, "package main\n\tiferr\n\tfor x := 0; x < 10; x++ {\n\t\t\n\t}\n")
+	h.Keys("TAB TAB y")
+	expectContents(t, h, "package main\n\tiferr\n\tfor x := 0; x < 10; x++ {\n\t\ty\n\t}\n")
+	h.Keys("ESC u u")
+	expectContents(t, h, "package main\n\tiferr\n\tfor\n")
+
+	// Prefixes complete, without one TAB indents
+	h.Keys("G o h a n C-n")
+	if len(editorCompletion.Items) != 1 || editorCompletion.Items[0].Detail != "HTTP handler" {
+		t.Fatalf("unexpected completions %+v", editorCompletion.Items)
+	}
+	h.Keys("C-y ESC o x TAB")
+	expectContents(t, h, "package main\n\tiferr\n\tfor\n\thandler\n\tx\t\n")
+}
@@ -32,6 +32,11 @@ func style(name string) tcell.Style {
 			Foreground(tcell.ColorWhite).
 			Background(tcell.Color(0))
 	}
+	if name == "snippet" {
+		return tcell.StyleDefault.
+			Foreground(tcell.ColorWhite).
+			Background(tcell.ColorNavy)
+	}
 	if name == "special" {
 		return tcell.StyleDefault.
 			Foreground(tcell.Color(0))
@@ -56,6 +61,37 @@ func style(name string) tcell.Style {
 		return tcell.StyleDefault.
 			Foreground(tcell.Color(6))
 	}
+	if name == "popup" {
+		return tcell.StyleDefault.
+			Foreground(tcell.ColorWhite).
+			Background(tcell.Color(0))
+	}
+	if name == "popup.selected" {
+		return tcell.StyleDefault.
+			Foreground(tcell.ColorBlack).
+			Background(tcell.Color(6))
+	}
+	if name == "popup.border" {
+		return tcell.StyleDefault.
+			Foreground(tcell.Color(6)).
+			Background(tcell.Color(0))
+	}
+	if name == "diagnostic.error" {
+		return tcell.StyleDefault.
+			Foreground(tcell.ColorRed)
+	}
+	if name == "diagnostic.warning" {
+		return tcell.StyleDefault.
+			Foreground(tcell.ColorOlive)
+	}
+	if name == "diagnostic.info" {
+		return tcell.StyleDefault.
+			Foreground(tcell.ColorNavy)
+	}
+	if name == "diagnostic.hint" {
+		return tcell.StyleDefault.
+			Foreground(tcell.Color(6))
+	}
 	if name == "cursor" {
 		return tcell.StyleDefault.Reverse(true)
 	}
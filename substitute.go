@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// :s/pattern/replacement/flags replaces pattern on the cursor line, or on
+// every line with :%s. pattern is a Go regexp and replacement can use $1
+// for groups. Flags are g, to replace every match of a line instead of
+// the first, and i to ignore case. Any character can take the place of /.
+
+func initSubstitute() {
+	addRawCommand("substitute", "Replaces a regexp on the current line, or all lines with %", func(args []string) {
+		substitute(currentViewTree.Leaf.Buf, args[1], commandWholeBuffer)
+	})
+	addAlias("s", "substitute")
+}
+
+// Splits "/pattern/replacement/flags" on its delimiter
+func parseSubstitute(arg string) (pattern, replacement, flags string, err error) {
+	delim, size := utf8.DecodeRuneInString(arg)
+	if arg == "" || isWord(delim) || delim == ' ' || delim == '\\' {
+		return "", "", "", errors.New("Usage: s/pattern/replacement/[gi]")
+	}
+	parts := []string{}
+	part := []rune{}
+	runes := []rune(arg[size:])
+	for i := 0; i < len(runes); i++ {
+		switch {
+		case runes[i] == '\\' && i+1 < len(runes) && runes[i+1] == delim:
+			part = append(part, delim)
+			i++
+		case runes[i] == delim && len(parts) < 2:
+			parts = append(parts, string(part))
+			part = []rune{}
+		default:
+			part = append(part, runes[i])
+		}
+	}
+	parts = append(parts, string(part))
+	for len(parts) < 3 {
+		parts = append(parts, "")
+	}
+	return parts[0], parts[1], parts[2], nil
+}
+
+func substitute(b *Buffer, arg string, whole bool) {
+	pattern, replacement, flags, err := parseSubstitute(arg)
+	if err != nil {
+		messageError(err.Error())
+		return
+	}
+	if pattern == "" {
+		if last_search == "" {
+			messageError("No previous search")
+			return
+		}
+		pattern = regexp.QuoteMeta(last_search)
+	}
+	if strings.Contains(flags, "i") {
+		pattern = "(?i)" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		messageError("Invalid pattern: " + err.Error())
+		return
+	}
+	all := strings.Contains(flags, "g")
+
+	from, to := b.Cursor.Line, b.Cursor.Line
+	if whole {
+		from, to = 0, len(b.Data)-1
+	}
+	count, lines, last := 0, 0, -1
+	b.BeginUndoGroup()
+	for l := from; l <= to; l++ {
+		// Only the text of grep hits, leaving where they are alone
+		start := 0
+		if b.IsInMode("grep") {
+			m := grepLineRegexp.FindStringSubmatchIndex(string(b.Data[l]))
+			if m == nil {
+				continue
+			}
+			start = utf8.RuneCountInString(string(b.Data[l])[:m[8]])
+		}
+		text := string(b.Data[l][start:])
+		replaced, n := substituteLine(re, text, replacement, all)
+		if n == 0 || replaced == text {
+			continue
+		}
+		b.RemoveAt(NewLocation(l, start), len(b.Data[l])-start)
+		b.InsertAt(NewLocation(l, start), []rune(replaced))
+		count += n
+		lines++
+		last = l
+	}
+	b.EndUndoGroup()
+
+	if count == 0 {
+		messageError("Pattern not found: " + pattern)
+		return
+	}
+	b.MoveTo(0, last)
+	message(strconv.Itoa(count) + " substitutions on " + strconv.Itoa(lines) + " lines")
+}
+
+// Replaces the first match of re in text, or all of them, returning the
+// number of replacements made
+func substituteLine(re *regexp.Regexp, text, replacement string, all bool) (string, int) {
+	matches := re.FindAllStringSubmatchIndex(text, -1)
+	if !all && len(matches) > 1 {
+		matches = matches[:1]
+	}
+	result := []byte{}
+	prev := 0
+	for _, m := range matches {
+		result = append(result, text[prev:m[0]]...)
+		result = re.ExpandString(result, replacement, text, m)
+		prev = m[1]
+	}
+	result = append(result, text[prev:]...)
+	return string(result), len(matches)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseSubstitute(t *testing.T) {
+	for _, c := range []struct{ arg, pattern, replacement, flags string }{
+		{"/foo/bar/g", "foo", "bar", "g"},
+		{"/foo/bar", "foo", "bar", ""},
+		{"#a/b#c\\#d#", "a/b", "c#d", ""},
+		{"/x", "x", "", ""},
+	} {
+		pattern, replacement, flags, err := parseSubstitute(c.arg)
+		if err != nil || pattern != c.pattern || replacement != c.replacement || flags != c.flags {
+			t.Errorf("%q: got %q %q %q %v", c.arg, pattern, replacement, flags, err)
+		}
+	}
+	if _, _, _, err := parseSubstitute("abc"); err == nil {
+		t.Error("expected an error for a word delimiter")
+	}
+}
+
+func TestSubstitute(t *testing.T) {
+	h := newHeadless(80, 24, nil)
+	h.Buffer().Insert([]rune("foo foo\nfoo bar\nFOO"))
+	h.Buffer().MoveTo(0, 0)
+	h.Keys(": s / / x / RET")
+	if h.Message() != "No previous search" || h.Contents() != "foo foo\nfoo bar\nFOO\n" {
+		t.Fatalf("expected no previous search, got %q", h.Message())
+	}
+	h.Keys(": s / f o o / b a z / RET")
+	if h.Contents() != "baz foo\nfoo bar\nFOO\n" {
+		t.Fatalf("unexpected contents %q", h.Contents())
+	}
+	h.Keys(": % s / ( f o ) o / $ { 1 } x / g i RET")
+	if h.Contents() != "baz fox\nfox bar\nFOx\n" {
+		t.Fatalf("unexpected contents %q", h.Contents())
+	}
+	if h.Message() != "3 substitutions on 3 lines" {
+		t.Fatalf("unexpected message %q", h.Message())
+	}
+	h.Keys("u")
+	if h.Contents() != "baz foo\nfoo bar\nFOO\n" {
+		t.Fatalf("expected :%%s undone in one step, got %q", h.Contents())
+	}
+	// An empty pattern is the last search
+	h.Keys("/ f o o RET : % s / / x / g RET")
+	if h.Contents() != "baz x\nx bar\nFOO\n" {
+		t.Fatalf("expected the search replaced, got %q", h.Contents())
+	}
+	h.Keys(": s / n o p e / RET")
+	if h.Message() != "Pattern not found: nope" {
+		t.Fatalf("unexpected message %q", h.Message())
+	}
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Tags files, as written by Universal Ctags (ctags -R), list where
+// symbols are defined. The closest one up from a buffer's directory
+// answers C-] and :tag, which go to the definition of a symbol, picking
+// between them when there are several. Jumps go on a stack C-t comes back
+// through.
+
+type tag struct {
+	Name    string
+	Path    string // absolute
+	Address string // line number or /pattern/
+	Kind    string
+}
+
+type tagsFile struct {
+	modTime time.Time
+	tags    []tag // by name
+}
+
+// Where a tag jump was made from
+type tagJump struct {
+	Buf  *Buffer
+	Path string
+	Loc  *Location
+}
+
+var (
+	tagsFiles = map[string]*tagsFile{}
+	tagStack  = []tagJump{}
+)
+
+func initTags() {
+	tagsFiles = map[string]*tagsFile{}
+	tagStack = []tagJump{}
+
+	bindDesc("normal", k("C-]"), "Jump to tag under cursor", func(vt *ViewTree, b *Buffer, kl *KeyList) {
+		word := string(b.WordUnderCursor())
+		if word == "" {
+			messageError("No word under cursor")
+			return
+		}
+		jumpToTag(b, word)
+	})
+	bindDesc("normal", k("C-t"), "Back from tag", func(vt *ViewTree, b *Buffer, kl *KeyList) {
+		popTag()
+	})
+
+	addCommandDesc("tag", "Jumps to the definition of a symbol from the tags file", func(args []string) {
+		if len(args) != 2 {
+			messageError("Usage: tag <name>")
+			return
+		}
+		jumpToTag(currentViewTree.Leaf.Buf, args[1])
+	})
+	addCommandCompletion("tag", func(prefix string) []string {
+		names := []string{}
+		for _, t := range bufferTags(currentViewTree.Leaf.Buf) {
+			if len(names) == 0 || names[len(names)-1] != t.Name {
+				names = append(names, t.Name)
+			}
+		}
+		return completeFrom(prefix, names)
+	})
+	addCommandDesc("pop", "Goes back to where the last tag jump was made from", func(args []string) {
+		popTag()
+	})
+}
+
+// Closest tags file up from b's directory, or the working directory
+func findTagsFile(b *Buffer) string {
+	dir, _ := os.Getwd()
+	if b != nil && b.Path != "" {
+		dir = filepath.Dir(b.Path)
+		if info, err := os.Stat(b.Path); err == nil && info.IsDir() {
+			dir = b.Path
+		}
+	}
+	for d := dir; ; d = filepath.Dir(d) {
+		path := filepath.Join(d, "tags")
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path
+		}
+		if filepath.Dir(d) == d {
+			return ""
+		}
+	}
+}
+
+// Tags of the tags file for b, read again when it changed
+func bufferTags(b *Buffer) []tag {
+	path := findTagsFile(b)
+	if path == "" {
+		return nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil
+	}
+	if f := tagsFiles[path]; f != nil && f.modTime.Equal(info.ModTime()) {
+		return f.tags
+	}
+	tags, err := readTags(path)
+	if err != nil {
+		messageError("Can't read " + path + ": " + err.Error())
+		return nil
+	}
+	tagsFiles[path] = &tagsFile{modTime: info.ModTime(), tags: tags}
+	return tags
+}
+
+// Reads a tags file: lines of name, file and address separated by tabs,
+// then ;" and extension fields, the first one being the kind
+func readTags(path string) ([]tag, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	dir := filepath.Dir(path)
+	tags := []tag{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "!_TAG_") {
+			continue
+		}
+		fields := strings.SplitN(line, "\t", 3)
+		if len(fields) < 3 {
+			continue
+		}
+		t := tag{Name: fields[0], Path: fields[1], Address: fields[2]}
+		if i := strings.LastIndex(t.Address, ";\"\t"); i >= 0 {
+			extra := strings.Split(t.Address[i+3:], "\t")
+			t.Address = t.Address[:i]
+			if !strings.Contains(extra[0], ":") {
+				t.Kind = extra[0]
+			} else if strings.HasPrefix(extra[0], "kind:") {
+				t.Kind = strings.TrimPrefix(extra[0], "kind:")
+			}
+		}
+		t.Address = strings.TrimSuffix(t.Address, ";\"")
+		if !filepath.IsAbs(t.Path) {
+			t.Path = filepath.Join(dir, t.Path)
+		}
+		tags = append(tags, t)
+	}
+	sort.SliceStable(tags, func(i, j int) bool {
+		return tags[i].Name < tags[j].Name
+	})
+	return tags, scanner.Err()
+}
+
+// Tags named name in b's tags file
+func findTags(b *Buffer, name string) []tag {
+	tags := bufferTags(b)
+	i := sort.Search(len(tags), func(i int) bool {
+		return tags[i].Name >= name
+	})
+	j := i
+	for j < len(tags) && tags[j].Name == name {
+		j++
+	}
+	return tags[i:j]
+}
+
+// Goes to the definition of name, or lets the user pick one when there
+// are several
+func jumpToTag(b *Buffer, name string) {
+	tags := findTags(b, name)
+	switch len(tags) {
+	case 0:
+		messageError("Tag not found: " + name)
+	case 1:
+		pushTag(b)
+		goToTag(tags[0])
+	default:
+		root := filepath.Dir(findTagsFile(b))
+		byItem := map[string]tag{}
+		items := []string{}
+		for _, t := range tags {
+			item, _ := filepath.Rel(root, t.Path)
+			if t.Kind != "" {
+				item += " (" + t.Kind + ")"
+			}
+			if pattern := tagPattern(t.Address); pattern != "" {
+				item += "  " + strings.TrimSpace(pattern)
+			} else {
+				item += ":" + t.Address
+			}
+			if _, ok := byItem[item]; !ok {
+				items = append(items, item)
+			}
+			byItem[item] = t
+		}
+		p := &Picker{Title: "tag " + name + ": ", Items: items}
+		p.Open = func(item, split string) {
+			pushTag(b)
+			if split != "" {
+				splitWindow(split == "vsplit")
+			}
+			goToTag(byItem[item])
+		}
+		openPicker(p, "")
+	}
+}
+
+// Opens t's file on the line its address finds
+func goToTag(t tag) {
+	nb := showFile(t.Path)
+	if nb == nil {
+		return
+	}
+	line := tagLine(nb, t.Address)
+	if line < 0 {
+		messageError("Tag " + t.Name + " not found in " + nb.Name)
+		return
+	}
+	// On the name when it's on the line
+	char := strings.Index(string(nb.Data[line]), t.Name)
+	if char < 0 {
+		char = 0
+	} else {
+		char = len([]rune(string(nb.Data[line])[:char]))
+	}
+	nb.MoveTo(char, line)
+	currentViewTree.Leaf.CenterPending = true
+}
+
+// Pattern of a /pattern/ or ?pattern? address, unescaped, "" for others
+func tagPattern(address string) string {
+	if len(address) < 2 || address[0] != '/' && address[0] != '?' || address[len(address)-1] != address[0] {
+		return ""
+	}
+	pattern := address[1 : len(address)-1]
+	pattern = strings.TrimPrefix(pattern, "^")
+	pattern = strings.TrimSuffix(pattern, "$")
+	r := strings.NewReplacer(`\/`, "/", `\?`, "?", `\\`, `\`)
+	return r.Replace(pattern)
+}
+
+// Line an address points to in b, -1 when the pattern isn't found.
+// Patterns are anchored at the start and end of lines when they begin
+// with ^ and end with $.
+func tagLine(b *Buffer, address string) int {
+	if n, err := strconv.Atoi(address); err == nil {
+		return max(min(n-1, len(b.Data)-1), 0)
+	}
+	pattern := tagPattern(address)
+	if pattern == "" {
+		return -1
+	}
+	body := address[1 : len(address)-1]
+	start := strings.HasPrefix(body, "^")
+	end := strings.HasSuffix(body, "$") && !strings.HasSuffix(body, `\$`)
+	for l, line := range b.Data {
+		s := string(line)
+		if start && end && s == pattern || start && !end && strings.HasPrefix(s, pattern) ||
+			!start && end && strings.HasSuffix(s, pattern) || !start && !end && strings.Contains(s, pattern) {
+			return l
+		}
+	}
+	return -1
+}
+
+func pushTag(b *Buffer) {
+	tagStack = append(tagStack, tagJump{Buf: b, Path: b.Path, Loc: b.Cursor.Clone()})
+}
+
+// Goes back to where the last tag jump was made from
+func popTag() {
+	if len(tagStack) == 0 {
+		messageError("Tag stack empty")
+		return
+	}
+	jump := tagStack[len(tagStack)-1]
+	tagStack = tagStack[:len(tagStack)-1]
+	var b *Buffer
+	if bufferIsOpen(jump.Buf) {
+		b = showBuffer(jump.Buf.Name)
+	} else if jump.Path != "" {
+		b = showFile(jump.Path)
+	}
+	if b != nil {
+		b.MoveTo(jump.Loc.Char, jump.Loc.Line)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestTags(t *testing.T) {
+	dir := writeProject(t, map[string]string{
+		"tags": "!_TAG_FILE_FORMAT\t2\t/extended format/\n" +
+			"Open\tstore/store.go\t/^func (s *Store) Open() error {$/;\"\tf\n" +
+			"Open\tstore/file.go\t/^func Open(path string) \\/* file *\\/ {$/;\"\tkind:function\n" +
+			"Store\tstore/store.go\t3;\"\tt\n" +
+			"main\tmain.go\t/^func main() {$/;\"\tf\n",
+		"main.go":        "package main\n\nfunc main() {\n\ts := Store{}\n\ts.Open()\n}\n",
+		"store/store.go": "package store\n\ntype Store struct{}\n\nfunc (s *Store) Open() error {\n\treturn nil\n}\n",
+		"store/file.go":  "package store\n\nfunc Open(path string) /* file */ {\n}\n",
+	})
+	h := newHeadless(80, 24, []string{filepath.Join(dir, "main.go")})
+
+	// C-] goes to the tag under the cursor, C-t back
+	h.Keys("j j j w w C-]")
+	if h.Buffer().Name != "store.go" {
+		t.Fatalf("expected store.go opened, got %s", h.Buffer().Name)
+	}
+	expectCursor(t, h, 2, 5)
+	h.Keys("C-t")
+	if h.Buffer().Name != "main.go" {
+		t.Fatalf("expected back to main.go, got %s", h.Buffer().Name)
+	}
+	expectCursor(t, h, 3, 6)
+
+	// Several tags of a name are picked from
+	h.Keys("j 0 l l l C-]")
+	if editorPicker == nil || !reflect.DeepEqual(editorPicker.Items, []string{
+		"store/store.go (f)  func (s *Store) Open() error {",
+		"store/file.go (function)  func Open(path string) /* file */ {",
+	}) {
+		t.Fatal("expected a picker of tags")
+	}
+	h.Keys("C-n RET")
+	if h.Buffer().Name != "file.go" {
+		t.Fatalf("expected file.go opened, got %s", h.Buffer().Name)
+	}
+	expectCursor(t, h, 2, 5)
+
+	// :tag completes names
+	h.Keys(": t a g SPC m TAB RET")
+	if h.Buffer().Name != "main.go" {
+		t.Fatalf("expected main.go opened, got %s", h.Buffer().Name)
+	}
+	expectCursor(t, h, 2, 5)
+	h.Keys("C-t")
+	if h.Buffer().Name != "file.go" {
+		t.Fatalf("expected back to file.go, got %s", h.Buffer().Name)
+	}
+	h.Keys(": t a g SPC n o p e RET")
+	if h.Message() != "Tag not found: nope" {
+		t.Fatalf("unexpected message %q", h.Message())
+	}
+	h.Keys("C-t C-t C-t")
+	if h.Message() != "Tag stack empty" || h.Buffer().Name != "main.go" {
+		t.Fatalf("expected back to main.go with the stack empty, got %s %q", h.Buffer().Name, h.Message())
+	}
+}
@@ -1,5 +1,7 @@
 package terminal
 
+import "fmt"
+
 // ANSI color values
 const (
 	Black Color = iota
@@ -20,6 +22,14 @@ const (
 	White
 )
 
+// Colors reported to programs querying the default foreground,
+// background and cursor colors (OSC 10, 11 and 12).
+var (
+	defaultFGColor     = LightGrey
+	defaultBGColor     = Black
+	defaultCursorColor = White
+)
+
 // Default colors are potentially distinct to allow for special behavior.
 // For example, a transparent background. Otherwise, the simple case is to
 // map default colors to another color.
@@ -35,3 +45,34 @@ type Color uint16
 func (c Color) ANSI() bool {
 	return (c < 16)
 }
+
+var ansiRGB = [16][3]uint8{
+	{0x00, 0x00, 0x00}, {0xcd, 0x00, 0x00}, {0x00, 0xcd, 0x00}, {0xcd, 0xcd, 0x00},
+	{0x00, 0x00, 0xee}, {0xcd, 0x00, 0xcd}, {0x00, 0xcd, 0xcd}, {0xe5, 0xe5, 0xe5},
+	{0x7f, 0x7f, 0x7f}, {0xff, 0x00, 0x00}, {0x00, 0xff, 0x00}, {0xff, 0xff, 0x00},
+	{0x5c, 0x5c, 0xff}, {0xff, 0x00, 0xff}, {0x00, 0xff, 0xff}, {0xff, 0xff, 0xff},
+}
+
+var cubeLevels = [6]uint8{0x00, 0x5f, 0x87, 0xaf, 0xd7, 0xff}
+
+// RGB returns the red, green and blue components of c in the xterm
+// palette.
+func (c Color) RGB() (r, g, b uint8) {
+	switch {
+	case c < 16:
+		return ansiRGB[c][0], ansiRGB[c][1], ansiRGB[c][2]
+	case c < 232:
+		i := c - 16
+		return cubeLevels[i/36], cubeLevels[i/6%6], cubeLevels[i%6]
+	case c < 256:
+		v := uint8(8 + 10*(c-232))
+		return v, v, v
+	}
+	return 0, 0, 0
+}
+
+// rgbSpec formats c the way xterm replies to color queries.
+func (c Color) rgbSpec() string {
+	r, g, b := c.RGB()
+	return fmt.Sprintf("rgb:%04x/%04x/%04x", uint16(r)*0x101, uint16(g)*0x101, uint16(b)*0x101)
+}
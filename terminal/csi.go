@@ -1,10 +1,14 @@
 package terminal
 
 import (
+	"fmt"
 	"strconv"
 	"strings"
 )
 
+// vt102ID is the reply to primary device attribute requests (DA1/DECID).
+const vt102ID = "\033[?6c"
+
 // CSI (Control Sequence Introducer)
 // ESC+[
 type csiEscape struct {
@@ -12,6 +16,7 @@ type csiEscape struct {
 	args []int
 	mode byte
 	priv bool
+	sec  bool // '>' prefix, as in secondary DA
 }
 
 func (c *csiEscape) reset() {
@@ -19,6 +24,7 @@ func (c *csiEscape) reset() {
 	c.args = c.args[:0]
 	c.mode = 0
 	c.priv = false
+	c.sec = false
 }
 
 func (c *csiEscape) put(b byte) bool {
@@ -40,6 +46,9 @@ func (c *csiEscape) parse() {
 	if s[0] == '?' {
 		c.priv = true
 		s = s[1:]
+	} else if s[0] == '>' {
+		c.sec = true
+		s = s[1:]
 	}
 	s = s[:len(s)-1]
 	ss := strings.Split(s, ";")
@@ -77,8 +86,14 @@ func (t *State) handleCSI() {
 	case 'B', 'e': // CUD, VPR - cursor <n> down
 		t.moveTo(t.cur.x, t.cur.y+c.maxarg(0, 1))
 	case 'c': // DA - device attributes
-		if c.arg(0, 0) == 0 {
-			// TODO: write vt102 id
+		if c.arg(0, 0) != 0 {
+			break
+		}
+		if c.sec {
+			// secondary DA: VT220, firmware version 0, no cartridge
+			t.respond("\033[>1;0;0c")
+		} else {
+			t.respond(vt102ID)
 		}
 	case 'C', 'a': // CUF, HPR - cursor <n> forward
 		t.moveTo(t.cur.x+c.maxarg(0, 1), t.cur.y)
@@ -125,6 +140,9 @@ func (t *State) handleCSI() {
 			t.clear(0, t.cur.y, t.cur.x, t.cur.y)
 		case 2: // all
 			t.clear(0, 0, t.cols-1, t.rows-1)
+			if t.mode&ModeAltScreen == 0 {
+				t.marks = nil
+			}
 		default:
 			goto unknown
 		}
@@ -160,6 +178,23 @@ func (t *State) handleCSI() {
 		t.moveAbsTo(t.cur.x, c.arg(0, 1)-1)
 	case 'h': // SM - set terminal mode
 		t.setMode(c.priv, true, c.args)
+	case 'n': // DSR - device status report
+		switch c.arg(0, 0) {
+		case 5: // operating status
+			t.respond("\033[0n")
+		case 6: // CPR - cursor position report
+			x, y := t.cur.x, t.cur.y
+			if t.cur.state&cursorOrigin != 0 {
+				y -= t.top
+			}
+			if c.priv {
+				t.respond(fmt.Sprintf("\033[?%d;%dR", y+1, x+1))
+			} else {
+				t.respond(fmt.Sprintf("\033[%d;%dR", y+1, x+1))
+			}
+		default:
+			goto unknown
+		}
 	case 'm': // SGR - terminal attribute (color)
 		t.setAttr(c.args)
 	case 'r': // DECSTBM - set scrolling region
@@ -173,6 +208,20 @@ func (t *State) handleCSI() {
 		t.saveCursor()
 	case 'u': // DECRC - restore cursor position (ANSI.SYS)
 		t.restoreCursor()
+	case 't': // window manipulation
+		switch c.arg(0, 0) {
+		case 14: // report text area size in pixels
+			t.respond(fmt.Sprintf("\033[4;%d;%dt", t.rows*cellPixels, t.cols*cellPixels))
+		case 16: // report character cell size in pixels
+			t.respond(fmt.Sprintf("\033[6;%d;%dt", cellPixels, cellPixels))
+		case 18: // report text area size in characters
+			t.respond(fmt.Sprintf("\033[8;%d;%dt", t.rows, t.cols))
+		case 19: // report screen size in characters
+			t.respond(fmt.Sprintf("\033[9;%d;%dt", t.rows, t.cols))
+		case 22, 23: // push/pop title (ignored)
+		default:
+			goto unknown
+		}
 	}
 	return
 unknown: // TODO: get rid of this goto
@@ -0,0 +1,79 @@
+package terminal
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Dump returns a plain text dump of the screen: a header with the size,
+// cursor and screen modes, the text of every row with trailing blanks
+// trimmed, then the runs of cells whose attributes aren't the defaults.
+// It's meant for golden tests and debugging.
+func (t *State) Dump() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "size %dx%d cursor %d,%d", t.cols, t.rows, t.cur.x, t.cur.y)
+	if !t.CursorVisible() {
+		b.WriteString(" hidden")
+	}
+	if t.mode&ModeAltScreen != 0 {
+		b.WriteString(" altscreen")
+	}
+	b.WriteString("\n")
+
+	row := make([]rune, t.cols)
+	for y := 0; y < t.rows; y++ {
+		for x := 0; x < t.cols; x++ {
+			row[x] = t.lines[y][x].c
+			if row[x] == 0 {
+				row[x] = ' '
+			}
+		}
+		fmt.Fprintf(&b, "%2d|%s\n", y, strings.TrimRight(string(row), " "))
+	}
+
+	b.WriteString("attributes:\n")
+	for y := 0; y < t.rows; y++ {
+		for x := 0; x < t.cols; {
+			g := t.lines[y][x]
+			end := x
+			for end+1 < t.cols && sameAttr(t.lines[y][end+1], g) {
+				end++
+			}
+			if !sameAttr(g, glyph{fg: DefaultFG, bg: DefaultBG}) {
+				fmt.Fprintf(&b, "%2d %d-%d %s\n", y, x, end, attrString(g))
+			}
+			x = end + 1
+		}
+	}
+	return b.String()
+}
+
+func sameAttr(g1, g2 glyph) bool {
+	return g1.fg == g2.fg && g1.bg == g2.bg && g1.link == g2.link &&
+		g1.mode&^attrWrap == g2.mode&^attrWrap
+}
+
+var attrNames = []struct {
+	bit  int16
+	name string
+}{
+	{attrBold, "bold"},
+	{attrItalic, "italic"},
+	{attrUnderline, "underline"},
+	{attrReverse, "reverse"},
+	{attrBlink, "blink"},
+	{attrGfx, "gfx"},
+}
+
+func attrString(g glyph) string {
+	s := fmt.Sprintf("fg=%d bg=%d", g.fg, g.bg)
+	for _, a := range attrNames {
+		if g.mode&a.bit != 0 {
+			s += " " + a.name
+		}
+	}
+	if g.link != 0 {
+		s += fmt.Sprintf(" link=%d", g.link)
+	}
+	return s
+}
@@ -0,0 +1,180 @@
+package terminal
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// MouseButton identifies the button of a mouse event.
+type MouseButton int
+
+// Mouse buttons, numbered as in xterm mouse reports
+const (
+	MouseLeft MouseButton = iota
+	MouseMiddle
+	MouseRight
+	MouseNone // motion with no button held
+	MouseWheelUp
+	MouseWheelDown
+)
+
+// MouseAction is what happened to the button of a mouse event.
+type MouseAction int
+
+// Mouse actions
+const (
+	MousePress MouseAction = iota
+	MouseRelease
+	MouseMotion
+)
+
+// MouseEvent is a mouse event on cell (X, Y), relative to the top left
+// of the terminal.
+type MouseEvent struct {
+	Button MouseButton
+	Action MouseAction
+	X, Y   int
+	Shift  bool
+	Alt    bool
+	Ctrl   bool
+}
+
+const (
+	pasteStart = "\033[200~"
+	pasteEnd   = "\033[201~"
+)
+
+// EncodeMouse returns the report for ev according to the mouse modes set
+// by the program running in the terminal (X10, normal, button motion, any
+// motion, and SGR encoding). It returns nil if ev should not be reported.
+func (t *State) EncodeMouse(ev MouseEvent) []byte {
+	if t.mode&ModeMouseMask == 0 {
+		return nil
+	}
+	if ev.X < 0 || ev.Y < 0 || ev.X >= t.cols || ev.Y >= t.rows {
+		return nil
+	}
+	wheel := ev.Button == MouseWheelUp || ev.Button == MouseWheelDown
+	if ev.Button == MouseNone && ev.Action != MouseMotion {
+		return nil
+	}
+	switch ev.Action {
+	case MousePress:
+		if t.mode&ModeMouseX10 != 0 && wheel {
+			return nil
+		}
+	case MouseRelease:
+		if t.mode&ModeMouseX10 != 0 || wheel {
+			return nil
+		}
+	case MouseMotion:
+		if t.mode&ModeMouseMany == 0 &&
+			(t.mode&ModeMouseMotion == 0 || ev.Button == MouseNone) {
+			return nil
+		}
+	}
+
+	var cb int
+	switch ev.Button {
+	case MouseWheelUp:
+		cb = 64
+	case MouseWheelDown:
+		cb = 65
+	default:
+		cb = int(ev.Button)
+	}
+	if ev.Action == MouseMotion {
+		cb += 32
+	}
+	// X10 compatibility mode doesn't report modifiers
+	if t.mode&ModeMouseX10 == 0 {
+		if ev.Shift {
+			cb |= 4
+		}
+		if ev.Alt {
+			cb |= 8
+		}
+		if ev.Ctrl {
+			cb |= 16
+		}
+	}
+
+	x, y := ev.X+1, ev.Y+1
+	if t.mode&ModeMouseSgr != 0 {
+		final := 'M'
+		if ev.Action == MouseRelease {
+			final = 'm'
+		}
+		return []byte(fmt.Sprintf("\033[<%d;%d;%d%c", cb, x, y, final))
+	}
+	// legacy encoding can't tell which button was released
+	if ev.Action == MouseRelease {
+		cb |= 3
+	}
+	// nor represent positions past 223
+	if x > 255-32 || y > 255-32 {
+		return nil
+	}
+	return []byte{'\033', '[', 'M', byte(32 + cb), byte(32 + x), byte(32 + y)}
+}
+
+// EncodePaste returns text as it should be sent to the program for a
+// paste: wrapped in markers when the program enabled bracketed paste
+// (mode 2004), unchanged otherwise.
+func (t *State) EncodePaste(text []byte) []byte {
+	if t.mode&ModeBracketedPaste == 0 {
+		return text
+	}
+	// an end marker in the text would let it escape the paste
+	text = bytes.Replace(text, []byte(pasteEnd), nil, -1)
+	b := make([]byte, 0, len(pasteStart)+len(text)+len(pasteEnd))
+	b = append(b, pasteStart...)
+	b = append(b, text...)
+	return append(b, pasteEnd...)
+}
+
+// EncodeFocus returns the focus in or out report if the program asked for
+// focus events (mode 1004), nil otherwise.
+func (t *State) EncodeFocus(focused bool) []byte {
+	if t.mode&ModeFocus == 0 {
+		return nil
+	}
+	if focused {
+		return []byte("\033[I")
+	}
+	return []byte("\033[O")
+}
+
+// SendMouse writes the report for ev to the program, if it asked for it.
+func (t *VT) SendMouse(ev MouseEvent) error {
+	t.dest.lock()
+	b, w := t.dest.EncodeMouse(ev), t.dest.w
+	t.dest.unlock()
+	return send(w, b)
+}
+
+// SendPaste writes pasted text to the program.
+func (t *VT) SendPaste(text []byte) error {
+	t.dest.lock()
+	b, w := t.dest.EncodePaste(text), t.dest.w
+	t.dest.unlock()
+	return send(w, b)
+}
+
+// SendFocus writes a focus in or out report to the program, if it asked
+// for it.
+func (t *VT) SendFocus(focused bool) error {
+	t.dest.lock()
+	b, w := t.dest.EncodeFocus(focused), t.dest.w
+	t.dest.unlock()
+	return send(w, b)
+}
+
+func send(w io.Writer, b []byte) error {
+	if len(b) == 0 || w == nil {
+		return nil
+	}
+	_, err := w.Write(b)
+	return err
+}
@@ -0,0 +1,94 @@
+package terminal
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEncodeMouse(t *testing.T) {
+	tests := []struct {
+		name  string
+		modes string
+		ev    MouseEvent
+		out   string
+	}{
+		{"disabled", "", MouseEvent{Button: MouseLeft}, ""},
+		{"x10 press", "\033[?9h", MouseEvent{Button: MouseLeft, X: 2, Y: 3, Ctrl: true}, "\033[M #$"},
+		{"x10 release", "\033[?9h", MouseEvent{Button: MouseLeft, Action: MouseRelease}, ""},
+		{"normal press", "\033[?1000h", MouseEvent{Button: MouseRight, X: 0, Y: 0}, "\033[M\"!!"},
+		{"normal mods", "\033[?1000h", MouseEvent{Button: MouseLeft, Shift: true, Ctrl: true}, "\033[M4!!"},
+		{"normal release", "\033[?1000h", MouseEvent{Button: MouseMiddle, Action: MouseRelease}, "\033[M#!!"},
+		{"normal motion", "\033[?1000h", MouseEvent{Button: MouseLeft, Action: MouseMotion}, ""},
+		{"normal wheel", "\033[?1000h", MouseEvent{Button: MouseWheelDown, X: 1, Y: 1}, "\033[Ma\"\""},
+		{"button motion", "\033[?1002h", MouseEvent{Button: MouseLeft, Action: MouseMotion}, "\033[M@!!"},
+		{"button motion no button", "\033[?1002h", MouseEvent{Button: MouseNone, Action: MouseMotion}, ""},
+		{"any motion", "\033[?1003h", MouseEvent{Button: MouseNone, Action: MouseMotion}, "\033[MC!!"},
+		{"sgr press", "\033[?1000h\033[?1006h", MouseEvent{Button: MouseLeft, X: 9, Y: 4}, "\033[<0;10;5M"},
+		{"sgr release", "\033[?1000h\033[?1006h", MouseEvent{Button: MouseRight, Action: MouseRelease, X: 9, Y: 4}, "\033[<2;10;5m"},
+		{"sgr wheel", "\033[?1000h\033[?1006h", MouseEvent{Button: MouseWheelUp, Alt: true}, "\033[<72;1;1M"},
+		{"outside", "\033[?1000h", MouseEvent{Button: MouseLeft, X: 80}, ""},
+		{"reset", "\033[?1000h\033[?1000l", MouseEvent{Button: MouseLeft}, ""},
+	}
+	for _, test := range tests {
+		var st State
+		term, err := Create(&st, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := term.Write([]byte(test.modes)); err != nil {
+			t.Fatal(err)
+		}
+		if out := string(st.EncodeMouse(test.ev)); out != test.out {
+			t.Errorf("%s: got %q, want %q", test.name, out, test.out)
+		}
+	}
+}
+
+func TestSendPaste(t *testing.T) {
+	var st State
+	term, err := Create(&st, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	term.SetResponseWriter(&out)
+
+	term.SendPaste([]byte("ls\n"))
+	if out.String() != "ls\n" {
+		t.Fatalf("got %q", out.String())
+	}
+
+	out.Reset()
+	term.Write([]byte("\033[?2004h"))
+	if !st.Mode(ModeBracketedPaste) {
+		t.Fatal("bracketed paste mode not set")
+	}
+	term.SendPaste([]byte("a\033[201~b"))
+	if out.String() != "\033[200~ab\033[201~" {
+		t.Fatalf("got %q", out.String())
+	}
+
+	out.Reset()
+	term.Write([]byte("\033[?2004l"))
+	term.SendPaste([]byte("x"))
+	if out.String() != "x" {
+		t.Fatalf("got %q", out.String())
+	}
+}
+
+func TestSendFocus(t *testing.T) {
+	var st State
+	term, err := Create(&st, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	term.SetResponseWriter(&out)
+	term.SendFocus(true)
+	term.Write([]byte("\033[?1004h"))
+	term.SendFocus(true)
+	term.SendFocus(false)
+	if out.String() != "\033[I\033[O" {
+		t.Fatalf("got %q", out.String())
+	}
+}
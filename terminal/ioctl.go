@@ -6,6 +6,9 @@ import (
 	"unsafe"
 )
 
+// cellPixels is the width and height reported for a single character cell.
+const cellPixels = 16
+
 func ioctl(f *os.File, cmd, p uintptr) error {
 	_, _, errno := syscall.Syscall(
 		syscall.SYS_IOCTL,
@@ -25,8 +28,8 @@ func (t *VT) ptyResize() error {
 	var w struct{ row, col, xpix, ypix uint16 }
 	w.row = uint16(t.dest.rows)
 	w.col = uint16(t.dest.cols)
-	w.xpix = 16 * uint16(t.dest.cols)
-	w.ypix = 16 * uint16(t.dest.rows)
+	w.xpix = cellPixels * uint16(t.dest.cols)
+	w.ypix = cellPixels * uint16(t.dest.rows)
 	return ioctl(t.pty, syscall.TIOCSWINSZ,
 		uintptr(unsafe.Pointer(&w)))
 }
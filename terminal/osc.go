@@ -0,0 +1,211 @@
+package terminal
+
+import (
+	"encoding/base64"
+	"net/url"
+	"strings"
+)
+
+// Hyperlink is a link attached to cells with OSC 8.
+type Hyperlink struct {
+	ID  string
+	URI string
+}
+
+// PromptMarkKind is the kind of a shell integration mark (OSC 133).
+type PromptMarkKind rune
+
+// Shell integration marks
+const (
+	PromptStart     PromptMarkKind = 'A'
+	CommandStart    PromptMarkKind = 'B'
+	CommandExecuted PromptMarkKind = 'C'
+	CommandFinished PromptMarkKind = 'D'
+)
+
+// PromptMark is a shell integration mark at cell (Col, Row). ExitCode is
+// only meaningful for CommandFinished marks.
+type PromptMark struct {
+	Kind     PromptMarkKind
+	Col, Row int
+	ExitCode int
+}
+
+// Cwd returns the working directory last reported by the shell with OSC 7.
+func (t *State) Cwd() string {
+	return t.cwd
+}
+
+// CellLink returns the hyperlink attached to the cell at (x, y), if any.
+func (t *State) CellLink(x, y int) (Hyperlink, bool) {
+	i := t.lines[y][x].link
+	if i == 0 {
+		return Hyperlink{}, false
+	}
+	return t.links[i-1], true
+}
+
+// PromptMarks returns the shell integration marks visible on the main
+// screen, from top to bottom.
+func (t *State) PromptMarks() []PromptMark {
+	return append([]PromptMark(nil), t.marks...)
+}
+
+// setCwd handles OSC 7, which reports a file:// URL.
+func (t *State) setCwd(s string) {
+	u, err := url.Parse(s)
+	if err != nil || u.Scheme != "file" {
+		t.logf("invalid OSC 7 url '%s'\n", s)
+		return
+	}
+	t.cwd = u.Path
+	t.changed |= ChangedCwd
+}
+
+// setLink handles OSC 8: links text written from now on to uri, or stops
+// linking when uri is empty. Cells sharing an id are the same link even
+// when they aren't contiguous.
+func (t *State) setLink(params, uri string) {
+	if uri == "" {
+		t.cur.attr.link = 0
+		return
+	}
+	id := ""
+	for _, p := range strings.Split(params, ":") {
+		if strings.HasPrefix(p, "id=") {
+			id = p[3:]
+		}
+	}
+	link := Hyperlink{ID: id, URI: uri}
+	if i, ok := t.linkIndex[link]; ok {
+		t.cur.attr.link = i
+		return
+	}
+	if len(t.links) >= t.linksPruneAt {
+		t.pruneLinks()
+	}
+	if t.linkIndex == nil {
+		t.linkIndex = map[Hyperlink]int{}
+	}
+	t.links = append(t.links, link)
+	t.linkIndex[link] = len(t.links)
+	t.cur.attr.link = len(t.links)
+}
+
+// minLinksPruneAt is the links count below which none are dropped.
+const minLinksPruneAt = 64
+
+// pruneLinks drops the links no cell or cursor refers to any more, once
+// they were cleared or scrolled off, renumbering the others. It waits for
+// the count to double before doing it again.
+func (t *State) pruneLinks() {
+	used := make([]bool, len(t.links)+1)
+	for _, lines := range [][]line{t.lines, t.altLines} {
+		for _, l := range lines {
+			for _, g := range l {
+				used[g.link] = true
+			}
+		}
+	}
+	used[t.cur.attr.link] = true
+	used[t.curSaved.attr.link] = true
+
+	renumber := make([]int, len(t.links)+1)
+	links := t.links[:0]
+	t.linkIndex = map[Hyperlink]int{}
+	for i, l := range t.links {
+		if used[i+1] {
+			links = append(links, l)
+			renumber[i+1] = len(links)
+			t.linkIndex[l] = len(links)
+		}
+	}
+	t.links = links
+	for _, lines := range [][]line{t.lines, t.altLines} {
+		for _, l := range lines {
+			for x := range l {
+				l[x].link = renumber[l[x].link]
+			}
+		}
+	}
+	t.cur.attr.link = renumber[t.cur.attr.link]
+	t.curSaved.attr.link = renumber[t.curSaved.attr.link]
+	t.linksPruneAt = max(minLinksPruneAt, 2*len(t.links))
+}
+
+// handleClipboard handles OSC 52, setting or querying a selection.
+func (t *State) handleClipboard(sel, data string) {
+	if sel == "" {
+		sel = "s0"
+	}
+	if data == "?" {
+		if t.ClipboardGet == nil {
+			return
+		}
+		reply := base64.StdEncoding.EncodeToString(t.ClipboardGet(sel))
+		t.respond("\033]52;" + sel + ";" + reply + t.str.term)
+		return
+	}
+	b, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		t.logf("invalid OSC 52 data: %v\n", err)
+		return
+	}
+	if t.ClipboardSet != nil {
+		t.ClipboardSet(sel, b)
+	}
+}
+
+// addMark handles OSC 133 shell integration marks.
+func (t *State) addMark(kind string, exitCode int) {
+	if len(kind) != 1 || !strings.Contains("ABCD", kind) {
+		t.logf("unknown OSC 133 mark '%s'\n", kind)
+		return
+	}
+	if t.mode&ModeAltScreen != 0 {
+		return
+	}
+	m := PromptMark{
+		Kind:     PromptMarkKind(kind[0]),
+		Col:      t.cur.x,
+		Row:      t.cur.y,
+		ExitCode: exitCode,
+	}
+	i := len(t.marks)
+	for i > 0 && (t.marks[i-1].Row > m.Row ||
+		t.marks[i-1].Row == m.Row && t.marks[i-1].Col > m.Col) {
+		i--
+	}
+	t.marks = append(t.marks, PromptMark{})
+	copy(t.marks[i+1:], t.marks[i:])
+	t.marks[i] = m
+}
+
+// scrollMarks moves the marks between rows top and bottom by n rows,
+// dropping those scrolled out of that region.
+func (t *State) scrollMarks(top, bottom, n int) {
+	if t.mode&ModeAltScreen != 0 {
+		return
+	}
+	marks := t.marks[:0]
+	for _, m := range t.marks {
+		if m.Row >= top && m.Row <= bottom {
+			m.Row += n
+			if m.Row < top || m.Row > bottom {
+				continue
+			}
+		}
+		marks = append(marks, m)
+	}
+	t.marks = marks
+}
+
+func (t *State) filterMarks(keep func(PromptMark) bool) {
+	marks := t.marks[:0]
+	for _, m := range t.marks {
+		if keep(m) {
+			marks = append(marks, m)
+		}
+	}
+	t.marks = marks
+}
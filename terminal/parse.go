@@ -70,7 +70,7 @@ func (t *State) parseEsc(c rune) {
 			t.moveTo(t.cur.x, t.cur.y-1)
 		}
 	case 'Z': // DECID - identify terminal
-		// TODO: write to our writer our id
+		t.respond(vt102ID)
 	case 'c': // RIS - reset to initial state
 		t.reset()
 	case '=': // DECPAM - application keypad
@@ -104,6 +104,7 @@ func (t *State) parseEscStr(c rune) {
 		t.state = t.parseEscStrEnd
 	case '\a': // backwards compatiblity to xterm
 		t.state = t.parse
+		t.str.term = "\a"
 		t.handleSTR()
 	default:
 		t.str.put(c)
@@ -116,6 +117,7 @@ func (t *State) parseEscStrEnd(c rune) {
 	}
 	t.state = t.parse
 	if c == '\\' {
+		t.str.term = "\033\\"
 		t.handleSTR()
 	}
 }
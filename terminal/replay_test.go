@@ -0,0 +1,113 @@
+package terminal
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update golden files in testdata")
+
+// replay feeds a recorded session through VT.Write, chunk bytes at a time,
+// and returns the dump of the resulting screen.
+func replay(t *testing.T, data []byte, chunk int) string {
+	var st State
+	term, err := Create(&st, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pending []byte
+	for len(data) > 0 {
+		n := min(chunk, len(data))
+		// like a reader would, keep the bytes of a partial rune for later
+		pending = append(pending, data[:n]...)
+		data = data[n:]
+		written, err := term.Write(pending)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pending = append(pending[:0], pending[written:]...)
+	}
+	return st.Dump()
+}
+
+// stripScript removes the header and footer script(1) adds around
+// recorded sessions.
+func stripScript(data []byte) []byte {
+	if bytes.HasPrefix(data, []byte("Script started")) {
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			data = data[i+1:]
+		}
+	}
+	if i := bytes.LastIndex(data, []byte("\nScript done")); i >= 0 {
+		data = data[:i]
+	}
+	return data
+}
+
+// TestReplay replays the sessions recorded with script(1) in testdata and
+// compares the screen with the matching golden dump. Run with -update to
+// regenerate the golden files after an intended change.
+func TestReplay(t *testing.T) {
+	files, err := filepath.Glob("testdata/*.typescript")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no recorded sessions in testdata")
+	}
+	for _, file := range files {
+		name := strings.TrimSuffix(filepath.Base(file), ".typescript")
+		t.Run(name, func(t *testing.T) {
+			data, err := ioutil.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data = stripScript(data)
+			actual := replay(t, data, len(data))
+
+			golden := strings.TrimSuffix(file, ".typescript") + ".golden"
+			if *update {
+				if err := ioutil.WriteFile(golden, []byte(actual), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			expected, err := ioutil.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if actual != string(expected) {
+				t.Errorf("screen doesn't match %s:\n%s", golden, diffLines(string(expected), actual))
+			}
+
+			// the result must not depend on how the stream is split
+			for _, chunk := range []int{1, 7, 64} {
+				if chunked := replay(t, data, chunk); chunked != actual {
+					t.Errorf("replay in chunks of %d differs:\n%s", chunk, diffLines(actual, chunked))
+				}
+			}
+		})
+	}
+}
+
+// diffLines lists the lines that differ between two dumps.
+func diffLines(expected, actual string) string {
+	el, al := strings.Split(expected, "\n"), strings.Split(actual, "\n")
+	var b strings.Builder
+	for i := 0; i < len(el) || i < len(al); i++ {
+		var e, a string
+		if i < len(el) {
+			e = el[i]
+		}
+		if i < len(al) {
+			a = al[i]
+		}
+		if e != a {
+			b.WriteString("- " + e + "\n+ " + a + "\n")
+		}
+	}
+	return b.String()
+}
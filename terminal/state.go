@@ -1,6 +1,7 @@
 package terminal
 
 import (
+	"io"
 	"log"
 	"sync"
 )
@@ -49,6 +50,7 @@ const (
 	ModeFocus
 	ModeMouseX10
 	ModeMouseMany
+	ModeBracketedPaste
 	ModeMouseMask = ModeMouseButton | ModeMouseMotion | ModeMouseX10 | ModeMouseMany
 )
 
@@ -59,12 +61,14 @@ type ChangeFlag uint32
 const (
 	ChangedScreen ChangeFlag = 1 << iota
 	ChangedTitle
+	ChangedCwd
 )
 
 type glyph struct {
 	c      rune
 	mode   int16
 	fg, bg Color
+	link   int // index in State.links plus one, 0 when not a link
 }
 
 type line []glyph
@@ -82,6 +86,15 @@ type parseState func(c rune)
 type State struct {
 	DebugLogger *log.Logger
 
+	// ClipboardSet is called when the program sets a clipboard selection
+	// (OSC 52). sel holds the selection characters, e.g. "c" or "p". It's
+	// called with the state locked.
+	ClipboardSet func(sel string, data []byte)
+	// ClipboardGet is called when the program queries a clipboard
+	// selection (OSC 52). Queries are ignored when nil. It's called with
+	// the state locked.
+	ClipboardGet func(sel string) []byte
+
 	mu            sync.Mutex
 	changed       ChangeFlag
 	cols, rows    int
@@ -98,6 +111,13 @@ type State struct {
 	numlock       bool
 	tabs          []bool
 	title         string
+	cwd           string
+	links         []Hyperlink
+	linkIndex     map[Hyperlink]int // of links, plus one like glyph.link
+	linksPruneAt  int               // links count dropping unused ones
+	marks         []PromptMark
+	w             io.Writer // replies to queries
+	replies       []byte    // waiting to be written to w once unlocked
 }
 
 func (t *State) logf(format string, args ...interface{}) {
@@ -112,6 +132,26 @@ func (t *State) logln(s string) {
 	}
 }
 
+// respond queues a reply to a query for the program running in the
+// terminal. Replies are written by unlockAndReply, so a program not
+// reading them can't block others waiting for the lock.
+func (t *State) respond(s string) {
+	if t.w == nil {
+		return
+	}
+	t.replies = append(t.replies, s...)
+}
+
+// unlockAndReply unlocks the state then writes the queued replies.
+func (t *State) unlockAndReply() {
+	w, replies := t.w, t.replies
+	t.replies = nil
+	t.unlock()
+	if err := send(w, replies); err != nil {
+		t.logf("error writing response: %v\n", err)
+	}
+}
+
 func (t *State) lock() {
 	t.mu.Lock()
 }
@@ -279,6 +319,9 @@ func (t *State) reset() {
 	t.mode = ModeWrap
 	t.clear(0, 0, t.rows-1, t.cols-1)
 	t.moveTo(0, 0)
+	t.links = nil
+	t.linkIndex = nil
+	t.marks = nil
 }
 
 // TODO: definitely can improve allocs
@@ -324,6 +367,10 @@ func (t *State) resize(cols, rows int) bool {
 		}
 	}
 
+	if slide > 0 {
+		t.scrollMarks(0, t.rows-1, -slide)
+	}
+	t.filterMarks(func(m PromptMark) bool { return m.Row < rows })
 	t.cols = cols
 	t.rows = rows
 	t.setScroll(0, rows-1)
@@ -357,6 +404,7 @@ func (t *State) clear(x0, y0, x1, y1 int) {
 		for x := x0; x <= x1; x++ {
 			t.lines[y][x] = t.cur.attr
 			t.lines[y][x].c = ' '
+			t.lines[y][x].link = 0
 		}
 	}
 }
@@ -451,6 +499,7 @@ func (t *State) scrollDown(orig, n int) {
 		t.dirty[i] = true
 		t.dirty[i-n] = true
 	}
+	t.scrollMarks(orig, t.bottom, n)
 
 	// TODO: selection scroll
 }
@@ -464,6 +513,7 @@ func (t *State) scrollUp(orig, n int) {
 		t.dirty[i] = true
 		t.dirty[i+n] = true
 	}
+	t.scrollMarks(orig, t.bottom, -n)
 
 	// TODO: selection scroll
 }
@@ -528,6 +578,8 @@ func (t *State) setMode(priv bool, set bool, args []int) {
 				t.modMode(set, ModeMouseSgr)
 			case 1034:
 				t.modMode(set, Mode8bit)
+			case 2004: // bracketed paste
+				t.modMode(set, ModeBracketedPaste)
 			case 1049, // = 1047 and 1048
 				47, 1047:
 				alt := t.mode&ModeAltScreen != 0
@@ -1,6 +1,7 @@
 package terminal
 
 import (
+	"fmt"
 	"strconv"
 	"strings"
 )
@@ -12,17 +13,22 @@ type strEscape struct {
 	typ  rune
 	buf  []rune
 	args []string
+	term string // terminator used, replies end with the same one
 }
 
 func (s *strEscape) reset() {
 	s.typ = 0
 	s.buf = s.buf[:0]
 	s.args = nil
+	s.term = ""
 }
 
+// strMaxLen bounds STR sequences, large enough for OSC 52 clipboard data.
+const strMaxLen = 1 << 20
+
 func (s *strEscape) put(c rune) {
 	// TODO: improve allocs with an array backed slice; bench first
-	if len(s.buf) < 256 {
+	if len(s.buf) < strMaxLen {
 		s.buf = append(s.buf, c)
 	}
 	// Going by st, it is better to remain silent when the STR sequence is not
@@ -53,6 +59,15 @@ func (s *strEscape) argString(i int, def string) string {
 	return s.args[i]
 }
 
+// argRest returns the arguments from i on joined back together, for string
+// arguments that may contain ';'.
+func (s *strEscape) argRest(i int) string {
+	if i >= len(s.args) || i < 0 {
+		return ""
+	}
+	return strings.Join(s.args[i:], ";")
+}
+
 func (t *State) handleSTR() {
 	s := &t.str
 	s.parse()
@@ -65,11 +80,39 @@ func (t *State) handleSTR() {
 			if title != "" {
 				t.setTitle(title)
 			}
-		case 4: // color set
+		case 7: // current directory
+			t.setCwd(s.argRest(1))
+		case 8: // hyperlink
+			t.setLink(s.argString(1, ""), s.argRest(2))
+		case 52: // clipboard
+			t.handleClipboard(s.argString(1, ""), s.argString(2, ""))
+		case 133: // shell integration marks
+			t.addMark(s.argString(1, ""), s.arg(2, 0))
+		case 4: // color set or query
 			if len(s.args) < 3 {
 				break
 			}
-			// setcolorname(s.arg(1, 0), s.argString(2, ""))
+			for i := 1; i+1 < len(s.args); i += 2 {
+				if s.args[i+1] == "?" {
+					n := s.arg(i, -1)
+					if between(n, 0, 255) {
+						t.respond(fmt.Sprintf("\033]4;%d;%s%s", n, Color(n).rgbSpec(), s.term))
+					}
+				}
+				// setcolorname(s.arg(i, 0), s.argString(i+1, ""))
+			}
+		case 10, 11, 12: // default fg, default bg, cursor color query
+			if s.argString(1, "") != "?" {
+				break
+			}
+			c := defaultFGColor
+			switch d {
+			case 11:
+				c = defaultBGColor
+			case 12:
+				c = defaultCursorColor
+			}
+			t.respond(fmt.Sprintf("\033]%d;%s%s", d, c.rgbSpec(), s.term))
 		case 104: // color reset
 			// TODO: complain about invalid color, redraw, etc.
 			// setcolorname(s.arg(1, 0), nil)
@@ -1,6 +1,10 @@
 package terminal
 
 import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -13,3 +17,126 @@ func TestSTRParse(t *testing.T) {
 		t.Fatal("STR parse mismatch")
 	}
 }
+
+func newTestState(t *testing.T) (*VT, *State) {
+	st := &State{}
+	term, err := Create(st, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return term, st
+}
+
+func TestOSCCwd(t *testing.T) {
+	term, st := newTestState(t)
+	term.Write([]byte("\033]7;file://host/home/me/my%20dir\a"))
+	if st.Cwd() != "/home/me/my dir" {
+		t.Fatalf("got cwd %q", st.Cwd())
+	}
+	if !st.Changed(ChangedCwd) {
+		t.Fatal("cwd change not flagged")
+	}
+	term.Write([]byte("\033]7;http://example.com/\a"))
+	if st.Cwd() != "/home/me/my dir" {
+		t.Fatalf("non file url changed cwd to %q", st.Cwd())
+	}
+}
+
+func TestOSCHyperlink(t *testing.T) {
+	term, st := newTestState(t)
+	term.Write([]byte("a\033]8;id=x;http://a.b/c;d\033\\link\033]8;;\033\\b"))
+	if _, ok := st.CellLink(0, 0); ok {
+		t.Fatal("cell before link is linked")
+	}
+	for x := 1; x <= 4; x++ {
+		l, ok := st.CellLink(x, 0)
+		if !ok || l.URI != "http://a.b/c;d" || l.ID != "x" {
+			t.Fatalf("cell %d: got %v %v", x, l, ok)
+		}
+	}
+	if _, ok := st.CellLink(5, 0); ok {
+		t.Fatal("cell after link is linked")
+	}
+	term.Write([]byte("\033]8;id=x;http://a.b/c;d\a!\033]8;;\a"))
+	if len(st.links) != 1 {
+		t.Fatalf("same link stored %d times", len(st.links))
+	}
+	term.Write([]byte("\033[2J"))
+	if _, ok := st.CellLink(1, 0); ok {
+		t.Fatal("cleared cell is still linked")
+	}
+
+	// Links overwritten are dropped, the others kept
+	term.Write([]byte("\033[H\033]8;;http://keep\aK\033]8;;\a"))
+	for i := 0; i < 200; i++ {
+		term.Write([]byte(fmt.Sprintf("\033[2;1H\033]8;;http://x/%d\aZ\033]8;;\a", i)))
+	}
+	if len(st.links) > minLinksPruneAt {
+		t.Fatalf("%d links stored", len(st.links))
+	}
+	if l, ok := st.CellLink(0, 0); !ok || l.URI != "http://keep" {
+		t.Fatalf("got %v %v for the kept link", l, ok)
+	}
+	if l, ok := st.CellLink(0, 1); !ok || l.URI != "http://x/199" {
+		t.Fatalf("got %v %v for the last link", l, ok)
+	}
+}
+
+func TestOSCClipboard(t *testing.T) {
+	term, st := newTestState(t)
+	var replies bytes.Buffer
+	term.SetResponseWriter(&replies)
+	var gotSel, gotData string
+	st.ClipboardSet = func(sel string, data []byte) {
+		gotSel, gotData = sel, string(data)
+	}
+	term.Write([]byte("\033]52;c;aGVsbG8=\a"))
+	if gotSel != "c" || gotData != "hello" {
+		t.Fatalf("got %q %q", gotSel, gotData)
+	}
+
+	term.Write([]byte("\033]52;c;?\a"))
+	if replies.Len() != 0 {
+		t.Fatalf("replied %q without ClipboardGet", replies.String())
+	}
+	st.ClipboardGet = func(sel string) []byte {
+		return []byte("hi " + sel)
+	}
+	term.Write([]byte("\033]52;p;?\033\\"))
+	if replies.String() != "\033]52;p;aGkgcA==\033\\" {
+		t.Fatalf("got %q", replies.String())
+	}
+}
+
+func TestOSCPromptMarks(t *testing.T) {
+	term, st := newTestState(t)
+	term.Write([]byte("\033]133;A\a$ \033]133;B\als\r\n\033]133;C\afile\r\n\033]133;D;2\a"))
+	marks := st.PromptMarks()
+	expected := []PromptMark{
+		{Kind: PromptStart, Col: 0, Row: 0},
+		{Kind: CommandStart, Col: 2, Row: 0},
+		{Kind: CommandExecuted, Col: 0, Row: 1},
+		{Kind: CommandFinished, Col: 0, Row: 2, ExitCode: 2},
+	}
+	if !reflect.DeepEqual(marks, expected) {
+		t.Fatalf("got %v", marks)
+	}
+
+	// scrolling moves marks up, dropping the ones leaving the screen
+	term.Write([]byte(strings.Repeat("\n", 22)))
+	marks = st.PromptMarks()
+	if len(marks) != 2 || marks[0].Row != 0 || marks[1].Row != 1 {
+		t.Fatalf("got %v after scroll", marks)
+	}
+
+	// marks aren't recorded on the alternate screen
+	term.Write([]byte("\033[?1049h\033]133;A\a\033[?1049l"))
+	if len(st.PromptMarks()) != 2 {
+		t.Fatalf("got %v after alt screen", st.PromptMarks())
+	}
+
+	term.Write([]byte("\033[2J"))
+	if len(st.PromptMarks()) != 0 {
+		t.Fatalf("got %v after clear", st.PromptMarks())
+	}
+}
@@ -33,17 +33,22 @@ func Start(state *State, cmd *exec.Cmd) (*VT, *os.File, error) {
 	}
 	t.rc = t.pty
 	t.init()
+	t.dest.w = t.pty
 	return t, t.pty, nil
 }
 
 // Create initializes a virtual terminal emulator with the target state
-// and io.ReadCloser input.
+// and io.ReadCloser input. If rc is also an io.Writer, replies to terminal
+// queries are written to it.
 func Create(state *State, rc io.ReadCloser) (*VT, error) {
 	t := &VT{
 		dest: state,
 		rc:   rc,
 	}
 	t.init()
+	if w, ok := rc.(io.Writer); ok {
+		t.dest.w = w
+	}
 	return t, nil
 }
 
@@ -57,6 +62,15 @@ func (t *VT) init() {
 	t.dest.reset()
 }
 
+// SetResponseWriter sets where replies to terminal queries (device
+// attributes, cursor position reports, color queries, ...) are written.
+// Start uses the pty file; a nil writer discards replies.
+func (t *VT) SetResponseWriter(w io.Writer) {
+	t.dest.lock()
+	defer t.dest.unlock()
+	t.dest.w = w
+}
+
 // File returns the pty file.
 func (t *VT) File() *os.File {
 	return t.pty
@@ -67,7 +81,7 @@ func (t *VT) Write(p []byte) (int, error) {
 	var written int
 	r := bytes.NewReader(p)
 	t.dest.lock()
-	defer t.dest.unlock()
+	defer t.dest.unlockAndReply()
 	for {
 		c, sz, err := r.ReadRune()
 		if err != nil {
@@ -76,15 +90,16 @@ func (t *VT) Write(p []byte) (int, error) {
 			}
 			return written, err
 		}
-		written += sz
 		if c == unicode.ReplacementChar && sz == 1 {
-			if r.Len() == 0 {
+			if !utf8.FullRune(p[written:]) {
 				// not enough bytes for a full rune
-				return written - 1, nil
+				return written, nil
 			}
+			written += sz
 			t.dest.logln("invalid utf8 sequence")
 			continue
 		}
+		written += sz
 		t.dest.put(c)
 	}
 	return written, nil
@@ -103,7 +118,7 @@ func (t *VT) Parse() error {
 	var locked bool
 	defer func() {
 		if locked {
-			t.dest.unlock()
+			t.dest.unlockAndReply()
 		}
 	}()
 	for {
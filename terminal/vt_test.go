@@ -1,9 +1,11 @@
 package terminal
 
 import (
+	"bytes"
 	"io"
 	"strings"
 	"testing"
+	"time"
 )
 
 func extractStr(t *State, x0, x1, row int) string {
@@ -68,3 +70,100 @@ func TestNewline(t *testing.T) {
 		t.Fatal(st.cur.x, st.cur.y, fg, bg)
 	}
 }
+
+func TestReplies(t *testing.T) {
+	tests := []struct {
+		name, in, reply string
+	}{
+		{"DA1", "\033[c", "\033[?6c"},
+		{"DA1 explicit", "\033[0c", "\033[?6c"},
+		{"DECID", "\033Z", "\033[?6c"},
+		{"DA2", "\033[>c", "\033[>1;0;0c"},
+		{"DSR status", "\033[5n", "\033[0n"},
+		{"DSR cursor", "\033[3;7H\033[6n", "\033[3;7R"},
+		{"DECXCPR", "\033[2;4H\033[?6n", "\033[?2;4R"},
+		{"DSR origin", "\033[5;10r\033[?6h\033[2;3H\033[6n", "\033[2;3R"},
+		{"window size", "\033[18t", "\033[8;24;80t"},
+		{"screen size", "\033[19t", "\033[9;24;80t"},
+		{"pixel size", "\033[14t", "\033[4;384;1280t"},
+		{"fg query", "\033]10;?\a", "\033]10;rgb:e5e5/e5e5/e5e5\a"},
+		{"bg query", "\033]11;?\033\\", "\033]11;rgb:0000/0000/0000\033\\"},
+		{"cursor query", "\033]12;?\a", "\033]12;rgb:ffff/ffff/ffff\a"},
+		{"palette query", "\033]4;1;?;196;?\a", "\033]4;1;rgb:cdcd/0000/0000\a\033]4;196;rgb:ffff/0000/0000\a"},
+		{"no reply", "\033[1c\033]10;red\a", ""},
+	}
+	for _, test := range tests {
+		var st State
+		term, err := Create(&st, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var replies bytes.Buffer
+		term.SetResponseWriter(&replies)
+		if _, err := term.Write([]byte(test.in)); err != nil {
+			t.Fatal(err)
+		}
+		if replies.String() != test.reply {
+			t.Errorf("%s: got %q, want %q", test.name, replies.String(), test.reply)
+		}
+	}
+}
+
+// lockingWriter locks the state it replies for, like a program reading
+// it to answer would
+type lockingWriter struct {
+	bytes.Buffer
+	st *State
+}
+
+func (w *lockingWriter) Write(p []byte) (int, error) {
+	w.st.Lock()
+	w.st.Unlock()
+	return w.Buffer.Write(p)
+}
+
+func TestRepliesWrittenUnlocked(t *testing.T) {
+	var st State
+	term, err := Create(&st, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := &lockingWriter{st: &st}
+	term.SetResponseWriter(w)
+	done := make(chan struct{})
+	go func() {
+		term.Write([]byte("\033[5n\033[c"))
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("reply written with the state locked")
+	}
+	if w.String() != "\033[0n\033[?6c" {
+		t.Fatalf("got %q", w.String())
+	}
+}
+
+type readWriteCloser struct {
+	bytes.Buffer
+}
+
+func (rwc *readWriteCloser) Close() error {
+	return nil
+}
+
+func TestRepliesToReadWriter(t *testing.T) {
+	var st State
+	rwc := &readWriteCloser{}
+	term, err := Create(&st, rwc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := term.Write([]byte("\033[6n")); err != nil {
+		t.Fatal(err)
+	}
+	if rwc.String() != "\033[1;1R" {
+		t.Fatalf("got %q", rwc.String())
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 	"unicode"
 	"unicode/utf8"
@@ -91,6 +92,14 @@ func write(style tcell.Style, x, y int, str string) int {
 	return i
 }
 
+// Replaces a leading ~ in path by the user's home directory
+func expandHome(path string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		return os.Getenv("HOME") + path[1:]
+	}
+	return path
+}
+
 func listContainsString(list []string, search string) bool {
 	for _, item := range list {
 		if item == search {
@@ -100,6 +109,19 @@ func listContainsString(list []string, search string) bool {
 	return false
 }
 
+// Path relative to the working directory when under it, else as is
+func relativePath(path string) string {
+	wd, err := os.Getwd()
+	if err != nil {
+		return path
+	}
+	rel, err := filepath.Rel(wd, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return path
+	}
+	return rel
+}
+
 func padr(str string, length int, padding rune) string {
 	for utf8.RuneCountInString(str) < length {
 		str = str + string(padding)
@@ -114,6 +136,17 @@ func padl(str string, length int, padding rune) string {
 	return str
 }
 
+func truncate(str string, length int) string {
+	runes := []rune(str)
+	if length < 0 {
+		return ""
+	}
+	if len(runes) > length {
+		return string(runes[:length])
+	}
+	return str
+}
+
 func min(a, b int) int {
 	if a < b {
 		return a
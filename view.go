@@ -18,6 +18,9 @@ type View struct {
 	CenterPending bool
 
 	Highlights []*ViewHighlight
+
+	// Where the cursor was last drawn on screen, for menus and popups
+	CursorX, CursorY int
 }
 
 func NewView(buf *Buffer) *View {
@@ -57,13 +60,77 @@ type ViewTree struct {
 	Top    *ViewTree
 	Bottom *ViewTree
 	Leaf   *View
-	Size   int
+	Size   int // percentage of the space given to Left or Top
+
+	// Where the leaf was last rendered, to move between windows
+	X, Y, W, H int
 }
 
 func NewViewTreeLeaf(parent *ViewTree, v *View) *ViewTree {
 	return &ViewTree{Parent: parent, Leaf: v, Size: 50}
 }
 
+// Splits leaf vt in two, showing v in the new half, below or right of the
+// current one when vertical. Returns the new leaf.
+func (vt *ViewTree) Split(v *View, vertical bool) *ViewTree {
+	first := NewViewTreeLeaf(vt, vt.Leaf)
+	second := NewViewTreeLeaf(vt, v)
+	vt.Leaf = nil
+	vt.Size = 50
+	if vertical {
+		vt.Left, vt.Right = first, second
+	} else {
+		vt.Top, vt.Bottom = first, second
+	}
+	return second
+}
+
+// Children of a split, in order
+func (vt *ViewTree) Children() (*ViewTree, *ViewTree) {
+	if vt.Left != nil {
+		return vt.Left, vt.Right
+	}
+	return vt.Top, vt.Bottom
+}
+
+// Removes leaf vt, its sibling taking the parent's place. Returns the
+// leaf to focus after, nil when vt is the last one.
+func (vt *ViewTree) Close() *ViewTree {
+	parent := vt.Parent
+	if parent == nil {
+		return nil
+	}
+	first, second := parent.Children()
+	sibling := first
+	if first == vt {
+		sibling = second
+	}
+	*parent = ViewTree{
+		Parent: parent.Parent,
+		Left:   sibling.Left,
+		Right:  sibling.Right,
+		Top:    sibling.Top,
+		Bottom: sibling.Bottom,
+		Leaf:   sibling.Leaf,
+		Size:   sibling.Size,
+	}
+	for _, child := range []*ViewTree{parent.Left, parent.Right, parent.Top, parent.Bottom} {
+		if child != nil {
+			child.Parent = parent
+		}
+	}
+	return parent.Leaves()[0]
+}
+
+// Leaf view trees under vt, left to right and top to bottom
+func (vt *ViewTree) Leaves() []*ViewTree {
+	if vt.Leaf != nil {
+		return []*ViewTree{vt}
+	}
+	first, second := vt.Children()
+	return append(first.Leaves(), second.Leaves()...)
+}
+
 // }}}
 
 // {{{ message
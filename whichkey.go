@@ -0,0 +1,107 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"time"
+)
+
+// When the keys typed so far are the start of longer bindings, a popup
+// lists what can come next (once "which_key_delay" milliseconds pass)
+
+type whichKeyItem struct {
+	key  string
+	desc string
+}
+
+// Time at which the keys entered started waiting for more
+var keysPendingSince time.Time
+
+func initWhichKey() {
+	addOption("which_key", true, "Show the keys that can follow a partial key sequence")
+	addOption("which_key_delay", float64(500), "Milliseconds to wait before showing the which-key popup")
+}
+
+// Lists the keys that can follow kl in the modes given, with what they do.
+// Keys starting longer sequences get a "+" description.
+func whichKeyItems(modeNames []string, kl *KeyList) []whichKeyItem {
+	items := []whichKeyItem{}
+	groups := map[string]int{}
+	seen := map[string]bool{}
+	for _, name := range modeNames {
+		m := mustFindMode(name)
+		for _, b := range append(append([]*ModeBinding{}, m.mappings...), m.bindings...) {
+			if len(b.k.keys) <= len(kl.keys) || !(&KeyList{b.k.keys[:len(kl.keys)]}).Matches(kl) {
+				continue
+			}
+			next := b.k.keys[len(kl.keys)]
+			if next.Key == KeyTypeLeader {
+				next = leaderKey()
+			}
+			key := next.String()
+			if len(b.k.keys) > len(kl.keys)+1 {
+				groups[key]++
+				continue
+			}
+			if !seen[key] {
+				seen[key] = true
+				items = append(items, whichKeyItem{key, b.desc})
+			}
+		}
+	}
+	for key, n := range groups {
+		if !seen[key] {
+			desc := "+" + strconv.Itoa(n) + " bindings"
+			if n == 1 {
+				desc = "+1 binding"
+			}
+			items = append(items, whichKeyItem{key, desc})
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].key < items[j].key
+	})
+	return items
+}
+
+// Draws the which-key popup above the message bar when keys are pending
+func renderWhichKey(width, height int) {
+	if len(keysEntered.keys) == 0 || !configGetBool("which_key", nil) || editorMode == "prompt" || editorMode == "picker" {
+		return
+	}
+	delay := time.Duration(configGetNumber("which_key_delay", nil)) * time.Millisecond
+	if time.Since(keysPendingSince) < delay {
+		return
+	}
+	items := whichKeyItems(activeModes(), keysEntered)
+	if len(items) == 0 {
+		return
+	}
+
+	colWidth := 0
+	texts := []string{}
+	for _, item := range items {
+		text := item.key + " → " + item.desc
+		texts = append(texts, text)
+		if len([]rune(text)) > colWidth {
+			colWidth = len([]rune(text))
+		}
+	}
+	colWidth += 3
+	cols := max((width-4)/colWidth, 1)
+	rows := (len(texts) + cols - 1) / cols
+	rows = min(rows, max(height/2-2, 1))
+
+	lines := make([]string, rows)
+	for i, text := range texts {
+		row, col := i%rows, i/rows
+		if col >= cols {
+			break
+		}
+		if col < cols-1 {
+			text = padr(text, colWidth, ' ')
+		}
+		lines[row] += text
+	}
+	renderPopup(&Popup{Title: keysEntered.String(), Lines: lines, Selected: -1}, 0, height-1-(rows+2), width, rows+2)
+}
@@ -0,0 +1,126 @@
+package main
+
+// Windows are the leaves of the view tree, each showing a buffer. C-w
+// prefixes the bindings splitting, closing and moving between them.
+
+func initWindows() {
+	bindDesc("normal", k("C-w s"), "Split window", func(vt *ViewTree, b *Buffer, kl *KeyList) {
+		splitWindow(false)
+	})
+	bindDesc("normal", k("C-w v"), "Split window vertically", func(vt *ViewTree, b *Buffer, kl *KeyList) {
+		splitWindow(true)
+	})
+	bindDesc("normal", k("C-w h"), "Window left", func(vt *ViewTree, b *Buffer, kl *KeyList) {
+		focusWindowDirection(-1, 0)
+	})
+	bindDesc("normal", k("C-w j"), "Window below", func(vt *ViewTree, b *Buffer, kl *KeyList) {
+		focusWindowDirection(0, 1)
+	})
+	bindDesc("normal", k("C-w k"), "Window above", func(vt *ViewTree, b *Buffer, kl *KeyList) {
+		focusWindowDirection(0, -1)
+	})
+	bindDesc("normal", k("C-w l"), "Window right", func(vt *ViewTree, b *Buffer, kl *KeyList) {
+		focusWindowDirection(1, 0)
+	})
+	bindDesc("normal", k("C-w w"), "Next window", func(vt *ViewTree, b *Buffer, kl *KeyList) {
+		focusNextWindow()
+	})
+	bindDesc("normal", k("C-w c"), "Close window", func(vt *ViewTree, b *Buffer, kl *KeyList) {
+		closeWindow()
+	})
+	bindDesc("normal", k("C-w o"), "Only keep this window", func(vt *ViewTree, b *Buffer, kl *KeyList) {
+		onlyWindow()
+	})
+
+	addCommandDesc("split", "Splits the window, optionally editing a file in the new one", func(args []string) {
+		splitWindow(false)
+		if len(args) > 1 {
+			runCommand([]string{"edit", args[1]})
+		}
+	})
+	addAlias("sp", "split")
+	addCommandCompletion("split", completePath)
+	addCommandDesc("vsplit", "Splits the window vertically, optionally editing a file in the new one", func(args []string) {
+		splitWindow(true)
+		if len(args) > 1 {
+			runCommand([]string{"edit", args[1]})
+		}
+	})
+	addAlias("vs", "vsplit")
+	addCommandCompletion("vsplit", completePath)
+	addCommandDesc("close", "Closes the current window", func(args []string) {
+		closeWindow()
+	})
+	addCommandDesc("only", "Closes all windows but the current one", func(args []string) {
+		onlyWindow()
+	})
+}
+
+// Splits the current window, both halves showing its buffer
+func splitWindow(vertical bool) {
+	v := currentViewTree.Leaf
+	nv := NewView(v.Buf)
+	nv.LineOffset = v.LineOffset
+	currentViewTree = currentViewTree.Split(nv, vertical)
+}
+
+func closeWindow() {
+	if currentViewTree.Parent == nil {
+		messageError("Can't close the last window")
+		return
+	}
+	currentViewTree = currentViewTree.Close()
+}
+
+func onlyWindow() {
+	rootViewTree = NewViewTreeLeaf(nil, currentViewTree.Leaf)
+	currentViewTree = rootViewTree
+}
+
+func focusNextWindow() {
+	leaves := rootViewTree.Leaves()
+	for i, leaf := range leaves {
+		if leaf == currentViewTree {
+			currentViewTree = leaves[(i+1)%len(leaves)]
+			return
+		}
+	}
+}
+
+// Focuses the window next to the current one in direction dx,dy, the one
+// closest to the cursor's row or column when there are many
+func focusWindowDirection(dx, dy int) {
+	cur := currentViewTree
+	v := cur.Leaf
+	cursorX := cur.X
+	cursorY := cur.Y + v.Buf.Cursor.Line - v.LineOffset
+	var best *ViewTree
+	bestDistance := 0
+	for _, leaf := range rootViewTree.Leaves() {
+		if leaf == cur {
+			continue
+		}
+		var adjacent bool
+		var distance int
+		switch {
+		case dx < 0:
+			adjacent = leaf.X+leaf.W <= cur.X && leaf.Y <= cursorY && cursorY < leaf.Y+leaf.H
+			distance = cur.X - leaf.X - leaf.W
+		case dx > 0:
+			adjacent = leaf.X >= cur.X+cur.W && leaf.Y <= cursorY && cursorY < leaf.Y+leaf.H
+			distance = leaf.X - cur.X - cur.W
+		case dy < 0:
+			adjacent = leaf.Y+leaf.H <= cur.Y && leaf.X <= cursorX && cursorX < leaf.X+leaf.W
+			distance = cur.Y - leaf.Y - leaf.H
+		default:
+			adjacent = leaf.Y >= cur.Y+cur.H && leaf.X <= cursorX && cursorX < leaf.X+leaf.W
+			distance = leaf.Y - cur.Y - cur.H
+		}
+		if adjacent && (best == nil || distance < bestDistance) {
+			best, bestDistance = leaf, distance
+		}
+	}
+	if best != nil {
+		currentViewTree = best
+	}
+}
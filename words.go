@@ -0,0 +1,177 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// Words of open buffers complete the word before the cursor: the current
+// buffer's closest to the cursor first, then other buffers' by how
+// recently they were used.
+//
+// Words are indexed by buffer and line. Changes mark the lines they touch,
+// which are read again on the modified hook that follows them.
+
+type bufferWords struct {
+	lines [][]string
+	dirty map[int]bool
+	used  int // wordTick when last changed or moved in
+}
+
+var (
+	wordIndex = map[*Buffer]*bufferWords{}
+	wordTick  = 0
+)
+
+func initWords() {
+	wordIndex = map[*Buffer]*bufferWords{}
+	wordTick = 0
+
+	hook_buffer("modified", indexWords)
+	hook_buffer("moved", func(b *Buffer) {
+		if w := wordIndex[b]; w != nil {
+			wordTick++
+			w.used = wordTick
+		}
+	})
+	hook_buffer("closed", func(b *Buffer) {
+		delete(wordIndex, b)
+	})
+	onBufferChange(wordsChange)
+
+	addCompletionSource("words", 10, completeWords)
+}
+
+// Words of at least 2 characters in line
+func lineWords(line []rune) []string {
+	words := []string{}
+	beg := -1
+	for i := 0; i <= len(line); i++ {
+		if i < len(line) && isWord(line[i]) {
+			if beg < 0 {
+				beg = i
+			}
+			continue
+		}
+		if beg >= 0 && i-beg > 1 {
+			words = append(words, string(line[beg:i]))
+		}
+		beg = -1
+	}
+	return words
+}
+
+// Marks the lines a change touched, adding or removing lines for the ones
+// it added or joined
+func wordsChange(b *Buffer, typ ActionType, loc *Location, data []rune) {
+	w := wordIndex[b]
+	if w == nil {
+		return
+	}
+	n := 0
+	for _, r := range data {
+		if r == '\n' {
+			n++
+		}
+	}
+	if loc.Line >= len(w.lines) {
+		w.lines = nil // read everything again
+		return
+	}
+	if typ == ActionTypeInsert {
+		w.lines = append(w.lines[:loc.Line+1], append(make([][]string, n), w.lines[loc.Line+1:]...)...)
+	} else {
+		end := min(loc.Line+1+n, len(w.lines))
+		w.lines = append(w.lines[:loc.Line+1], w.lines[end:]...)
+		n = 0
+	}
+	for l := loc.Line; l <= loc.Line+n; l++ {
+		w.dirty[l] = true
+	}
+}
+
+// Reads the words of b's changed lines, or of all of them for buffers
+// not seen yet or changed without going through actions
+func indexWords(b *Buffer) {
+	w := wordIndex[b]
+	if w == nil {
+		w = &bufferWords{}
+		wordIndex[b] = w
+	}
+	wordTick++
+	w.used = wordTick
+	if len(w.lines) != len(b.Data) {
+		w.lines = make([][]string, len(b.Data))
+		for l, line := range b.Data {
+			w.lines[l] = lineWords(line)
+		}
+	} else {
+		for l := range w.dirty {
+			if l < len(b.Data) {
+				w.lines[l] = lineWords(b.Data[l])
+			}
+		}
+	}
+	w.dirty = map[int]bool{}
+}
+
+// Words matching prefix, the current buffer's from its lines closest to
+// line first, then other buffers' most recently used first. Those
+// starting with prefix come before fuzzy matches.
+func wordCompletions(b *Buffer, prefix string, line int) []completionItem {
+	starting, others := []completionItem{}, []completionItem{}
+	seen := map[string]bool{prefix: true}
+	add := func(words []string, detail string) {
+		for _, word := range words {
+			if seen[word] {
+				continue
+			}
+			seen[word] = true
+			item := completionItem{Label: word, Detail: detail}
+			if strings.HasPrefix(word, prefix) {
+				if len(starting) < maxCompletions {
+					starting = append(starting, item)
+				}
+			} else if _, ok := fuzzyMatchString(prefix, word); ok && len(others) < maxCompletions {
+				others = append(others, item)
+			}
+		}
+	}
+
+	if wordIndex[b] == nil {
+		indexWords(b)
+	}
+	lines := wordIndex[b].lines
+	for d := 0; d < len(lines); d++ {
+		if line-d >= 0 && line-d < len(lines) {
+			add(lines[line-d], "")
+		}
+		if d > 0 && line+d < len(lines) {
+			add(lines[line+d], "")
+		}
+	}
+
+	recent := []*Buffer{}
+	for ob := range wordIndex {
+		if ob != b && bufferIsOpen(ob) {
+			recent = append(recent, ob)
+		}
+	}
+	sort.Slice(recent, func(i, j int) bool {
+		return wordIndex[recent[i]].used > wordIndex[recent[j]].used
+	})
+	for _, ob := range recent {
+		for _, words := range wordIndex[ob].lines {
+			add(words, ob.Name)
+		}
+	}
+	return append(starting, others...)
+}
+
+func completeWords(req *completionRequest, reply func([]completionItem)) {
+	if req.Buf == nil {
+		reply(nil)
+		return
+	}
+	reply(wordCompletions(req.Buf, req.Prefix, req.Cursor.Line))
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLineWords(t *testing.T) {
+	words := lineWords([]rune("if err := f(x); err != nil {"))
+	if !reflect.DeepEqual(words, []string{"if", "err", "err", "nil"}) {
+		t.Fatalf("unexpected words %q", words)
+	}
+}
+
+func TestWordCompletion(t *testing.T) {
+	path := tempFile(t, "alpha\nalphabet beta\n\nalps\n")
+	other := filepath.Join(filepath.Dir(path), "other.txt")
+	if err := ioutil.WriteFile(other, []byte("alpine\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	h := newHeadless(80, 24, []string{path, other})
+
+	// Closest lines first, then other buffers
+	h.Keys("j j i a l C-n")
+	if !reflect.DeepEqual(completionLabels(), []string{"alphabet", "alps", "alpha", "alpine"}) {
+		t.Fatalf("unexpected completions %q", completionLabels())
+	}
+	expectContents(t, h, "alpha\nalphabet beta\nalphabet\nalps\n")
+	h.Keys("C-n")
+	expectContents(t, h, "alpha\nalphabet beta\nalps\nalps\n")
+	h.Keys("C-p C-p")
+	expectContents(t, h, "alpha\nalphabet beta\nal\nalps\n")
+	if editorCompletion == nil || h.Line(7) != "│ alpine    other.txt  words │" {
+		t.Fatalf("expected the menu open, got %q", h.Line(7))
+	}
+
+	// ESC goes back to what was typed, staying in insert mode
+	h.Keys("C-n ESC")
+	expectContents(t, h, "alpha\nalphabet beta\nal\nalps\n")
+	if editorCompletion != nil || h.Mode() != "insert" {
+		t.Fatalf("expected the menu closed in insert mode, got %s", h.Mode())
+	}
+
+	// Typing on accepts the completion, undone in one step
+	h.Keys("C-p SPC x ESC")
+	expectContents(t, h, "alpha\nalphabet beta\nalpine x\nalps\n")
+	h.Keys("u u")
+	expectContents(t, h, "alpha\nalphabet beta\nalpine\nalps\n")
+	h.Keys("u")
+	expectContents(t, h, "alpha\nalphabet beta\nal\nalps\n")
+
+	// Words typed are indexed as they come
+	h.Keys("G o z e b r a SPC z e n ESC o z e C-n")
+	if !reflect.DeepEqual(completionLabels(), []string{"zebra", "zen"}) {
+		t.Fatalf("unexpected completions %q", completionLabels())
+	}
+	h.Keys("RET")
+	b := h.Buffer()
+	for l, line := range b.Data {
+		if words := lineWords(line); !reflect.DeepEqual(words, wordIndex[b].lines[l]) {
+			t.Fatalf("expected %q indexed on line %d, got %q", words, l, wordIndex[b].lines[l])
+		}
+	}
+}